@@ -0,0 +1,93 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import "errors"
+
+// ErrAppendOrder is returned by Append when the key is not greater than every
+// key in the tree.
+var ErrAppendOrder = errors.New("btree: appended key is not greater than the maximum key")
+
+// Append adds k with value v to t. k must be greater than every key in t;
+// otherwise Append returns ErrAppendOrder and leaves t unchanged.
+//
+// Append is faster than Set for keys in increasing order, because it goes
+// straight down the right edge of the tree without searching. When a node on
+// the way is full, Append splits it unevenly, leaving the left part as full as
+// possible, so a tree built by Append has nearly full nodes rather than the
+// half-full nodes that Set leaves behind it.
+//
+// As a consequence, the nodes on the right edge of a tree that has been
+// appended to may have fewer than degree-1 items (but at least one). The other
+// operations work as usual on such trees.
+func (t *BTree) Append(k Key, v Value) error {
+	if t.Len() > 0 {
+		if max, _ := t.Max(); !t.less(max, k) {
+			return ErrAppendOrder
+		}
+	}
+	if t.root == nil || len(t.root.items) == 0 {
+		t.Set(k, v)
+		return nil
+	}
+	t.root = t.root.mutableFor(t.cow)
+	if len(t.root.items) >= t.maxItems() {
+		sz := t.root.size
+		m, second := t.root.split(t.appendSplitIndex(t.root))
+		t.appended = true
+		oldroot := t.root
+		t.root = t.cow.newNode()
+		t.root.items = append(t.root.items, m)
+		t.root.children = append(t.root.children, oldroot, second)
+		t.root.size = sz
+	}
+	n := t.root
+	for len(n.children) > 0 {
+		n.size++
+		i := len(n.children) - 1
+		if len(n.children[i].items) >= t.maxItems() {
+			child := n.mutableChild(i)
+			m, second := child.split(t.appendSplitIndex(child))
+			t.appended = true
+			n.items = append(n.items, m)
+			n.children = append(n.children, second)
+			i++
+		}
+		n = n.mutableChild(i)
+	}
+	n.items = append(n.items, item{k, v})
+	n.size++
+	t.count++
+	t.version++
+	t.root.updateCum()
+	if t.hasher != nil {
+		t.hasher.hash(t.root)
+	}
+	if t.watchers != nil {
+		t.notifySet(k, v, nil, false)
+	}
+	return nil
+}
+
+// appendSplitIndex returns the index at which Append splits the full node n,
+// leaving as many items as possible in n. The new node of a split leaf is
+// empty until Append adds to it (in a B+ tree, it gets the last item); the new
+// node of a split internal node gets one item, so that it is never empty.
+func (t *BTree) appendSplitIndex(n *node) int {
+	if len(n.children) == 0 {
+		return t.maxItems() - 1
+	}
+	return t.maxItems() - 2
+}
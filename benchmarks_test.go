@@ -18,6 +18,7 @@
 package btree
 
 import (
+	"flag"
 	"fmt"
 	"sort"
 	"testing"
@@ -27,13 +28,57 @@ const benchmarkTreeSize = 10000
 
 var degrees = []int{2, 8, 32, 64}
 
+var countersFlag = flag.Bool("counters", false, "report counts of comparisons, allocations and rebalancing in benchmarks")
+
+// benchCounters returns Counters for the trees of a benchmark if the -counters
+// flag is set, and nil otherwise.
+func benchCounters() *Counters {
+	if !*countersFlag {
+		return nil
+	}
+	return new(Counters)
+}
+
+// resetTimer resets the timer of b, and c if it is not nil, so that the setup
+// of a benchmark isn't counted.
+func resetTimer(b *testing.B, c *Counters) {
+	b.ResetTimer()
+	if c != nil {
+		c.Reset()
+	}
+}
+
+// reportCounts reports the counts of c per operation of b, if c is not nil.
+func reportCounts(b *testing.B, c *Counters) {
+	if c == nil {
+		return
+	}
+	n := float64(b.N)
+	cs := c.Counts()
+	for _, m := range []struct {
+		v    uint64
+		unit string
+	}{
+		{cs.Compares, "compares/op"},
+		{cs.Allocs, "nodes/op"},
+		{cs.Copies, "copies/op"},
+		{cs.Splits, "splits/op"},
+		{cs.Steals, "steals/op"},
+		{cs.Merges, "merges/op"},
+	} {
+		b.ReportMetric(float64(m.v)/n, m.unit)
+	}
+}
+
 func BenchmarkInsert(b *testing.B) {
 	insertP := perm(benchmarkTreeSize)
 	for _, d := range degrees {
 		b.Run(fmt.Sprintf("degree=%d", d), func(b *testing.B) {
+			c := benchCounters()
+			defer reportCounts(b, c)
 			i := 0
 			for i < b.N {
-				tr := New(d, less)
+				tr := NewWithOptions(d, less, Options{Counters: c})
 				for _, m := range insertP {
 					tr.Set(m.Key, m.Value)
 					i++
@@ -46,15 +91,44 @@ func BenchmarkInsert(b *testing.B) {
 	}
 }
 
+func BenchmarkAppend(b *testing.B) {
+	insertP := rang(benchmarkTreeSize)
+	for _, d := range degrees {
+		for _, app := range []bool{false, true} {
+			b.Run(fmt.Sprintf("degree=%d,append=%t", d, app), func(b *testing.B) {
+				c := benchCounters()
+				defer reportCounts(b, c)
+				i := 0
+				for i < b.N {
+					tr := NewWithOptions(d, less, Options{Counters: c})
+					for _, m := range insertP {
+						if app {
+							tr.Append(m.Key, m.Value)
+						} else {
+							tr.Set(m.Key, m.Value)
+						}
+						i++
+						if i >= b.N {
+							return
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkDeleteInsert(b *testing.B) {
 	insertP := perm(benchmarkTreeSize)
 	for _, d := range degrees {
 		b.Run(fmt.Sprintf("degree=%d", d), func(b *testing.B) {
-			tr := New(d, less)
+			c := benchCounters()
+			defer reportCounts(b, c)
+			tr := NewWithOptions(d, less, Options{Counters: c})
 			for _, m := range insertP {
 				tr.Set(m.Key, m.Value)
 			}
-			b.ResetTimer()
+			resetTimer(b, c)
 			for i := 0; i < b.N; i++ {
 				m := insertP[i%benchmarkTreeSize]
 				tr.Delete(m.Key)
@@ -64,6 +138,26 @@ func BenchmarkDeleteInsert(b *testing.B) {
 	}
 }
 
+func BenchmarkNoIndex(b *testing.B) {
+	insertP := perm(benchmarkTreeSize)
+	for _, noIndex := range []bool{false, true} {
+		for _, d := range degrees {
+			b.Run(fmt.Sprintf("noIndex=%t/degree=%d", noIndex, d), func(b *testing.B) {
+				tr := NewWithOptions(d, less, Options{NoIndex: noIndex})
+				for _, m := range insertP {
+					tr.Set(m.Key, m.Value)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					m := insertP[i%benchmarkTreeSize]
+					tr.Delete(m.Key)
+					tr.Set(m.Key, m.Value)
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkDeleteInsertCloneOnce(b *testing.B) {
 	insertP := perm(benchmarkTreeSize)
 	for _, d := range degrees {
@@ -87,11 +181,13 @@ func BenchmarkDeleteInsertCloneEachTime(b *testing.B) {
 	insertP := perm(benchmarkTreeSize)
 	for _, d := range degrees {
 		b.Run(fmt.Sprintf("degree=%d", d), func(b *testing.B) {
-			tr := New(d, less)
+			c := benchCounters()
+			defer reportCounts(b, c)
+			tr := NewWithOptions(d, less, Options{Counters: c})
 			for _, m := range insertP {
 				tr.Set(m.Key, m.Value)
 			}
-			b.ResetTimer()
+			resetTimer(b, c)
 			for i := 0; i < b.N; i++ {
 				tr = tr.Clone()
 				m := insertP[i%benchmarkTreeSize]
@@ -155,6 +251,50 @@ func BenchmarkGet(b *testing.B) {
 	}
 }
 
+func BenchmarkGetHint(b *testing.B) {
+	insertP := perm(benchmarkTreeSize)
+	for _, d := range degrees {
+		c := benchCounters()
+		tr := NewWithOptions(d, less, Options{Counters: c})
+		for _, v := range insertP {
+			tr.Set(v.Key, v.Value)
+		}
+		for _, hint := range []bool{false, true} {
+			b.Run(fmt.Sprintf("degree=%d,hint=%t", d, hint), func(b *testing.B) {
+				resetTimer(b, c)
+				defer reportCounts(b, c)
+				var h Hint
+				for i := 0; i < b.N; i++ {
+					// Sequential keys.
+					k := i % benchmarkTreeSize
+					if hint {
+						tr.GetHint(k, &h)
+					} else {
+						tr.Get(k)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkGetInt64Tree(b *testing.B) {
+	insertP := perm(benchmarkTreeSize)
+	getP := perm(benchmarkTreeSize)
+	for _, d := range degrees {
+		b.Run(fmt.Sprintf("degree=%d", d), func(b *testing.B) {
+			tr := NewInt64Tree(d)
+			for _, v := range insertP {
+				tr.Set(int64(v.Index), v.Value)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.Get(int64(getP[i%benchmarkTreeSize].Index))
+			}
+		})
+	}
+}
+
 func BenchmarkGetWithIndex(b *testing.B) {
 	insertP := perm(benchmarkTreeSize)
 	getP := perm(benchmarkTreeSize)
@@ -180,6 +320,22 @@ func BenchmarkGetWithIndex(b *testing.B) {
 	}
 }
 
+func BenchmarkAt(b *testing.B) {
+	insertP := perm(benchmarkTreeSize)
+	for _, d := range degrees {
+		b.Run(fmt.Sprintf("degree=%d", d), func(b *testing.B) {
+			tr := New(d, less)
+			for _, v := range insertP {
+				tr.Set(v.Key, v.Value)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.At(insertP[i%benchmarkTreeSize].Index)
+			}
+		})
+	}
+}
+
 func BenchmarkGetCloneEachTime(b *testing.B) {
 	insertP := perm(benchmarkTreeSize)
 	getP := perm(benchmarkTreeSize)
@@ -206,6 +362,26 @@ func BenchmarkGetCloneEachTime(b *testing.B) {
 	}
 }
 
+func BenchmarkScan(b *testing.B) {
+	insertP := perm(benchmarkTreeSize)
+	for _, bplus := range []bool{false, true} {
+		for _, d := range degrees {
+			b.Run(fmt.Sprintf("bplus=%t/degree=%d", bplus, d), func(b *testing.B) {
+				tr := NewWithOptions(d, less, Options{BPlus: bplus})
+				for _, m := range insertP {
+					tr.Set(m.Key, m.Value)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					it := tr.BeforeIndex(0)
+					for it.Next() {
+					}
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkFind(b *testing.B) {
 	for _, d := range degrees {
 		var items []item
@@ -0,0 +1,374 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"fmt"
+	"sort"
+)
+
+// In a B+ tree, all items are in the leaves. The items of an internal node are
+// separators, holding only a key: all keys in children[i] are less than
+// items[i].key, which is less than or equal to all keys in children[i+1].
+// Deleting an item may leave a separator whose key is no longer in the tree,
+// which is harmless. The size of an internal node counts only the items in the
+// leaves beneath it.
+//
+// Each leaf points to the next leaf, so iteration can move from leaf to leaf
+// without climbing the tree. Copy-on-write complicates this: when a tree copies
+// a leaf, the previous leaf still points to the original, and updating it would
+// mean copying it and its ancestors too. So a tree trusts a link only if both
+// ends belong to it (that is, have its copy-on-write context). We maintain the
+// invariant that such a link is correct:
+//
+//   - A tree only writes to the links of leaves it owns.
+//   - When it splits a leaf, both halves are its own, and the new one takes the old
+//     one's link.
+//   - When it merges a leaf into its left neighbor, which is always the leaf's
+//     predecessor, the neighbor takes the merged leaf's link.
+//   - A copy of a leaf that the tree doesn't own keeps the original's link,
+//     which points to a node the tree doesn't own either (nodes are never
+//     given a context after they are created).
+//
+// When a link can't be trusted, iteration falls back to the cursor stack. So
+// iterating over a fresh clone is no faster than iterating over a B-tree, but
+// the speed returns as the clone's leaves are rewritten.
+
+// errBPlusUnsupported returns the error for an operation that B+ trees don't support.
+func errBPlusUnsupported(op string) error {
+	return fmt.Errorf("btree: %s is not supported for B+ trees", op)
+}
+
+// childIndexBPlus returns the index of the child of the internal node n whose
+// subtree would hold k.
+func (n *node) childIndexBPlus(k Key, less lessFunc) int {
+	i, found := n.items.find(k, less)
+	if found {
+		i++
+	}
+	return i
+}
+
+// splitLeafBPlus splits the leaf n before index i. Unlike a split in a B-tree,
+// n.items[i] moves to the new node, and only its key moves up as a separator.
+func (n *node) splitLeafBPlus(i int) (item, *node) {
+	next := n.cow.newNode()
+	next.items = append(next.items, n.items[i:]...)
+	n.items.truncate(i)
+	next.next = n.next
+	n.next = next
+	if !n.cow.noIndex {
+		n.size = len(n.items)
+		next.size = len(next.items)
+	}
+	return item{key: next.items[0].key}, next
+}
+
+// insertBPlus is like insert, for B+ trees.
+func (n *node) insertBPlus(m item, maxItems int, less lessFunc, withIndex bool) (old Value, present bool, idx int) {
+	if len(n.children) == 0 {
+		i, found := n.items.find(m.key, less)
+		if found {
+			out := n.items[i]
+			n.items[i] = m
+			return out.value, true, i
+		}
+		n.items.insertAt(i, m)
+		n.size++
+		return old, false, i
+	}
+	i := n.childIndexBPlus(m.key, less)
+	if n.maybeSplitChild(i, maxItems) && !less(m.key, n.items[i].key) {
+		i++
+	}
+	old, present, idx = n.mutableChild(i).insertBPlus(m, maxItems, less, withIndex)
+	if !present {
+		n.size++
+	}
+	if withIndex {
+		idx += n.childrenSize(i)
+	}
+	return old, present, idx
+}
+
+// removeBPlus is like remove, for B+ trees.
+func (n *node) removeBPlus(key Key, minItems int, typ toRemove, less lessFunc) (item, bool) {
+	if len(n.children) == 0 {
+		var out item
+		switch typ {
+		case removeMax:
+			out = n.items.pop()
+		case removeMin:
+			out = n.items.removeAt(0)
+		case removeItem:
+			i, found := n.items.find(key, less)
+			if !found {
+				return item{}, false
+			}
+			out = n.items.removeAt(i)
+		default:
+			panic("invalid type")
+		}
+		n.size--
+		return out, true
+	}
+	var i int
+	switch typ {
+	case removeMax:
+		i = len(n.children) - 1
+	case removeMin:
+		i = 0
+	case removeItem:
+		i = n.childIndexBPlus(key, less)
+	default:
+		panic("invalid type")
+	}
+	if len(n.children[i].items) <= minItems {
+		return n.growChildAndRemoveBPlus(i, key, minItems, typ, less)
+	}
+	out, removed := n.mutableChild(i).removeBPlus(key, minItems, typ, less)
+	if removed {
+		n.size--
+	}
+	return out, removed
+}
+
+// growChildAndRemoveBPlus is like growChildAndRemove, for B+ trees. When the
+// children are leaves, stealing moves an item between them and replaces the
+// separator, and merging drops the separator.
+func (n *node) growChildAndRemoveBPlus(i int, key Key, minItems int, typ toRemove, less lessFunc) (item, bool) {
+	leaves := len(n.children[i].children) == 0
+	if i > 0 && len(n.children[i-1].items) > minItems {
+		// Steal from left child
+		n.cow.countSteal()
+		child := n.mutableChild(i)
+		stealFrom := n.mutableChild(i - 1)
+		if leaves {
+			stolen := stealFrom.items.pop()
+			child.items.insertAt(0, stolen)
+			n.items[i-1] = item{key: stolen.key}
+			stealFrom.size--
+			child.size++
+		} else {
+			child.items.insertAt(0, n.items[i-1])
+			n.items[i-1] = stealFrom.items.pop()
+			c := stealFrom.children.pop()
+			stealFrom.size -= c.size
+			child.children.insertAt(0, c)
+			child.size += c.size
+		}
+	} else if i < len(n.items) && len(n.children[i+1].items) > minItems {
+		// Steal from right child
+		n.cow.countSteal()
+		child := n.mutableChild(i)
+		stealFrom := n.mutableChild(i + 1)
+		if leaves {
+			child.items = append(child.items, stealFrom.items.removeAt(0))
+			n.items[i] = item{key: stealFrom.items[0].key}
+			stealFrom.size--
+			child.size++
+		} else {
+			child.items = append(child.items, n.items[i])
+			n.items[i] = stealFrom.items.removeAt(0)
+			c := stealFrom.children.removeAt(0)
+			stealFrom.size -= c.size
+			child.children = append(child.children, c)
+			child.size += c.size
+		}
+	} else {
+		if i >= len(n.items) {
+			i--
+		}
+		child := n.mutableChild(i)
+		// Merge with right child
+		n.cow.countMerge()
+		sep := n.items.removeAt(i)
+		mergeChild := n.children.removeAt(i + 1)
+		if leaves {
+			child.next = mergeChild.next
+		} else {
+			child.items = append(child.items, sep)
+		}
+		child.items = append(child.items, mergeChild.items...)
+		child.children = append(child.children, mergeChild.children...)
+		if !n.cow.noIndex {
+			child.size = child.computeSize()
+		}
+		n.cow.freeNode(mergeChild)
+	}
+	return n.removeBPlus(key, minItems, typ, less)
+}
+
+// getBPlus is like get, for B+ trees.
+func (n *node) getBPlus(k Key, withIndex bool, less lessFunc) (item, bool, int) {
+	idx := 0
+	for len(n.children) > 0 {
+		i := n.childIndexBPlus(k, less)
+		if withIndex {
+			idx += n.childrenSize(i)
+		}
+		n = n.children[i]
+	}
+	i, found := n.items.find(k, less)
+	if !found {
+		return item{}, false, -1
+	}
+	return n.items[i], true, idx + i
+}
+
+// atBPlus is like at, for B+ trees.
+func (n *node) atBPlus(i int) item {
+	cs := n.cursorStackForIndexBPlus(i, nil)
+	top := cs.top()
+	return top.node.items[top.index]
+}
+
+// cursorStackForKeyBPlus is like cursorStackForKey, for B+ trees. Cursors on
+// internal nodes index children.
+func (n *node) cursorStackForKeyBPlus(k Key, cs cursorStack, less lessFunc, withIndex bool) (cursorStack, bool, int) {
+	idx := 0
+	for len(n.children) > 0 {
+		i := n.childIndexBPlus(k, less)
+		if withIndex {
+			idx += n.childrenSize(i)
+		}
+		cs.push(cursor{n, i})
+		n = n.children[i]
+	}
+	i, found := n.items.find(k, less)
+	cs.push(cursor{n, i})
+	return cs, found, idx + i
+}
+
+// cursorStackForIndexBPlus is like cursorStackForIndex, for B+ trees.
+// It assumes i is in range.
+func (n *node) cursorStackForIndexBPlus(i int, cs cursorStack) cursorStack {
+	for len(n.children) > 0 {
+		// Child j holds indexes from childrenSize(j) up to childrenSize(j+1).
+		j := sort.Search(len(n.children)-1, func(j int) bool { return n.childrenSize(j+1) > i })
+		i -= n.childrenSize(j)
+		cs.push(cursor{n, j})
+		n = n.children[j]
+	}
+	return cs.push(cursor{n, i})
+}
+
+// buildSortedBPlus is like buildSorted, for B+ trees. Each leaf holds between
+// minItems and per items, and the first key of every leaf but the first is a
+// separator in the level above.
+func (c *copyOnWriteContext) buildSortedBPlus(s []item, per, minItems int) *node {
+	var nodes []*node
+	var seps []item
+	var prev *node
+	for _, g := range groupSizes(len(s), per-1, minItems-1) {
+		n := c.newNode()
+		n.items = append(make(items, 0, g), s[:g]...)
+		n.size = g
+		s = s[g:]
+		if prev != nil {
+			prev.next = n
+			seps = append(seps, item{key: n.items[0].key})
+		}
+		nodes = append(nodes, n)
+		prev = n
+	}
+	return c.buildLevels(nodes, seps, per, minItems)
+}
+
+// bplusIter holds what an Iterator over a B+ tree needs to follow leaf links.
+type bplusIter struct {
+	root *node
+	cow  *copyOnWriteContext
+	less lessFunc
+	// If stale is true, only the top cursor is valid: the iterator moved to
+	// its leaf by a link.
+	stale bool
+}
+
+// incBPlus is like inc, for B+ trees.
+func (it *Iterator) incBPlus() bool {
+	it.Index++
+	top := it.cursors.incTop(1)
+	if top.index < len(top.node.items) {
+		return true
+	}
+	// Move to the next leaf, by its link if we can trust it.
+	leaf := top.node
+	if next := leaf.next; next != nil && leaf.cow == it.bplus.cow && next.cow == it.bplus.cow {
+		it.cursors[len(it.cursors)-1] = cursor{next, 0}
+		it.bplus.stale = true
+		return true
+	}
+	it.cursors.incTop(-1)
+	it.rebuildBPlus()
+	it.cursors.incTop(1)
+	// Go up until there is a next child, then down to its first leaf.
+	for {
+		it.cursors.pop()
+		if it.cursors.empty() {
+			return false
+		}
+		if top = it.cursors.incTop(1); top.index < len(top.node.children) {
+			break
+		}
+	}
+	for len(top.node.children) > 0 {
+		top = cursor{top.node.children[top.index], 0}
+		it.cursors.push(top)
+	}
+	return true
+}
+
+// decBPlus is like dec, for B+ trees. It does not use leaf links.
+func (it *Iterator) decBPlus() bool {
+	it.Index--
+	it.rebuildBPlus()
+	top := it.cursors.incTop(-1)
+	if top.index >= 0 {
+		return true
+	}
+	// Go up until there is a previous child, then down to its last leaf.
+	for {
+		it.cursors.pop()
+		if it.cursors.empty() {
+			return false
+		}
+		if top = it.cursors.incTop(-1); top.index >= 0 {
+			break
+		}
+	}
+	for len(top.node.children) > 0 {
+		c := top.node.children[top.index]
+		if len(c.children) > 0 {
+			top = cursor{c, len(c.children) - 1}
+		} else {
+			top = cursor{c, len(c.items) - 1}
+		}
+		it.cursors.push(top)
+	}
+	return true
+}
+
+// rebuildBPlus restores the cursor stack if it is stale, by searching for the
+// key of the current item.
+func (it *Iterator) rebuildBPlus() {
+	if !it.bplus.stale {
+		return
+	}
+	top := it.cursors.top()
+	k := top.node.items[top.index].key
+	it.cursors, _, _ = it.bplus.root.cursorStackForKeyBPlus(k, it.cursors[:0], it.bplus.less, false)
+	it.bplus.stale = false
+}
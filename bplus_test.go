@@ -0,0 +1,203 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newBPlus(degree int) *BTree {
+	return NewWithOptions(degree, less, Options{BPlus: true})
+}
+
+// checkSame checks that the B+ tree bp has the same contents as the B-tree bt,
+// by every means of access.
+func checkSame(t *testing.T, bp, bt *BTree) {
+	t.Helper()
+	checkTree(t, bp)
+	if bp.Len() != bt.Len() {
+		t.Fatalf("Len: got %d, want %d", bp.Len(), bt.Len())
+	}
+	want := all(bt.BeforeIndex(0))
+	if got := all(bp.BeforeIndex(0)); !cmp.Equal(got, want) {
+		t.Fatalf("BeforeIndex(0):\ngot  %v\nwant %v", got, want)
+	}
+	if bt.Len() == 0 {
+		return
+	}
+	if got, want := all(bp.AfterIndex(bp.Len()-1)), all(bt.AfterIndex(bt.Len()-1)); !cmp.Equal(got, want) {
+		t.Fatalf("AfterIndex(Len-1):\ngot  %v\nwant %v", got, want)
+	}
+	for j := 0; j < 20; j++ {
+		i := rand.Intn(bt.Len())
+		if gk, gv := bp.At(i); gk != want[i].Key || gv != want[i].Value {
+			t.Fatalf("At(%d) = %v, %v; want %v", i, gk, gv, want[i])
+		}
+		k := rand.Intn(bt.Len() * 2)
+		gv, gi := bp.GetWithIndex(k)
+		wv, wi := bt.GetWithIndex(k)
+		if gv != wv || gi != wi || bp.Has(k) != bt.Has(k) {
+			t.Fatalf("GetWithIndex(%d) = %v, %d; want %v, %d", k, gv, gi, wv, wi)
+		}
+		if got, want := all(bp.Before(k)), all(bt.Before(k)); !cmp.Equal(got, want) {
+			t.Fatalf("Before(%d):\ngot  %v\nwant %v", k, got, want)
+		}
+		if got, want := all(bp.After(k)), all(bt.After(k)); !cmp.Equal(got, want) {
+			t.Fatalf("After(%d):\ngot  %v\nwant %v", k, got, want)
+		}
+		if got, want := all(bp.BeforeIndex(i)), all(bt.BeforeIndex(i)); !cmp.Equal(got, want) {
+			t.Fatalf("BeforeIndex(%d):\ngot  %v\nwant %v", i, got, want)
+		}
+		if got, want := all(bp.AfterIndex(i)), all(bt.AfterIndex(i)); !cmp.Equal(got, want) {
+			t.Fatalf("AfterIndex(%d):\ngot  %v\nwant %v", i, got, want)
+		}
+	}
+}
+
+func TestBPlus(t *testing.T) {
+	const size = 2000
+	for _, degree := range []int{2, 3, 8} {
+		bp, bt := newBPlus(degree), New(degree, less)
+		for _, m := range perm(size) {
+			_, gp, gi := bp.SetWithIndex(m.Key, m.Value)
+			_, wp, wi := bt.SetWithIndex(m.Key, m.Value)
+			if gp != wp || gi != wi {
+				t.Fatalf("SetWithIndex(%v) = %t, %d; want %t, %d", m.Key, gp, gi, wp, wi)
+			}
+		}
+		checkSame(t, bp, bt)
+		for i := 0; i < 5*size; i++ {
+			k := rand.Intn(size)
+			switch rand.Intn(5) {
+			case 0, 1:
+				bp.Set(k, -k)
+				bt.Set(k, -k)
+			case 2:
+				gv, gok := bp.Delete(k)
+				wv, wok := bt.Delete(k)
+				if gv != wv || gok != wok {
+					t.Fatalf("Delete(%d) = %v, %t; want %v, %t", k, gv, gok, wv, wok)
+				}
+			case 3:
+				if gk, _ := bp.DeleteMin(); gk != func() Key { k, _ := bt.DeleteMin(); return k }() {
+					t.Fatalf("DeleteMin: got %v", gk)
+				}
+			case 4:
+				if gk, _ := bp.DeleteMax(); gk != func() Key { k, _ := bt.DeleteMax(); return k }() {
+					t.Fatalf("DeleteMax: got %v", gk)
+				}
+			}
+			if i%1000 == 0 {
+				checkSame(t, bp, bt)
+			}
+		}
+		checkSame(t, bp, bt)
+		for bt.Len() > 0 {
+			k, _ := bt.DeleteMin()
+			if _, ok := bp.Delete(k); !ok {
+				t.Fatalf("Delete(%v) failed", k)
+			}
+		}
+		checkSame(t, bp, bt)
+	}
+}
+
+func TestBPlusClone(t *testing.T) {
+	bp, bt := newBPlus(3), New(3, less)
+	for _, m := range perm(1000) {
+		bp.Set(m.Key, m.Value)
+		bt.Set(m.Key, m.Value)
+	}
+	// Diverge a chain of clones, writing to each one, and check that every
+	// tree still sees its own contents.
+	var bps, bts []*BTree
+	for i := 0; i < 5; i++ {
+		bps = append(bps, bp)
+		bts = append(bts, bt)
+		bp, bt = bp.Clone(), bt.Clone()
+		for j := 0; j < 200; j++ {
+			k := rand.Intn(1200)
+			if rand.Intn(2) == 0 {
+				bp.Set(k, i)
+				bt.Set(k, i)
+			} else {
+				bp.Delete(k)
+				bt.Delete(k)
+			}
+		}
+	}
+	bps = append(bps, bp)
+	bts = append(bts, bt)
+	for i := range bps {
+		checkSame(t, bps[i], bts[i])
+	}
+	// Writing to the original must not disturb the clones.
+	for k := 0; k < 1000; k += 2 {
+		bps[0].Delete(k)
+		bts[0].Delete(k)
+	}
+	for i := range bps {
+		checkSame(t, bps[i], bts[i])
+	}
+}
+
+func TestBPlusDecode(t *testing.T) {
+	bt := New(4, less)
+	for _, m := range perm(500) {
+		bt.Set(m.Key, m.Value)
+	}
+	data, err := json.Marshal(bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := newBPlus(4)
+	bp.DecodeJSONWith(decodeJSONInt, decodeJSONInt)
+	if err := json.Unmarshal(data, bp); err != nil {
+		t.Fatal(err)
+	}
+	checkSame(t, bp, bt)
+	// Keep writing to the decoded tree.
+	for k := 0; k < 500; k += 3 {
+		bp.Delete(k)
+		bt.Delete(k)
+	}
+	checkSame(t, bp, bt)
+}
+
+func decodeJSONInt(m json.RawMessage) (interface{}, error) {
+	var x int
+	err := json.Unmarshal(m, &x)
+	return x, err
+}
+
+func TestBPlusUnsupported(t *testing.T) {
+	bp := newBPlus(4)
+	bp.Set(1, 1)
+	if err := Freeze(&bytes.Buffer{}, bp, IntCodec, IntCodec); err == nil {
+		t.Error("Freeze: got nil, want error")
+	}
+	if err := NewSnapshotter(IntCodec, IntCodec).Write(&bytes.Buffer{}, bp); err == nil {
+		t.Error("Snapshotter.Write: got nil, want error")
+	}
+	bp.EnableHashing(IntCodec, IntCodec)
+	if _, err := bp.Prove(1); err == nil {
+		t.Error("Prove: got nil, want error")
+	}
+}
@@ -21,7 +21,10 @@
 // added.
 //
 // btree implements an in-memory B-Tree for use as an ordered data structure.
-// It is not meant for persistent storage solutions.
+// For a tree stored in a file, see DiskBTree. An in-memory tree can also be
+// saved and restored with WriteTo and ReadFrom, saved incrementally with a
+// Snapshotter and Restorer, or written with Freeze for querying in place as a
+// FrozenTree.
 //
 // It has a flatter structure than an equivalent red-black or other binary tree,
 // which in some cases yields better memory usage and/or performance.
@@ -47,9 +50,9 @@
 package btree
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"sort"
-	"sync"
 )
 
 // Key represents a key into the tree.
@@ -81,10 +84,54 @@ func New(degree int, less func(interface{}, interface{}) bool) *BTree {
 	return &BTree{
 		degree: degree,
 		less:   less,
-		cow:    &copyOnWriteContext{},
+		cow:    &copyOnWriteContext{freelist: defaultFreeList},
 	}
 }
 
+// Options configure a BTree created by NewWithOptions.
+type Options struct {
+	// BPlus makes the tree a B+ tree, which stores all items in its leaves and
+	// links each leaf to the next, making sequential scans faster at a small
+	// cost in memory for the separator keys in internal nodes.
+	//
+	// B+ trees support all BTree methods, but not incremental snapshots (see
+	// Snapshotter), Freeze, or Merkle proofs (see Prove).
+	BPlus bool
+
+	// FreeList is where the tree gets nodes from and returns them to. If nil,
+	// the tree uses a free list of size DefaultFreeListSize shared by all such
+	// trees. The tree's clones share its free list, so if it is private (see
+	// NewPrivateFreeList), the tree and its clones must not be written
+	// concurrently.
+	FreeList *FreeList
+
+	// Counters, if not nil, count comparisons, node allocations and rebalancing
+	// done by the tree and its clones.
+	Counters *Counters
+
+	// NoIndex makes the tree skip keeping the subtree sizes that positional
+	// access needs, which makes writes faster. The methods that take
+	// or return an index (At, GetWithIndex, SetWithIndex, BeforeIndex and
+	// AfterIndex) panic, and the Index field of an Iterator returned by Before
+	// or After is meaningless.
+	NoIndex bool
+}
+
+// NewWithOptions is like New, but configures the tree with opts.
+func NewWithOptions(degree int, less func(interface{}, interface{}) bool, opts Options) *BTree {
+	t := New(degree, less)
+	t.cow.bplus = opts.BPlus
+	t.cow.noIndex = opts.NoIndex
+	if opts.FreeList != nil {
+		t.cow.freelist = opts.FreeList
+	}
+	if opts.Counters != nil {
+		t.cow.counters = opts.Counters
+		t.less = opts.Counters.countingLess(less)
+	}
+	return t
+}
+
 // items stores items in a node.
 type items []item
 
@@ -193,12 +240,22 @@ func (s *children) truncate(index int) {
 type node struct {
 	items    items
 	children children
-	size     int // number of items in the subtree: len(items) + sum over i of children[i].size
+	size     int // number of items in the subtree: len(items) + sum over i of children[i].size; unused if cow.noIndex
 	cow      *copyOnWriteContext
+	hash     *[sha256.Size]byte // Merkle hash of the subtree, or nil if not computed; see merkle.go
+	sum      *rangeDigest       // digest of the subtree's items, computed with hash; see reconcile.go
+	next     *node              // in a B+ tree, the next leaf; see bplus.go
+	// cum holds cumulative child sizes: cum[i] is the sum of children[j].size
+	// for j <= i. It is empty while a write is modifying n; see updateCum.
+	cum []int
 }
 
 func (n *node) computeSize() int {
 	sz := len(n.items)
+	if n.cow.bplus && len(n.children) > 0 {
+		// The items of an internal B+ tree node are only separators.
+		sz = 0
+	}
 	for _, c := range n.children {
 		sz += c.size
 	}
@@ -206,16 +263,33 @@ func (n *node) computeSize() int {
 }
 
 func (n *node) checkSize() {
+	if n.cow.noIndex {
+		return
+	}
 	sz := n.computeSize()
 	if n.size != sz {
 		panic(fmt.Sprintf("n.size = %d, computed size = %d", n.size, sz))
 	}
+	if len(n.cum) != len(n.children) {
+		panic(fmt.Sprintf("len(n.cum) = %d, len(n.children) = %d", len(n.cum), len(n.children)))
+	}
+	sz = 0
+	for i, c := range n.children {
+		sz += c.size
+		if n.cum[i] != sz {
+			panic(fmt.Sprintf("n.cum[%d] = %d, computed %d", i, n.cum[i], sz))
+		}
+	}
 }
 
 func (n *node) mutableFor(cow *copyOnWriteContext) *node {
 	if n.cow == cow {
+		// The caller is about to modify n, so its hash and cum will be stale.
+		n.hash, n.sum = nil, nil
+		n.cum = n.cum[:0]
 		return n
 	}
+	cow.countCopy()
 	out := cow.newNode()
 	if cap(out.items) >= len(n.items) {
 		out.items = out.items[:len(n.items)]
@@ -231,6 +305,7 @@ func (n *node) mutableFor(cow *copyOnWriteContext) *node {
 	}
 	copy(out.children, n.children)
 	out.size = n.size
+	out.next = n.next
 	return out
 }
 
@@ -244,6 +319,10 @@ func (n *node) mutableChild(i int) *node {
 // and this function returns the item that existed at that index and a new node
 // containing all items/children after it.
 func (n *node) split(i int) (item, *node) {
+	n.cow.countSplit()
+	if n.cow.bplus && len(n.children) == 0 {
+		return n.splitLeafBPlus(i)
+	}
 	item := n.items[i]
 	next := n.cow.newNode()
 	next.items = append(next.items, n.items[i+1:]...)
@@ -252,11 +331,94 @@ func (n *node) split(i int) (item, *node) {
 		next.children = append(next.children, n.children[i+1:]...)
 		n.children.truncate(i + 1)
 	}
-	n.size = n.computeSize()
-	next.size = next.computeSize()
+	if !n.cow.noIndex {
+		n.size = n.computeSize()
+		next.size = next.computeSize()
+	}
 	return item, next
 }
 
+// buildSorted returns the root of a new subtree holding the items of s, which
+// must be in ascending order with no duplicate keys, or nil if s is empty.
+// Nodes other than the root hold between minItems and per items, distributed
+// as evenly as possible. It takes time linear in len(s).
+func (c *copyOnWriteContext) buildSorted(s []item, per, minItems int) *node {
+	if len(s) == 0 {
+		return nil
+	}
+	if c.bplus {
+		return c.buildSortedBPlus(s, per, minItems)
+	}
+	// Build the leaves. A leaf of g units holds g-1 items; the item between two
+	// leaves becomes a separator in the level above.
+	var nodes []*node
+	var seps []item
+	sizes := groupSizes(len(s)+1, per, minItems)
+	for i, g := range sizes {
+		n := c.newNode()
+		n.items = append(make(items, 0, g-1), s[:g-1]...)
+		n.size = len(n.items)
+		s = s[g-1:]
+		nodes = append(nodes, n)
+		if i < len(sizes)-1 {
+			seps = append(seps, s[0])
+			s = s[1:]
+		}
+	}
+	return c.buildLevels(nodes, seps, per, minItems)
+}
+
+// buildLevels builds the levels of a tree above nodes, where seps holds the
+// separators between them, and returns the root.
+func (c *copyOnWriteContext) buildLevels(nodes []*node, seps []item, per, minItems int) *node {
+	// Build each level from the one below it. A node of g units has g children and
+	// the g-1 separators between them; the separator between two groups moves up.
+	for len(nodes) > 1 {
+		var parents []*node
+		var up []item
+		sizes := groupSizes(len(nodes), per, minItems)
+		for i, g := range sizes {
+			n := c.newNode()
+			n.children = append(make(children, 0, g), nodes[:g]...)
+			n.items = append(make(items, 0, g-1), seps[:g-1]...)
+			n.size = n.computeSize()
+			n.updateCum()
+			nodes, seps = nodes[g:], seps[g-1:]
+			parents = append(parents, n)
+			if i < len(sizes)-1 {
+				up = append(up, seps[0])
+				seps = seps[1:]
+			}
+		}
+		nodes, seps = parents, up
+	}
+	return nodes[0]
+}
+
+// groupSizes divides x units into as few groups as possible of at most per+1
+// units each, and returns the size of each group. If there is more than one
+// group, each has at least minItems+1 units; if that is not possible with
+// groups of at most per+1, the groups get larger, up to 2*(minItems+1).
+// The groups are as even as possible.
+func groupSizes(x, per, minItems int) []int {
+	k := (x + per) / (per + 1)
+	if k > 1 && x/k < minItems+1 {
+		// per is too small to divide x evenly without underfilling the groups.
+		k = x / (minItems + 1)
+	}
+	if k < 1 {
+		k = 1
+	}
+	sizes := make([]int, k)
+	for i := range sizes {
+		sizes[i] = x / k
+		if i < x%k {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
+
 // maybeSplitChild checks if a child should be split, and if so splits it.
 // Returns whether or not a split occurred.
 func (n *node) maybeSplitChild(i, maxItems int) bool {
@@ -323,7 +485,11 @@ func (n *node) insert(m item, maxItems int, less lessFunc, withIndex bool) (old
 func (n *node) get(k Key, withIndex bool, less lessFunc) (item, bool, int) {
 	i, found := n.items.find(k, less)
 	if found {
-		return n.items[i], true, n.itemIndex(i)
+		idx := 0
+		if withIndex {
+			idx = n.itemIndex(i)
+		}
+		return n.items[i], true, idx
 	}
 	if len(n.children) > 0 {
 		m, found, idx := n.children[i].get(k, withIndex, less)
@@ -347,30 +513,74 @@ func (n *node) itemIndex(i int) int {
 
 // Returns the size of the non-leaf node up to but not including child i.
 func (n *node) partialSize(i int) int {
-	var sz int
-	for j, c := range n.children {
-		if j == i {
-			break
+	return n.childrenSize(i) + i
+}
+
+// childrenSize returns the number of items in the subtrees of the first i
+// children of n.
+func (n *node) childrenSize(i int) int {
+	if len(n.cum) != len(n.children) {
+		// n is being written.
+		var sz int
+		for _, c := range n.children[:i] {
+			sz += c.size
 		}
-		sz += c.size + 1
+		return sz
 	}
-	return sz
+	if i == 0 {
+		return 0
+	}
+	return n.cum[i-1]
 }
 
-// cursorStackForKey returns a stack of cursors for the key, along with whether the key was found and the index.
-func (n *node) cursorStackForKey(k Key, cs cursorStack, less lessFunc) (cursorStack, bool, int) {
+// updateCum recomputes cum for n and the nodes beneath it that were modified
+// by the current write, which are those whose cum is empty. Like hashes (see
+// merkle.go), cum is kept stale during a write because sizes change on the way
+// back up from a recursive insert or remove; each write calls updateCum on the
+// root when it is done. The nodes it updates belong to the writing tree.
+func (n *node) updateCum() {
+	if n == nil || n.cow.noIndex || len(n.cum) == len(n.children) {
+		return
+	}
+	var sz int
+	for _, c := range n.children {
+		c.updateCum()
+		sz += c.size
+		n.cum = append(n.cum, sz)
+	}
+}
+
+// locate finds index i among the items and children of the internal node n.
+// If the index is that of an item, it returns the item's index and true.
+// Otherwise it returns the index of the child whose subtree holds it, false,
+// and the index relative to that subtree.
+func (n *node) locate(i int) (int, bool, int) {
+	// Item j is at index childrenSize(j+1) + j, which increases with j.
+	j := sort.Search(len(n.items), func(j int) bool { return n.childrenSize(j+1)+j >= i })
+	if j < len(n.items) && n.childrenSize(j+1)+j == i {
+		return j, true, 0
+	}
+	return j, false, i - n.partialSize(j)
+}
+
+// cursorStackForKey returns a stack of cursors for the key, along with whether the key was found and,
+// if withIndex is true, the index.
+func (n *node) cursorStackForKey(k Key, cs cursorStack, less lessFunc, withIndex bool) (cursorStack, bool, int) {
 	i, found := n.items.find(k, less)
 	cs.push(cursor{n, i})
 	idx := i
 	if found {
-		if len(n.children) > 0 {
+		if withIndex && len(n.children) > 0 {
 			idx = n.partialSize(i+1) - 1
 		}
 		return cs, true, idx
 	}
 	if len(n.children) > 0 {
-		cs, found, idx := n.children[i].cursorStackForKey(k, cs, less)
-		return cs, found, idx + n.partialSize(i)
+		cs, found, idx := n.children[i].cursorStackForKey(k, cs, less, withIndex)
+		if withIndex {
+			idx += n.partialSize(i)
+		}
+		return cs, found, idx
 	}
 	return cs, false, idx
 }
@@ -381,17 +591,11 @@ func (n *node) at(i int) item {
 	if len(n.children) == 0 {
 		return n.items[i]
 	}
-	for j, c := range n.children {
-		if i < c.size {
-			return c.at(i)
-		}
-		i -= c.size
-		if i == 0 {
-			return n.items[j]
-		}
-		i--
+	j, isItem, ci := n.locate(i)
+	if isItem {
+		return n.items[j]
 	}
-	panic("impossible")
+	return n.children[j].at(ci)
 }
 
 // cursorStackForIndex returns a stack of cursors for the index.
@@ -400,17 +604,11 @@ func (n *node) cursorStackForIndex(i int, cs cursorStack) cursorStack {
 	if len(n.children) == 0 {
 		return cs.push(cursor{n, i})
 	}
-	for j, c := range n.children {
-		if i < c.size {
-			return c.cursorStackForIndex(i, cs.push(cursor{n, j}))
-		}
-		i -= c.size
-		if i == 0 {
-			return cs.push(cursor{n, j})
-		}
-		i--
+	j, isItem, ci := n.locate(i)
+	if isItem {
+		return cs.push(cursor{n, j})
 	}
-	panic("impossible")
+	return n.children[j].cursorStackForIndex(ci, cs.push(cursor{n, j}))
 }
 
 // toRemove details what item to remove in a node.remove call.
@@ -502,6 +700,7 @@ func (n *node) remove(key Key, minItems int, typ toRemove, less lessFunc) (item,
 func (n *node) growChildAndRemove(i int, key Key, minItems int, typ toRemove, less lessFunc) (item, bool) {
 	if i > 0 && len(n.children[i-1].items) > minItems {
 		// Steal from left child
+		n.cow.countSteal()
 		child := n.mutableChild(i)
 		stealFrom := n.mutableChild(i - 1)
 		stolenItem := stealFrom.items.pop()
@@ -517,6 +716,7 @@ func (n *node) growChildAndRemove(i int, key Key, minItems int, typ toRemove, le
 		}
 	} else if i < len(n.items) && len(n.children[i+1].items) > minItems {
 		// steal from right child
+		n.cow.countSteal()
 		child := n.mutableChild(i)
 		stealFrom := n.mutableChild(i + 1)
 		stolenItem := stealFrom.items.removeAt(0)
@@ -536,12 +736,15 @@ func (n *node) growChildAndRemove(i int, key Key, minItems int, typ toRemove, le
 		}
 		child := n.mutableChild(i)
 		// merge with right child
+		n.cow.countMerge()
 		mergeItem := n.items.removeAt(i)
 		mergeChild := n.children.removeAt(i + 1)
 		child.items = append(child.items, mergeItem)
 		child.items = append(child.items, mergeChild.items...)
 		child.children = append(child.children, mergeChild.children...)
-		child.size = child.computeSize()
+		if !n.cow.noIndex {
+			child.size = child.computeSize()
+		}
 		n.cow.freeNode(mergeChild)
 	}
 	return n.remove(key, minItems, typ, less)
@@ -559,6 +762,20 @@ type BTree struct {
 	less   lessFunc
 	root   *node
 	cow    *copyOnWriteContext
+	// watchers is nil unless Watch has been called, so that
+	// unwatched trees pay only a nil check per write.
+	watchers []*watcher
+	// jsonDecoders is set by DecodeJSONWith.
+	jsonDecoders *jsonDecoders
+	// hasher is set by EnableHashing.
+	hasher *hasher
+	// count is the number of items, if cow.noIndex.
+	count int
+	// version counts writes, so that a Hint can tell whether its path is current.
+	version uint64
+	// appended is set once Append has split a node unevenly, which can leave
+	// nodes on the right edge underfull; Verify allows for them.
+	appended bool
 }
 
 // copyOnWriteContext pointers determine node ownership. A tree with a cow
@@ -575,11 +792,21 @@ type BTree struct {
 // tree's context, that node is modifiable in place.  Children of that node may
 // not share context, but before we descend into them, we'll make a mutable
 // copy.
-type copyOnWriteContext struct{ byte } // non-empty, because empty structs may have same addr
+//
+// Since every tree has its own context, the context also holds settings that
+// the tree's nodes need to know about. Clones copy them.
+type copyOnWriteContext struct {
+	bplus    bool      // the tree is a B+ tree; see bplus.go
+	noIndex  bool      // don't maintain sizes; see Options.NoIndex
+	freelist *FreeList // where nodes come from and go to
+	counters *Counters // nil unless Options.Counters was set
+}
 
 // Clone clones the btree, lazily.  Clone should not be called concurrently,
 // but the original tree (t) and the new tree (t2) can be used concurrently
-// once the Clone call completes.
+// once the Clone call completes. The exception is a tree with a private free
+// list (see NewPrivateFreeList), which t2 shares: t and t2 may then be read
+// concurrently, but not written.
 //
 // The internal tree structure of b is marked read-only and shared between t and
 // t2.  Writes to both t and t2 use copy-on-write logic, creating new nodes
@@ -598,9 +825,23 @@ func (t *BTree) Clone() *BTree {
 	out := *t
 	t.cow = &cow1
 	out.cow = &cow2
+	// Watches belong to the tree they were registered on.
+	out.watchers = nil
+	if t.hasher != nil {
+		// The clone needs its own scratch buffer, since it may be written
+		// concurrently with t.
+		out.hasher = &hasher{kc: t.hasher.kc, vc: t.hasher.vc}
+	}
 	return &out
 }
 
+// freeze makes the nodes currently in t read-only by giving t a new
+// copy-on-write context. Later writes to t will copy them.
+func (t *BTree) freeze() {
+	cow := *t.cow
+	t.cow = &cow
+}
+
 // maxItems returns the max number of items to allow per node.
 func (t *BTree) maxItems() int {
 	return t.degree*2 - 1
@@ -612,10 +853,9 @@ func (t *BTree) minItems() int {
 	return t.degree - 1
 }
 
-var nodePool = sync.Pool{New: func() interface{} { return new(node) }}
-
 func (c *copyOnWriteContext) newNode() *node {
-	n := nodePool.Get().(*node)
+	c.countAlloc()
+	n := c.freelist.newNode()
 	n.cow = c
 	return n
 }
@@ -626,7 +866,10 @@ func (c *copyOnWriteContext) freeNode(n *node) {
 		n.items.truncate(0)
 		n.children.truncate(0)
 		n.cow = nil
-		nodePool.Put(n)
+		n.hash, n.sum = nil, nil
+		n.next = nil
+		n.cum = n.cum[:0]
+		c.freelist.freeNode(n)
 	}
 }
 
@@ -640,6 +883,7 @@ func (t *BTree) Set(k Key, v Value) (old Value, present bool) {
 }
 
 func (t *BTree) SetWithIndex(k Key, v Value) (old Value, present bool, index int) {
+	t.checkIndexed("SetWithIndex")
 	return t.set(k, v, true)
 }
 
@@ -648,20 +892,35 @@ func (t *BTree) set(k Key, v Value, withIndex bool) (old Value, present bool, id
 		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, item{k, v})
 		t.root.size = 1
-		return old, false, 0
+	} else {
+		t.root = t.root.mutableFor(t.cow)
+		if len(t.root.items) >= t.maxItems() {
+			sz := t.root.size
+			item2, second := t.root.split(t.maxItems() / 2)
+			oldroot := t.root
+			t.root = t.cow.newNode()
+			t.root.items = append(t.root.items, item2)
+			t.root.children = append(t.root.children, oldroot, second)
+			t.root.size = sz
+		}
+		if t.cow.bplus {
+			old, present, idx = t.root.insertBPlus(item{k, v}, t.maxItems(), t.less, withIndex)
+		} else {
+			old, present, idx = t.root.insert(item{k, v}, t.maxItems(), t.less, withIndex)
+		}
 	}
-	t.root = t.root.mutableFor(t.cow)
-	if len(t.root.items) >= t.maxItems() {
-		sz := t.root.size
-		item2, second := t.root.split(t.maxItems() / 2)
-		oldroot := t.root
-		t.root = t.cow.newNode()
-		t.root.items = append(t.root.items, item2)
-		t.root.children = append(t.root.children, oldroot, second)
-		t.root.size = sz
+	if !present {
+		t.count++
 	}
-
-	return t.root.insert(item{k, v}, t.maxItems(), t.less, withIndex)
+	t.version++
+	t.root.updateCum()
+	if t.hasher != nil {
+		t.hasher.hash(t.root)
+	}
+	if t.watchers != nil {
+		t.notifySet(k, v, old, present)
+	}
+	return old, present, idx
 }
 
 // Delete removes the item with the given key, returning its value. The second return value
@@ -690,12 +949,29 @@ func (t *BTree) deleteItem(key Key, typ toRemove) (item, bool) {
 		return item{}, false
 	}
 	t.root = t.root.mutableFor(t.cow)
-	out, removed := t.root.remove(key, t.minItems(), typ, t.less)
+	var out item
+	var removed bool
+	if t.cow.bplus {
+		out, removed = t.root.removeBPlus(key, t.minItems(), typ, t.less)
+	} else {
+		out, removed = t.root.remove(key, t.minItems(), typ, t.less)
+	}
 	if len(t.root.items) == 0 && len(t.root.children) > 0 {
 		oldroot := t.root
 		t.root = t.root.children[0]
 		t.cow.freeNode(oldroot)
 	}
+	if removed {
+		t.count--
+	}
+	t.version++
+	t.root.updateCum()
+	if t.hasher != nil {
+		t.hasher.hash(t.root)
+	}
+	if removed && t.watchers != nil {
+		t.notify(Event{Kind: EventDelete, Key: out.key, Old: out.value})
+	}
 	return out, removed
 }
 
@@ -708,7 +984,7 @@ func (t *BTree) Get(k Key) Value {
 	if t.root == nil {
 		return z
 	}
-	item, ok, _ := t.root.get(k, false, t.less)
+	item, ok, _ := t.get(k, false)
 	if !ok {
 		return z
 	}
@@ -718,21 +994,35 @@ func (t *BTree) Get(k Key) Value {
 // GetWithIndex returns the value and index for the given key in the tree, or the
 // zero value and -1 if the key is not in the tree.
 func (t *BTree) GetWithIndex(k Key) (Value, int) {
+	t.checkIndexed("GetWithIndex")
 	var z Value
 	if t.root == nil {
 		return z, -1
 	}
-	item, _, index := t.root.get(k, true, t.less)
+	item, _, index := t.get(k, true)
 	return item.value, index
 }
 
+func (t *BTree) get(k Key, withIndex bool) (item, bool, int) {
+	if t.cow.bplus {
+		return t.root.getBPlus(k, withIndex, t.less)
+	}
+	return t.root.get(k, withIndex, t.less)
+}
+
 // At returns the key and value at index i. The minimum item has index 0.
 // If i is outside the range [0, t.Len()), At panics.
 func (t *BTree) At(i int) (Key, Value) {
+	t.checkIndexed("At")
 	if i < 0 || i >= t.Len() {
 		panic("btree: index out of range")
 	}
-	item := t.root.at(i)
+	var item item
+	if t.cow.bplus {
+		item = t.root.atBPlus(i)
+	} else {
+		item = t.root.at(i)
+	}
 	return item.key, item.value
 }
 
@@ -741,7 +1031,7 @@ func (t *BTree) Has(k Key) bool {
 	if t.root == nil {
 		return false
 	}
-	_, ok, _ := t.root.get(k, false, t.less)
+	_, ok, _ := t.get(k, false)
 	return ok
 }
 
@@ -784,6 +1074,9 @@ func (t *BTree) Max() (Key, Value) {
 
 // Len returns the number of items currently in the tree.
 func (t *BTree) Len() int {
+	if t.cow.noIndex {
+		return t.count
+	}
 	if t.root == nil {
 		return 0
 	}
@@ -797,8 +1090,7 @@ func (t *BTree) Before(k Key) *Iterator {
 	if t.root == nil {
 		return &Iterator{}
 	}
-	var cs cursorStack
-	cs, found, idx := t.root.cursorStackForKey(k, cs, t.less)
+	cs, found, idx := t.cursorStackForKey(k)
 	// If we found the key, the cursor stack is pointing to it. Since that is
 	// the first element we want, don't advance the iterator on the initial call to Next.
 	// If we haven't found the key, then the top of the cursor stack is either pointing at the
@@ -813,12 +1105,12 @@ func (t *BTree) Before(k Key) *Iterator {
 	} else {
 		idx--
 	}
-	return &Iterator{
+	return t.newIterator(&Iterator{
 		cursors:    cs,
 		stay:       stay,
 		descending: false,
 		Index:      idx,
-	}
+	})
 }
 
 // After returns an iterator positioned just after k. After the first call to Next,
@@ -828,18 +1120,17 @@ func (t *BTree) After(k Key) *Iterator {
 	if t.root == nil {
 		return &Iterator{}
 	}
-	var cs cursorStack
-	cs, found, idx := t.root.cursorStackForKey(k, cs, t.less)
+	cs, found, idx := t.cursorStackForKey(k)
 	// If we found the key, the cursor stack is pointing to it. Since that is
 	// the first element we want, don't advance the iterator on the initial call to Next.
 	// If we haven't found the key, the the cursor stack is pointing just after the first item,
 	// so we do want to advance.
-	return &Iterator{
+	return t.newIterator(&Iterator{
 		cursors:    cs,
 		stay:       found,
 		descending: true,
 		Index:      idx,
-	}
+	})
 }
 
 // BeforeIndex returns an iterator positioned just before the item with the given index.
@@ -847,6 +1138,7 @@ func (t *BTree) After(k Key) *Iterator {
 // If i is not in the range [0, tr.Len()], BeforeIndex panics.
 // Note that it is not an error to provide an index of tr.Len().
 func (t *BTree) BeforeIndex(i int) *Iterator {
+	t.checkIndexed("BeforeIndex")
 	return t.indexIterator(i, false)
 }
 
@@ -855,6 +1147,7 @@ func (t *BTree) BeforeIndex(i int) *Iterator {
 // If i is not in the range [0, tr.Len()], AfterIndex panics.
 // Note that it is not an error to provide an index of tr.Len().
 func (t *BTree) AfterIndex(i int) *Iterator {
+	t.checkIndexed("AfterIndex")
 	return t.indexIterator(i, true)
 }
 
@@ -866,12 +1159,55 @@ func (t *BTree) indexIterator(i int, descending bool) *Iterator {
 		return &Iterator{}
 	}
 	var cs cursorStack
-	return &Iterator{
-		cursors:    t.root.cursorStackForIndex(i, cs),
+	if t.cow.bplus {
+		cs = t.root.cursorStackForIndexBPlus(i, cs)
+	} else {
+		cs = t.root.cursorStackForIndex(i, cs)
+	}
+	return t.newIterator(&Iterator{
+		cursors:    cs,
 		stay:       true,
 		descending: descending,
 		Index:      i,
+	})
+}
+
+func (t *BTree) cursorStackForKey(k Key) (cursorStack, bool, int) {
+	var cs cursorStack
+	if t.cow.bplus {
+		return t.root.cursorStackForKeyBPlus(k, cs, t.less, !t.cow.noIndex)
+	}
+	return t.root.cursorStackForKey(k, cs, t.less, !t.cow.noIndex)
+}
+
+// first returns an iterator positioned before the minimum item. Unlike
+// BeforeIndex(0), it works for trees without an index.
+func (t *BTree) first() *Iterator {
+	if t.Len() == 0 {
+		return &Iterator{}
+	}
+	var cs cursorStack
+	n := t.root
+	for len(n.children) > 0 {
+		cs.push(cursor{n, 0})
+		n = n.children[0]
+	}
+	return t.newIterator(&Iterator{cursors: cs.push(cursor{n, 0}), stay: true})
+}
+
+// checkIndexed panics if t was created with NoIndex.
+func (t *BTree) checkIndexed(method string) {
+	if t.cow.noIndex {
+		panic("btree: " + method + " called on a tree created with NoIndex")
+	}
+}
+
+// newIterator completes it with what it needs to know about t.
+func (t *BTree) newIterator(it *Iterator) *Iterator {
+	if t.cow.bplus {
+		it.bplus = &bplusIter{root: t.root, cow: t.cow, less: t.less}
 	}
+	return it
 }
 
 // An Iterator supports traversing the items in the tree.
@@ -885,6 +1221,7 @@ type Iterator struct {
 	cursors    cursorStack // stack of nodes with indices; last element is the top
 	stay       bool        // don't do anything on the first call to Next.
 	descending bool        // traverse the items in descending order
+	bplus      *bplusIter  // non-nil for B+ trees
 }
 
 // Next advances the Iterator to the next item in the tree. If Next returns true,
@@ -900,6 +1237,10 @@ func (it *Iterator) Next() bool {
 	case it.stay:
 		it.stay = false
 		more = true
+	case it.bplus != nil && it.descending:
+		more = it.decBPlus()
+	case it.bplus != nil:
+		more = it.incBPlus()
 	case it.descending:
 		more = it.dec()
 	default:
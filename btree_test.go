@@ -16,11 +16,14 @@
 package btree
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math/rand"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -420,3 +423,247 @@ func TestCloneConcurrentOperations(t *testing.T) {
 }
 
 func less(a, b interface{}) bool { return a.(int) < b.(int) }
+
+// checkTree checks the structural invariants of tr; see Verify.
+func checkTree(t *testing.T, tr *BTree) {
+	t.Helper()
+	if err := tr.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoIndex(t *testing.T) {
+	for _, bplus := range []bool{false, true} {
+		tr := NewWithOptions(3, less, Options{NoIndex: true, BPlus: bplus})
+		for _, m := range perm(1000) {
+			tr.Set(m.Key, m.Value)
+		}
+		for _, m := range perm(1000) {
+			if m.Key.(int)%2 == 0 {
+				tr.Delete(m.Key)
+			}
+		}
+		tr.DeleteMin()
+		tr.DeleteMax()
+		if got, want := tr.Len(), 498; got != want {
+			t.Fatalf("bplus=%t: Len = %d, want %d", bplus, got, want)
+		}
+		var want []Key
+		for k := 3; k < 999; k += 2 {
+			want = append(want, k)
+		}
+		var got []Key
+		for it := tr.Before(3); it.Next(); {
+			got = append(got, it.Key)
+		}
+		if !cmp.Equal(got, want) {
+			t.Fatalf("bplus=%t: got %v, want %v", bplus, got, want)
+		}
+		if v := tr.Get(501); v != 501 {
+			t.Errorf("bplus=%t: Get(501) = %v", bplus, v)
+		}
+		clone := tr.Clone()
+		clone.Delete(501)
+		if tr.Len() != 498 || clone.Len() != 497 {
+			t.Errorf("bplus=%t: after clone, Len = %d, %d", bplus, tr.Len(), clone.Len())
+		}
+		data, err := json.Marshal(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr2 := NewWithOptions(3, less, Options{NoIndex: true, BPlus: bplus})
+		tr2.DecodeJSONWith(decodeJSONInt, decodeJSONInt)
+		if err := json.Unmarshal(data, tr2); err != nil {
+			t.Fatal(err)
+		}
+		if tr2.Len() != tr.Len() {
+			t.Errorf("bplus=%t: decoded Len = %d, want %d", bplus, tr2.Len(), tr.Len())
+		}
+		for _, f := range []func(){
+			func() { tr.At(0) },
+			func() { tr.GetWithIndex(1) },
+			func() { tr.SetWithIndex(1, 1) },
+			func() { tr.BeforeIndex(0) },
+			func() { tr.AfterIndex(0) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("bplus=%t: no panic", bplus)
+					}
+				}()
+				f()
+			}()
+		}
+	}
+}
+
+// buildShape returns a tree of the given degree whose nodes are exactly those
+// described by shape, in which a node is written as its items in parentheses,
+// with its children between them: "((0 1) 2 (3))" is a root holding 2 with
+// leaves holding 0 and 1, and 3. Each item's value is its key. In a B+ tree,
+// the items of internal nodes are separators.
+func buildShape(t *testing.T, degree int, bplus bool, shape string) *BTree {
+	t.Helper()
+	tr := NewWithOptions(degree, less, Options{BPlus: bplus})
+	toks := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(shape))
+	var prev *node // the last leaf built, for B+ links
+	var parse func() *node
+	parse = func() *node {
+		if toks[0] != "(" {
+			t.Fatalf("shape %q: want '(' at %q", shape, toks)
+		}
+		toks = toks[1:]
+		n := tr.cow.newNode()
+		for toks[0] != ")" {
+			if toks[0] == "(" {
+				n.children = append(n.children, parse())
+				continue
+			}
+			k, err := strconv.Atoi(toks[0])
+			if err != nil {
+				t.Fatalf("shape %q: %v", shape, err)
+			}
+			toks = toks[1:]
+			n.items = append(n.items, item{k, k})
+		}
+		toks = toks[1:]
+		if len(n.children) == 0 {
+			if bplus && prev != nil {
+				prev.next = n
+			}
+			prev = n
+		} else if bplus {
+			for i := range n.items {
+				n.items[i].value = nil
+			}
+		}
+		n.size = n.computeSize()
+		return n
+	}
+	tr.root = parse()
+	tr.root.updateCum()
+	if err := tr.Verify(); err != nil {
+		t.Fatalf("shape %q: %v", shape, err)
+	}
+	return tr
+}
+
+// checkIndexes checks that the positions of the items of tr, by every means
+// of access by index, agree with keys, the sorted keys tr should hold.
+func checkIndexes(t *testing.T, tr *BTree, keys []int) {
+	t.Helper()
+	checkTree(t, tr)
+	if got := tr.Len(); got != len(keys) {
+		t.Fatalf("Len() = %d, want %d", got, len(keys))
+	}
+	for i, k := range keys {
+		if got, _ := tr.At(i); got != k {
+			t.Fatalf("At(%d) = %v, want %d", i, got, k)
+		}
+		if _, got := tr.GetWithIndex(k); got != i {
+			t.Fatalf("GetWithIndex(%d) = %d, want %d", k, got, i)
+		}
+		for _, it := range []*Iterator{tr.BeforeIndex(i), tr.AfterIndex(i)} {
+			if !it.Next() || it.Key != k || it.Index != i {
+				t.Fatalf("iterator at index %d: got key %v, index %d; want %d, %d", i, it.Key, it.Index, k, i)
+			}
+		}
+	}
+}
+
+// TestCumRebalance checks the cached cumulative child sizes after each kind of
+// rebalancing that a Delete can do, in trees of degree 2, where nodes hold
+// between one and three items.
+func TestCumRebalance(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		bplus  bool
+		shape  string
+		del    int
+		steals uint64
+		merges uint64
+	}{
+		// The deleted key is in a leaf whose left sibling can spare an item.
+		{"leaf steal left", false, "((0 1) 2 (3))", 3, 1, 0},
+		{"B+ leaf steal left", true, "((0 1) 2 (2))", 2, 1, 0},
+		// The parent of the deleted key's leaf takes a subtree from its left
+		// sibling, and then the leaf merges with its sibling.
+		{"steal left", false, "(((0 1) 2 (3) 4 (5)) 6 ((7) 8 (9)))", 9, 1, 1},
+		{"B+ steal left", true, "(((0 1) 2 (2) 4 (4)) 6 ((6) 8 (8)))", 8, 1, 1},
+		// Likewise, from the right.
+		{"steal right", false, "(((0) 1 (2)) 3 ((4) 5 (6) 7 (8 9)))", 0, 1, 1},
+		{"B+ steal right", true, "(((0) 1 (1)) 2 ((2) 3 (3) 4 (4 5)))", 0, 1, 1},
+		// The children of the root merge, leaving it empty, so the tree loses
+		// a level; then the leaves merge.
+		{"merge and collapse", false, "(((0) 1 (2)) 3 ((4) 5 (6)))", 0, 0, 2},
+		{"B+ merge and collapse", true, "(((0) 1 (1)) 2 ((2) 3 (3)))", 0, 0, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr := buildShape(t, 2, test.bplus, test.shape)
+			c := &Counters{}
+			tr.cow.counters = c
+			var keys []int
+			for it := tr.BeforeIndex(0); it.Next(); {
+				keys = append(keys, it.Key.(int))
+			}
+			checkIndexes(t, tr, keys)
+			height := tr.Stats().Height
+			if _, ok := tr.Delete(test.del); !ok {
+				t.Fatalf("Delete(%d) found nothing", test.del)
+			}
+			i := sort.SearchInts(keys, test.del)
+			keys = append(keys[:i], keys[i+1:]...)
+			checkIndexes(t, tr, keys)
+			got := c.Counts()
+			if got.Steals != test.steals || got.Merges != test.merges {
+				t.Errorf("got %d steals and %d merges, want %d and %d", got.Steals, got.Merges, test.steals, test.merges)
+			}
+			if collapsed := tr.Stats().Height < height; collapsed != (test.merges == 2) {
+				t.Errorf("height went from %d to %d", height, tr.Stats().Height)
+			}
+		})
+	}
+}
+
+// TestCumClone checks the cached cumulative child sizes of a tree and its
+// clone as writes to each make them diverge, so that some writes copy shared
+// nodes and others modify owned ones.
+func TestCumClone(t *testing.T) {
+	const size = 200
+	for _, bplus := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bplus=%t", bplus), func(t *testing.T) {
+			tr := NewWithOptions(2, less, Options{BPlus: bplus})
+			for _, m := range perm(size) {
+				tr.Set(m.Key, m.Value)
+			}
+			trees := []*BTree{tr, tr.Clone()}
+			models := [][]int{make([]int, size), make([]int, size)}
+			for i := range models[0] {
+				models[0][i], models[1][i] = i, i
+			}
+			r := rand.New(rand.NewSource(1))
+			for step := 0; step < 400; step++ {
+				j := step % 2
+				k := r.Intn(2 * size)
+				i := sort.SearchInts(models[j], k)
+				found := i < len(models[j]) && models[j][i] == k
+				if r.Intn(2) == 0 {
+					trees[j].Set(k, k)
+					if !found {
+						models[j] = append(models[j], 0)
+						copy(models[j][i+1:], models[j][i:])
+						models[j][i] = k
+					}
+				} else {
+					trees[j].Delete(k)
+					if found {
+						models[j] = append(models[j][:i], models[j][i+1:]...)
+					}
+				}
+				checkIndexes(t, trees[0], models[0])
+				checkIndexes(t, trees[1], models[1])
+			}
+		})
+	}
+}
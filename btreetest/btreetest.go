@@ -0,0 +1,409 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package btreetest supports model-based testing of btree.BTree and of types
+// built on it.
+//
+// A Generator produces random sequences of operations, and a Harness runs them
+// on the tree under test and on a Model, a simple reference implementation,
+// checking that each result agrees. When a sequence fails, the Harness can
+// shrink it to a short one that still fails, which is usually much easier to
+// debug.
+//
+// A typical test:
+//
+//	h := &btreetest.Harness{
+//		New:   func() btreetest.Tree { return btree.New(3, intLess) },
+//		Check: func(t btreetest.Tree) error { return t.(*btree.BTree).Verify() },
+//	}
+//	h.Test(t, &btreetest.Generator{}, rand.New(rand.NewSource(1)), 100, 1000)
+package btreetest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/jba/btree"
+)
+
+// Tree is the interface of the tree under test. *btree.BTree implements it, as
+// does any type that embeds one. The tree must hold the int keys and values
+// that the operations give it.
+type Tree interface {
+	Set(btree.Key, btree.Value) (btree.Value, bool)
+	Get(btree.Key) btree.Value
+	Delete(btree.Key) (btree.Value, bool)
+	DeleteMin() (btree.Key, btree.Value)
+	DeleteMax() (btree.Key, btree.Value)
+	At(int) (btree.Key, btree.Value)
+	Len() int
+	Before(btree.Key) *btree.Iterator
+	After(btree.Key) *btree.Iterator
+}
+
+// An ExtendedTree is a Tree with the other methods of *btree.BTree that the
+// kinds of operation from OpSetWithIndex on call. *btree.BTree implements it.
+// After an OpClone, the run goes on with the *btree.BTree that Clone returns.
+type ExtendedTree interface {
+	Tree
+	SetWithIndex(btree.Key, btree.Value) (btree.Value, bool, int)
+	GetWithIndex(btree.Key) (btree.Value, int)
+	BeforeIndex(int) *btree.Iterator
+	AfterIndex(int) *btree.Iterator
+	Append(btree.Key, btree.Value) error
+	SetHint(btree.Key, btree.Value, *btree.Hint) (btree.Value, bool)
+	DeleteHint(btree.Key, *btree.Hint) (btree.Value, bool)
+	Compact(float64)
+	Clone() *btree.BTree
+}
+
+// A Harness runs sequences of operations on a Tree and on a Model, and checks
+// that they agree.
+type Harness struct {
+	// New returns a new, empty tree to test. It is called at the start of each
+	// run.
+	New func() Tree
+
+	// Check, if not nil, is called after each operation to check invariants
+	// that the operations' results don't show, such as those checked by
+	// BTree.Verify.
+	Check func(Tree) error
+
+	// IgnoreIndex turns off the checking of iterator indexes, for trees created
+	// with the NoIndex option. Such trees panic on At and the other operations
+	// by index, so the operations must not include OpAt, OpSetWithIndex,
+	// OpGetWithIndex, OpBeforeIndex or OpAfterIndex either.
+	IgnoreIndex bool
+}
+
+// A Failure describes a run in which the tree under test failed.
+type Failure struct {
+	Ops  []Op   // the operations of the run
+	Step int    // index in Ops of the operation that failed
+	Msg  string // what went wrong
+}
+
+// Error describes the failure, listing the operations up to the failing one.
+func (f *Failure) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "btreetest: step %d, %s: %s\noperations:", f.Step, f.Ops[f.Step], f.Msg)
+	for i, op := range f.Ops[:f.Step+1] {
+		fmt.Fprintf(&b, "\n\t%d: %s", i, op)
+	}
+	return b.String()
+}
+
+// runState is the state of a run.
+type runState struct {
+	tr   Tree
+	m    *Model
+	hint btree.Hint // for OpSetHint and OpDeleteHint on tr
+	// After an OpClone, orig is the tree that was cloned, and origModel
+	// holds what it should still hold.
+	orig      Tree
+	origModel *Model
+}
+
+// Run runs ops on a new tree and a new Model. It returns a *Failure for the
+// first operation whose result differs from the model's, that panics, or after
+// which the length differs or Check fails. After an OpClone, it also fails if
+// the operations on the clone change the original. It returns nil if there is
+// no failure.
+func (h *Harness) Run(ops []Op) (err error) {
+	s := &runState{tr: h.New(), m: &Model{}}
+	var step int
+	defer func() {
+		if e := recover(); e != nil {
+			err = &Failure{Ops: ops, Step: step, Msg: fmt.Sprintf("panic: %v", e)}
+		}
+	}()
+	for step = range ops {
+		if msg := h.run(s, ops[step]); msg != "" {
+			return &Failure{Ops: ops, Step: step, Msg: msg}
+		}
+	}
+	return nil
+}
+
+// run runs op on the tree and model of s, and returns a description of any
+// difference.
+func (h *Harness) run(s *runState, op Op) string {
+	tr, m := s.tr, s.m
+	var msg string
+	var xt ExtendedTree
+	if op.Kind >= OpSetWithIndex && op.Kind < numOpKinds {
+		var ok bool
+		if xt, ok = tr.(ExtendedTree); !ok {
+			return fmt.Sprintf("%T does not implement ExtendedTree", tr)
+		}
+	}
+	switch op.Kind {
+	case OpSet:
+		got, gotOK := tr.Set(op.Key, op.Value)
+		want, wantOK := m.Set(op.Key, op.Value)
+		msg = compareValue(got, gotOK, want, wantOK)
+	case OpGet:
+		want, ok := m.Get(op.Key)
+		msg = compareValue(tr.Get(op.Key), ok, want, ok)
+	case OpDelete:
+		got, gotOK := tr.Delete(op.Key)
+		want, wantOK := m.Delete(op.Key)
+		msg = compareValue(got, gotOK, want, wantOK)
+	case OpDeleteMin:
+		k, v := tr.DeleteMin()
+		want, ok := m.DeleteMin()
+		msg = compareItem(k, v, want, ok)
+	case OpDeleteMax:
+		k, v := tr.DeleteMax()
+		want, ok := m.DeleteMax()
+		msg = compareItem(k, v, want, ok)
+	case OpAt:
+		if m.Len() > 0 {
+			i := op.Index % m.Len()
+			k, v := tr.At(i)
+			msg = compareItem(k, v, m.At(i), true)
+		}
+	case OpBefore:
+		want, index := m.Before(op.Key)
+		msg = h.compareIterator(tr.Before(op.Key), op.N, want, index, 1)
+	case OpAfter:
+		want, index := m.After(op.Key)
+		msg = h.compareIterator(tr.After(op.Key), op.N, want, index, -1)
+	case OpSetWithIndex:
+		got, gotOK, gotIndex := xt.SetWithIndex(op.Key, op.Value)
+		want, wantOK := m.Set(op.Key, op.Value)
+		msg = compareValue(got, gotOK, want, wantOK)
+		if wantIndex, _ := m.find(op.Key); msg == "" && gotIndex != wantIndex {
+			msg = fmt.Sprintf("got index %d, want %d", gotIndex, wantIndex)
+		}
+	case OpGetWithIndex:
+		got, gotIndex := xt.GetWithIndex(op.Key)
+		want, ok := m.Get(op.Key)
+		msg = compareValue(got, ok, want, ok)
+		wantIndex, _ := m.find(op.Key)
+		if !ok {
+			wantIndex = -1
+		}
+		if msg == "" && gotIndex != wantIndex {
+			msg = fmt.Sprintf("got index %d, want %d", gotIndex, wantIndex)
+		}
+	case OpBeforeIndex:
+		i := op.Index % (m.Len() + 1)
+		msg = h.compareIterator(xt.BeforeIndex(i), op.N, m.items[i:], i, 1)
+	case OpAfterIndex:
+		i := op.Index % (m.Len() + 1)
+		var want []Item
+		if i < m.Len() {
+			want, _ = m.After(m.items[i].Key)
+		}
+		msg = h.compareIterator(xt.AfterIndex(i), op.N, want, i, -1)
+	case OpAppend:
+		err := xt.Append(op.Key, op.Value)
+		if n := m.Len(); n > 0 && m.items[n-1].Key >= op.Key {
+			if !errors.Is(err, btree.ErrAppendOrder) {
+				msg = fmt.Sprintf("got error %v, want ErrAppendOrder", err)
+			}
+		} else if err != nil {
+			msg = fmt.Sprintf("got error %v", err)
+		} else {
+			m.Set(op.Key, op.Value)
+		}
+	case OpSetHint:
+		got, gotOK := xt.SetHint(op.Key, op.Value, &s.hint)
+		want, wantOK := m.Set(op.Key, op.Value)
+		msg = compareValue(got, gotOK, want, wantOK)
+	case OpDeleteHint:
+		got, gotOK := xt.DeleteHint(op.Key, &s.hint)
+		want, wantOK := m.Delete(op.Key)
+		msg = compareValue(got, gotOK, want, wantOK)
+	case OpCompact:
+		xt.Compact(op.fill())
+	case OpClone:
+		s.orig, s.origModel = tr, m
+		s.tr, s.m = xt.Clone(), m.Clone()
+		s.hint = btree.Hint{}
+		tr, m = s.tr, s.m
+	default:
+		panic(fmt.Sprintf("btreetest: bad operation kind %d", op.Kind))
+	}
+	if msg != "" {
+		return msg
+	}
+	if got, want := tr.Len(), m.Len(); got != want {
+		return fmt.Sprintf("Len() = %d, want %d", got, want)
+	}
+	if h.Check != nil {
+		if err := h.Check(tr); err != nil {
+			return err.Error()
+		}
+	}
+	if s.orig != nil {
+		if msg := h.compareContents(s.orig, s.origModel); msg != "" {
+			return "original of clone: " + msg
+		}
+	}
+	return ""
+}
+
+// compareContents compares all the items of tr with those of m, and checks tr
+// with Check.
+func (h *Harness) compareContents(tr Tree, m *Model) string {
+	if got, want := tr.Len(), m.Len(); got != want {
+		return fmt.Sprintf("Len() = %d, want %d", got, want)
+	}
+	if m.Len() > 0 {
+		if msg := h.compareIterator(tr.Before(m.items[0].Key), m.Len()+1, m.items, 0, 1); msg != "" {
+			return msg
+		}
+	}
+	if h.Check != nil {
+		if err := h.Check(tr); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// compareValue compares the results of an operation that returns a value and
+// whether it was present. The tree's value must be nil if it isn't.
+func compareValue(got btree.Value, gotOK bool, want int, wantOK bool) string {
+	if gotOK != wantOK || (wantOK && got != btree.Value(want)) || (!wantOK && got != nil) {
+		return fmt.Sprintf("got (%v, %t), want %s", got, gotOK, formatValue(want, wantOK))
+	}
+	return ""
+}
+
+func formatValue(v int, ok bool) string {
+	if !ok {
+		return "(<nil>, false)"
+	}
+	return fmt.Sprintf("(%d, true)", v)
+}
+
+// compareItem compares the results of an operation that returns a key and
+// value, which must both be nil if ok is false.
+func compareItem(k btree.Key, v btree.Value, want Item, ok bool) string {
+	if ok && (k != btree.Key(want.Key) || v != btree.Value(want.Value)) {
+		return fmt.Sprintf("got (%v, %v), want (%d, %d)", k, v, want.Key, want.Value)
+	}
+	if !ok && (k != nil || v != nil) {
+		return fmt.Sprintf("got (%v, %v), want (<nil>, <nil>)", k, v)
+	}
+	return ""
+}
+
+// compareIterator takes up to n steps of it and compares them to want, whose
+// first item has the given index, and whose indexes change by dir at each step.
+func (h *Harness) compareIterator(it *btree.Iterator, n int, want []Item, index, dir int) string {
+	for i := 0; i < n; i++ {
+		if !it.Next() {
+			if i < len(want) {
+				return fmt.Sprintf("iterator ended after %d items, want %d", i, len(want))
+			}
+			return ""
+		}
+		if i >= len(want) {
+			return fmt.Sprintf("iterator step %d: got (%v, %v), want end", i, it.Key, it.Value)
+		}
+		if it.Key != btree.Key(want[i].Key) || it.Value != btree.Value(want[i].Value) {
+			return fmt.Sprintf("iterator step %d: got (%v, %v), want (%d, %d)", i, it.Key, it.Value, want[i].Key, want[i].Value)
+		}
+		if !h.IgnoreIndex && it.Index != index+dir*i {
+			return fmt.Sprintf("iterator step %d: got index %d, want %d", i, it.Index, index+dir*i)
+		}
+	}
+	return ""
+}
+
+// Shrink returns a short sequence of operations drawn from ops that still
+// fails, or ops itself if ops doesn't fail. It first removes operations,
+// trying large runs of them before small ones, until removing any one makes
+// the failure go away. Then it tries to replace the keys, values and other
+// arguments of what's left with smaller ones. The failure of the result may
+// not be the same as that of ops, though it usually is.
+func (h *Harness) Shrink(ops []Op) []Op {
+	cur := h.failingPrefix(ops)
+	if cur == nil {
+		return ops
+	}
+	for chunk := len(cur) / 2; chunk >= 1; {
+		removed := false
+		for i := 0; i+chunk <= len(cur); {
+			try := append(append([]Op(nil), cur[:i]...), cur[i+chunk:]...)
+			if f := h.failingPrefix(try); f != nil {
+				cur = f
+				removed = true
+			} else {
+				i += chunk
+			}
+		}
+		if !removed {
+			chunk /= 2
+		} else if chunk > len(cur)/2 {
+			chunk = len(cur) / 2
+		}
+	}
+	for i := 0; i < len(cur); i++ {
+		for _, simplify := range simplifiers {
+			op := cur[i]
+			simplify(&op)
+			if op == cur[i] {
+				continue
+			}
+			try := append([]Op(nil), cur...)
+			try[i] = op
+			if f := h.failingPrefix(try); f != nil {
+				cur = f
+			}
+		}
+	}
+	return cur
+}
+
+// failingPrefix returns ops up to and including the operation that fails, or
+// nil if ops doesn't fail.
+func (h *Harness) failingPrefix(ops []Op) []Op {
+	f, ok := h.Run(ops).(*Failure)
+	if !ok {
+		return nil
+	}
+	return ops[:f.Step+1]
+}
+
+// simplifiers are the ways Shrink tries to make an operation simpler, in the
+// order it tries them.
+var simplifiers = []func(*Op){
+	func(op *Op) { op.Value = 0 },
+	func(op *Op) { op.Index = 0 },
+	func(op *Op) { op.N = 1 },
+	func(op *Op) { op.Key = 0 },
+	func(op *Op) { op.Key /= 2 },
+}
+
+// Test runs the given number of random sequences of n operations, generated by
+// g from r. If one fails, Test shrinks it and fails t with the result.
+func (h *Harness) Test(t testing.TB, g *Generator, r *rand.Rand, runs, n int) {
+	t.Helper()
+	for i := 0; i < runs; i++ {
+		ops := g.Generate(r, n)
+		if h.Run(ops) == nil {
+			continue
+		}
+		err := h.Run(h.Shrink(ops))
+		t.Fatalf("run %d failed; shrunk to:\n%v", i, err)
+	}
+}
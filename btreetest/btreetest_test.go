@@ -0,0 +1,207 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btreetest_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jba/btree"
+	"github.com/jba/btree/btreetest"
+)
+
+func less(a, b interface{}) bool { return a.(int) < b.(int) }
+
+func verify(t btreetest.Tree) error { return t.(*btree.BTree).Verify() }
+
+func TestBTree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range []btree.Options{{}, {BPlus: true}} {
+		for _, degree := range []int{2, 3} {
+			h := &btreetest.Harness{
+				New:   func() btreetest.Tree { return btree.NewWithOptions(degree, less, opts) },
+				Check: verify,
+			}
+			h.Test(t, &btreetest.Generator{}, r, 20, 500)
+			h.Test(t, &btreetest.Generator{MaxKey: 1000}, r, 5, 2000)
+		}
+	}
+}
+
+func TestNoIndex(t *testing.T) {
+	weights := map[btreetest.OpKind]int{}
+	for k, w := range btreetest.DefaultWeights {
+		if k != btreetest.OpAt {
+			weights[k] = w
+		}
+	}
+	h := &btreetest.Harness{
+		New:         func() btreetest.Tree { return btree.NewWithOptions(3, less, btree.Options{NoIndex: true}) },
+		Check:       verify,
+		IgnoreIndex: true,
+	}
+	h.Test(t, &btreetest.Generator{Weights: weights}, rand.New(rand.NewSource(2)), 20, 500)
+}
+
+func TestExtended(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, opts := range []btree.Options{{}, {BPlus: true}} {
+		for _, degree := range []int{2, 3} {
+			h := &btreetest.Harness{
+				New:   func() btreetest.Tree { return btree.NewWithOptions(degree, less, opts) },
+				Check: verify,
+			}
+			h.Test(t, &btreetest.Generator{Weights: btreetest.ExtendedWeights}, r, 20, 500)
+		}
+	}
+
+	weights := map[btreetest.OpKind]int{}
+	for k, w := range btreetest.ExtendedWeights {
+		switch k {
+		case btreetest.OpAt, btreetest.OpSetWithIndex, btreetest.OpGetWithIndex, btreetest.OpBeforeIndex, btreetest.OpAfterIndex:
+		default:
+			weights[k] = w
+		}
+	}
+	h := &btreetest.Harness{
+		New:         func() btreetest.Tree { return btree.NewWithOptions(2, less, btree.Options{NoIndex: true}) },
+		Check:       verify,
+		IgnoreIndex: true,
+	}
+	h.Test(t, &btreetest.Generator{Weights: weights}, r, 20, 500)
+}
+
+// sharedClone is a broken tree whose Clone shares it.
+type sharedClone struct {
+	*btree.BTree
+}
+
+func (s sharedClone) Clone() *btree.BTree { return s.BTree }
+
+func TestCloneChangesOriginal(t *testing.T) {
+	h := &btreetest.Harness{
+		New: func() btreetest.Tree { return sharedClone{btree.New(2, less)} },
+	}
+	ops := []btreetest.Op{
+		{Kind: btreetest.OpSet, Key: 1},
+		{Kind: btreetest.OpClone},
+		{Kind: btreetest.OpGet, Key: 1},
+		{Kind: btreetest.OpSet, Key: 2},
+	}
+	f, ok := h.Run(ops).(*btreetest.Failure)
+	if !ok || f.Step != 3 || !strings.HasPrefix(f.Msg, "original of clone: ") {
+		t.Fatalf("got %v", f)
+	}
+}
+
+func TestModel(t *testing.T) {
+	var m btreetest.Model
+	for _, k := range []int{5, 1, 3} {
+		m.Set(k, k*10)
+	}
+	if old, ok := m.Set(3, 0); old != 30 || !ok {
+		t.Errorf("Set(3) = %d, %t", old, ok)
+	}
+	want := []btreetest.Item{{1, 10}, {3, 0}, {5, 50}}
+	if got := m.Items(); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, i := m.Before(2); !cmp.Equal(got, want[1:]) || i != 1 {
+		t.Errorf("Before(2) = %v, %d", got, i)
+	}
+	if got, i := m.After(3); !cmp.Equal(got, []btreetest.Item{{3, 0}, {1, 10}}) || i != 1 {
+		t.Errorf("After(3) = %v, %d", got, i)
+	}
+	if got, i := m.After(0); len(got) != 0 || i != -1 {
+		t.Errorf("After(0) = %v, %d", got, i)
+	}
+	if it, ok := m.DeleteMax(); it != (btreetest.Item{5, 50}) || !ok {
+		t.Errorf("DeleteMax = %v, %t", it, ok)
+	}
+}
+
+// lazyDelete is a broken tree: it ignores deletions once it has five items.
+type lazyDelete struct {
+	*btree.BTree
+}
+
+func (l lazyDelete) Delete(k btree.Key) (btree.Value, bool) {
+	if l.Len() >= 5 {
+		return l.Get(k), l.Has(k)
+	}
+	return l.BTree.Delete(k)
+}
+
+func TestShrink(t *testing.T) {
+	h := &btreetest.Harness{
+		New: func() btreetest.Tree { return lazyDelete{btree.New(2, less)} },
+	}
+	g := &btreetest.Generator{MaxKey: 50}
+	ops := g.Generate(rand.New(rand.NewSource(3)), 2000)
+	err := h.Run(ops)
+	if err == nil {
+		t.Fatal("broken tree passed")
+	}
+	short := h.Shrink(ops)
+	// The smallest failure is five Sets and a Delete of one of their keys.
+	if len(short) != 6 || short[5].Kind != btreetest.OpDelete {
+		t.Fatalf("shrunk to %v", short)
+	}
+	for _, op := range short[:5] {
+		if op.Kind != btreetest.OpSet || op.Value != 0 {
+			t.Fatalf("shrunk to %v", short)
+		}
+	}
+	f, ok := h.Run(short).(*btreetest.Failure)
+	if !ok || f.Step != 5 {
+		t.Fatalf("shrunk sequence: got %v", f)
+	}
+	if got := f.Error(); !strings.Contains(got, "step 5, Delete(") || !strings.Contains(got, "Len() = 5, want 4") || !strings.Contains(got, "\t0: Set(") {
+		t.Errorf("bad error message:\n%s", got)
+	}
+
+	// A sequence that passes is its own shrinking.
+	good := []btreetest.Op{{Kind: btreetest.OpSet, Key: 1}}
+	if got := h.Shrink(good); !cmp.Equal(got, good) {
+		t.Errorf("Shrink of a passing sequence = %v", got)
+	}
+}
+
+// panicAt is a broken tree whose At panics.
+type panicAt struct {
+	*btree.BTree
+}
+
+func (panicAt) At(int) (btree.Key, btree.Value) { panic("oops") }
+
+func TestPanic(t *testing.T) {
+	h := &btreetest.Harness{
+		New: func() btreetest.Tree { return panicAt{btree.New(2, less)} },
+	}
+	ops := []btreetest.Op{
+		{Kind: btreetest.OpAt, Index: 3}, // nothing to do on an empty tree
+		{Kind: btreetest.OpSet, Key: 1, Value: 2},
+		{Kind: btreetest.OpAt, Index: 3},
+	}
+	f, ok := h.Run(ops).(*btreetest.Failure)
+	if !ok || f.Step != 2 || f.Msg != "panic: oops" {
+		t.Fatalf("got %v", f)
+	}
+	if got := h.Shrink(ops); len(got) != 2 || got[1].Index != 0 {
+		t.Errorf("shrunk to %v", got)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btreetest
+
+import "sort"
+
+// An Item is a key and value in a Model.
+type Item struct {
+	Key, Value int
+}
+
+// A Model is a reference implementation of an ordered map from int keys to int
+// values, kept as a sorted slice. It is too slow for real use, but simple
+// enough to be obviously correct. The zero Model is empty and ready to use.
+type Model struct {
+	items []Item
+}
+
+// find returns the index of k in m, or where it would be inserted, and whether
+// it is present.
+func (m *Model) find(k int) (int, bool) {
+	i := sort.Search(len(m.items), func(i int) bool { return m.items[i].Key >= k })
+	return i, i < len(m.items) && m.items[i].Key == k
+}
+
+// Len returns the number of items in m.
+func (m *Model) Len() int { return len(m.items) }
+
+// Get returns the value of k and whether k is present.
+func (m *Model) Get(k int) (int, bool) {
+	i, ok := m.find(k)
+	if !ok {
+		return 0, false
+	}
+	return m.items[i].Value, true
+}
+
+// Set sets k to v, returning the old value of k and whether it was present.
+func (m *Model) Set(k, v int) (old int, present bool) {
+	i, ok := m.find(k)
+	if ok {
+		old = m.items[i].Value
+		m.items[i].Value = v
+		return old, true
+	}
+	m.items = append(m.items, Item{})
+	copy(m.items[i+1:], m.items[i:])
+	m.items[i] = Item{k, v}
+	return 0, false
+}
+
+// Delete removes k, returning its value and whether it was present.
+func (m *Model) Delete(k int) (int, bool) {
+	i, ok := m.find(k)
+	if !ok {
+		return 0, false
+	}
+	return m.removeAt(i).Value, true
+}
+
+// DeleteMin removes and returns the item with the smallest key. It reports
+// false if m is empty.
+func (m *Model) DeleteMin() (Item, bool) {
+	if len(m.items) == 0 {
+		return Item{}, false
+	}
+	return m.removeAt(0), true
+}
+
+// DeleteMax removes and returns the item with the largest key. It reports
+// false if m is empty.
+func (m *Model) DeleteMax() (Item, bool) {
+	if len(m.items) == 0 {
+		return Item{}, false
+	}
+	return m.removeAt(len(m.items) - 1), true
+}
+
+func (m *Model) removeAt(i int) Item {
+	it := m.items[i]
+	m.items = append(m.items[:i], m.items[i+1:]...)
+	return it
+}
+
+// At returns the item at index i. It panics if i is out of range.
+func (m *Model) At(i int) Item {
+	return m.items[i]
+}
+
+// Before returns the items with keys greater than or equal to k, in ascending
+// order, like the items visited by an iterator from BTree.Before. The index of
+// the first is returned as well.
+func (m *Model) Before(k int) ([]Item, int) {
+	i, _ := m.find(k)
+	return m.items[i:], i
+}
+
+// After returns the items with keys less than or equal to k, in descending
+// order, like the items visited by an iterator from BTree.After. The index of
+// the first is returned as well.
+func (m *Model) After(k int) ([]Item, int) {
+	i, ok := m.find(k)
+	if ok {
+		i++
+	}
+	s := make([]Item, i)
+	for j := range s {
+		s[j] = m.items[i-1-j]
+	}
+	return s, i - 1
+}
+
+// Clone returns a copy of m.
+func (m *Model) Clone() *Model {
+	return &Model{items: append([]Item(nil), m.items...)}
+}
+
+// Items returns the items of m in order. The caller must not modify them.
+func (m *Model) Items() []Item {
+	return m.items
+}
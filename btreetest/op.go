@@ -0,0 +1,191 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btreetest
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// An OpKind is a kind of operation on a tree.
+type OpKind int
+
+const (
+	OpSet       OpKind = iota // Set(Key, Value)
+	OpGet                     // Get(Key)
+	OpDelete                  // Delete(Key)
+	OpDeleteMin               // DeleteMin()
+	OpDeleteMax               // DeleteMax()
+	OpAt                      // At(Index), with Index reduced modulo the length
+	OpBefore                  // up to N steps of the iterator from Before(Key)
+	OpAfter                   // up to N steps of the iterator from After(Key)
+
+	// The remaining kinds need an ExtendedTree.
+
+	OpSetWithIndex // SetWithIndex(Key, Value)
+	OpGetWithIndex // GetWithIndex(Key)
+	OpBeforeIndex  // up to N steps of the iterator from BeforeIndex(Index), with Index reduced modulo the length plus one
+	OpAfterIndex   // up to N steps of the iterator from AfterIndex(Index), with Index reduced likewise
+	OpAppend       // Append(Key, Value)
+	OpSetHint      // SetHint(Key, Value), with a Hint kept for the run
+	OpDeleteHint   // DeleteHint(Key), with the same Hint
+	OpCompact      // Compact(fill), where fill is (Value mod 100 + 1) / 100
+	OpClone        // go on with a Clone of the tree; the original must not change
+	numOpKinds
+)
+
+var opNames = [numOpKinds]string{
+	"Set", "Get", "Delete", "DeleteMin", "DeleteMax", "At", "Before", "After",
+	"SetWithIndex", "GetWithIndex", "BeforeIndex", "AfterIndex", "Append",
+	"SetHint", "DeleteHint", "Compact", "Clone",
+}
+
+func (k OpKind) String() string {
+	if k < 0 || k >= numOpKinds {
+		return fmt.Sprintf("OpKind(%d)", int(k))
+	}
+	return opNames[k]
+}
+
+// An Op is an operation on a tree. Only the fields used by its Kind are
+// meaningful.
+//
+// The index of an At is taken modulo the length of the tree when the Op is
+// run, and an At on an empty tree does nothing; the indexes of BeforeIndex and
+// AfterIndex are reduced in the same way. So every sequence of Ops is valid,
+// which lets Shrink remove Ops freely.
+type Op struct {
+	Kind  OpKind
+	Key   int
+	Value int
+	Index int
+	N     int
+}
+
+// String formats op as a call, as in "Set(3, 7)".
+func (op Op) String() string {
+	switch op.Kind {
+	case OpSet, OpSetWithIndex, OpAppend, OpSetHint:
+		return fmt.Sprintf("%s(%d, %d)", op.Kind, op.Key, op.Value)
+	case OpGet, OpDelete, OpGetWithIndex, OpDeleteHint:
+		return fmt.Sprintf("%s(%d)", op.Kind, op.Key)
+	case OpAt:
+		return fmt.Sprintf("At(%d)", op.Index)
+	case OpBefore, OpAfter:
+		return fmt.Sprintf("%s(%d) x %d", op.Kind, op.Key, op.N)
+	case OpBeforeIndex, OpAfterIndex:
+		return fmt.Sprintf("%s(%d) x %d", op.Kind, op.Index, op.N)
+	case OpCompact:
+		return fmt.Sprintf("Compact(%.2f)", op.fill())
+	default:
+		return op.Kind.String() + "()"
+	}
+}
+
+// DefaultWeights are the relative frequencies of the kinds of operation that a
+// Generator produces if its Weights are nil. Sets are the most common, so that
+// trees grow.
+var DefaultWeights = map[OpKind]int{
+	OpSet:       8,
+	OpGet:       2,
+	OpDelete:    4,
+	OpDeleteMin: 1,
+	OpDeleteMax: 1,
+	OpAt:        2,
+	OpBefore:    1,
+	OpAfter:     1,
+}
+
+// ExtendedWeights are like DefaultWeights, but include the kinds of operation
+// that need an ExtendedTree.
+var ExtendedWeights = map[OpKind]int{
+	OpSet:          8,
+	OpGet:          2,
+	OpDelete:       4,
+	OpDeleteMin:    1,
+	OpDeleteMax:    1,
+	OpAt:           2,
+	OpBefore:       1,
+	OpAfter:        1,
+	OpSetWithIndex: 2,
+	OpGetWithIndex: 2,
+	OpBeforeIndex:  1,
+	OpAfterIndex:   1,
+	OpAppend:       2,
+	OpSetHint:      2,
+	OpDeleteHint:   1,
+	OpCompact:      1,
+	OpClone:        1,
+}
+
+// fill returns the fill of a Compact.
+func (op Op) fill() float64 {
+	v := op.Value % 100
+	if v < 0 {
+		v += 100
+	}
+	return float64(v+1) / 100
+}
+
+// A Generator generates random sequences of operations.
+type Generator struct {
+	// MaxKey bounds the keys of the operations, which are in [0, MaxKey). A
+	// small MaxKey makes updates and deletions of present keys more common. If
+	// zero, it is 100.
+	MaxKey int
+
+	// MaxSteps bounds the number of steps of an iterator. If zero, it is 10.
+	MaxSteps int
+
+	// Weights gives the relative frequency of each kind of operation; kinds
+	// that are missing don't occur. If nil, DefaultWeights is used.
+	Weights map[OpKind]int
+}
+
+// Generate returns a sequence of n random operations, using r as the source of
+// randomness.
+func (g *Generator) Generate(r *rand.Rand, n int) []Op {
+	maxKey, maxSteps, weights := g.MaxKey, g.MaxSteps, g.Weights
+	if maxKey == 0 {
+		maxKey = 100
+	}
+	if maxSteps == 0 {
+		maxSteps = 10
+	}
+	if weights == nil {
+		weights = DefaultWeights
+	}
+	// Lay out the kinds in order, so the result depends only on r.
+	var kinds []OpKind
+	for k := OpKind(0); k < numOpKinds; k++ {
+		for i := 0; i < weights[k]; i++ {
+			kinds = append(kinds, k)
+		}
+	}
+	if len(kinds) == 0 {
+		panic("btreetest: no operation has positive weight")
+	}
+	ops := make([]Op, n)
+	for i := range ops {
+		ops[i] = Op{
+			Kind:  kinds[r.Intn(len(kinds))],
+			Key:   r.Intn(maxKey),
+			Value: r.Intn(1000),
+			Index: r.Intn(maxKey),
+			N:     1 + r.Intn(maxSteps),
+		}
+	}
+	return ops
+}
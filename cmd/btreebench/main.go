@@ -0,0 +1,426 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Btreebench measures the performance of BTrees of several degrees on a
+// workload, and compares them with simpler ordered maps: a sorted slice, and a
+// map whose keys are sorted when a range scan needs them.
+//
+// Usage:
+//
+//	btreebench [flags]
+//
+// The workload starts by loading -n items, then runs -ops operations, each a
+// read, a write or a range scan. The -reads and -scans flags give the
+// fractions of reads and scans; the rest are writes. Keys are chosen by the
+// -keys flag:
+//
+//	uniform     uniformly from [0, 2n)
+//	sequential  reads cycle through the keys in order; writes add new keys
+//	            in increasing order
+//	zipf        from [0, 2n) with a Zipf distribution, so that a few keys are
+//	            hot; -zipf sets its exponent
+//
+// For each implementation, btreebench prints the load time, the throughput
+// and time per operation of the workload, the allocations per operation, and
+// the heap in use afterwards, as a table or, with -csv, as CSV. It also prints
+// the number of reads that found their key, which is the same for every
+// implementation.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jba/btree"
+)
+
+var (
+	size    = flag.Int("n", 100000, "number of items to load")
+	numOps  = flag.Int("ops", 1000000, "number of operations to run after loading")
+	reads   = flag.Float64("reads", 0.9, "fraction of operations that are reads")
+	scans   = flag.Float64("scans", 0, "fraction of operations that are range scans")
+	scanLen = flag.Int("scanlen", 100, "number of items visited by a range scan")
+	keyDist = flag.String("keys", "uniform", "key distribution: uniform, sequential or zipf")
+	zipfS   = flag.Float64("zipf", 1.1, "exponent of the zipf distribution; must be greater than 1")
+	degrees = flag.String("degrees", "2,8,32,64", "comma-separated BTree degrees")
+	impls   = flag.String("impls", "btree,slice,map", "comma-separated implementations: btree, slice, map")
+	csvOut  = flag.Bool("csv", false, "print CSV instead of a table")
+	seed    = flag.Int64("seed", 1, "random seed")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("btreebench: ")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: btreebench [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *reads < 0 || *scans < 0 || *reads+*scans > 1 {
+		log.Fatal("-reads and -scans must be non-negative and add up to at most 1")
+	}
+	w, err := newWorkload(*keyDist, *size, *numOps, *reads, *scans, *zipfS, rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	stores, err := parseStores(*impls, *degrees)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var results []result
+	for _, newStore := range stores {
+		results = append(results, w.run(newStore, *scanLen))
+	}
+	if *csvOut {
+		err = writeCSV(os.Stdout, results)
+	} else {
+		err = writeTable(os.Stdout, results)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseStores returns functions that create the stores named by the flags.
+func parseStores(impls, degrees string) ([]func() store, error) {
+	var stores []func() store
+	for _, name := range strings.Split(impls, ",") {
+		switch strings.TrimSpace(name) {
+		case "btree":
+			for _, ds := range strings.Split(degrees, ",") {
+				d, err := strconv.Atoi(strings.TrimSpace(ds))
+				if err != nil || d < 2 {
+					return nil, fmt.Errorf("bad degree %q", ds)
+				}
+				stores = append(stores, func() store { return newBTreeStore(d) })
+			}
+		case "slice":
+			stores = append(stores, func() store { return &sliceStore{} })
+		case "map":
+			stores = append(stores, func() store { return &mapStore{m: map[int]int{}} })
+		default:
+			return nil, fmt.Errorf("unknown implementation %q", name)
+		}
+	}
+	return stores, nil
+}
+
+// A store is an ordered map from ints to ints.
+type store interface {
+	name() string
+	set(k, v int)
+	get(k int) (int, bool)
+	// scan calls f on up to n items with keys greater than or equal to from, in
+	// order.
+	scan(from, n int, f func(k, v int))
+}
+
+type btreeStore struct {
+	t      *btree.BTree
+	degree int
+}
+
+func newBTreeStore(degree int) *btreeStore {
+	less := func(a, b interface{}) bool { return a.(int) < b.(int) }
+	return &btreeStore{t: btree.New(degree, less), degree: degree}
+}
+
+func (s *btreeStore) name() string { return fmt.Sprintf("btree/%d", s.degree) }
+
+func (s *btreeStore) set(k, v int) { s.t.Set(k, v) }
+
+func (s *btreeStore) get(k int) (int, bool) {
+	v := s.t.Get(k)
+	if v == nil {
+		return 0, false
+	}
+	return v.(int), true
+}
+
+func (s *btreeStore) scan(from, n int, f func(k, v int)) {
+	it := s.t.Before(from)
+	for i := 0; i < n && it.Next(); i++ {
+		f(it.Key.(int), it.Value.(int))
+	}
+}
+
+// A sliceStore keeps its items in a slice sorted by key.
+type sliceStore struct {
+	items []kv
+}
+
+type kv struct{ k, v int }
+
+func (s *sliceStore) name() string { return "slice" }
+
+func (s *sliceStore) find(k int) int {
+	return sort.Search(len(s.items), func(i int) bool { return s.items[i].k >= k })
+}
+
+func (s *sliceStore) set(k, v int) {
+	i := s.find(k)
+	if i < len(s.items) && s.items[i].k == k {
+		s.items[i].v = v
+		return
+	}
+	s.items = append(s.items, kv{})
+	copy(s.items[i+1:], s.items[i:])
+	s.items[i] = kv{k, v}
+}
+
+func (s *sliceStore) get(k int) (int, bool) {
+	i := s.find(k)
+	if i < len(s.items) && s.items[i].k == k {
+		return s.items[i].v, true
+	}
+	return 0, false
+}
+
+func (s *sliceStore) scan(from, n int, f func(k, v int)) {
+	for _, it := range s.items[s.find(from):] {
+		if n == 0 {
+			return
+		}
+		f(it.k, it.v)
+		n--
+	}
+}
+
+// A mapStore keeps its items in a map, and sorts the keys when a scan needs them
+// after a write has added a key.
+type mapStore struct {
+	m     map[int]int
+	keys  []int // sorted keys, if !dirty
+	dirty bool
+}
+
+func (s *mapStore) name() string { return "map" }
+
+func (s *mapStore) set(k, v int) {
+	if _, ok := s.m[k]; !ok {
+		s.dirty = true
+	}
+	s.m[k] = v
+}
+
+func (s *mapStore) get(k int) (int, bool) {
+	v, ok := s.m[k]
+	return v, ok
+}
+
+func (s *mapStore) scan(from, n int, f func(k, v int)) {
+	if s.dirty || s.keys == nil {
+		s.keys = s.keys[:0]
+		for k := range s.m {
+			s.keys = append(s.keys, k)
+		}
+		sort.Ints(s.keys)
+		s.dirty = false
+	}
+	for _, k := range s.keys[sort.SearchInts(s.keys, from):] {
+		if n == 0 {
+			return
+		}
+		f(k, s.m[k])
+		n--
+	}
+}
+
+// An op is an operation of a workload.
+type op struct {
+	kind opKind
+	key  int
+}
+
+type opKind byte
+
+const (
+	opRead opKind = iota
+	opWrite
+	opScan
+)
+
+// A workload is a list of keys to load and of operations to run afterwards. It
+// is generated in advance, so that every implementation runs the same one and
+// the cost of generating it isn't measured.
+type workload struct {
+	load []int
+	ops  []op
+}
+
+// newWorkload generates a workload of n items to load and numOps operations.
+func newWorkload(dist string, n, numOps int, reads, scans, s float64, r *rand.Rand) (*workload, error) {
+	w := &workload{}
+	space := 2 * n
+	var next func(opKind) int
+	switch dist {
+	case "uniform":
+		w.load = r.Perm(space)[:n]
+		next = func(opKind) int { return r.Intn(space) }
+	case "sequential":
+		w.load = make([]int, n)
+		for i := range w.load {
+			w.load[i] = i
+		}
+		read, write := 0, n
+		next = func(k opKind) int {
+			if k == opWrite {
+				write++
+				return write - 1
+			}
+			read = (read + 1) % n
+			return read
+		}
+	case "zipf":
+		if s <= 1 {
+			return nil, fmt.Errorf("-zipf must be greater than 1")
+		}
+		w.load = r.Perm(space)[:n]
+		z := rand.NewZipf(r, s, 1, uint64(space-1))
+		// Spread the hot keys over the key space, rather than leaving them all
+		// at the start.
+		next = func(opKind) int { return int(z.Uint64() * 2654435761 % uint64(space)) }
+	default:
+		return nil, fmt.Errorf("unknown key distribution %q", dist)
+	}
+	w.ops = make([]op, numOps)
+	for i := range w.ops {
+		var k opKind
+		switch x := r.Float64(); {
+		case x < reads:
+			k = opRead
+		case x < reads+scans:
+			k = opScan
+		default:
+			k = opWrite
+		}
+		w.ops[i] = op{k, next(k)}
+	}
+	return w, nil
+}
+
+// A result holds the measurements of a run of a workload.
+type result struct {
+	name        string
+	load        time.Duration // time to load the items
+	run         time.Duration // time to run the operations
+	ops         int
+	allocsPerOp float64
+	bytesPerOp  float64
+	heap        uint64 // bytes in use by the store after the run
+	hits        int    // reads that found their key
+}
+
+// run runs w on a new store, visiting scanLen items in each scan.
+func (w *workload) run(newStore func() store, scanLen int) result {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	s := newStore()
+	res := result{name: s.name(), ops: len(w.ops)}
+	start := time.Now()
+	for _, k := range w.load {
+		s.set(k, k)
+	}
+	res.load = time.Since(start)
+
+	var mid runtime.MemStats
+	runtime.ReadMemStats(&mid)
+	sum := 0
+	visit := func(k, v int) { sum += v }
+	start = time.Now()
+	for i, o := range w.ops {
+		switch o.kind {
+		case opRead:
+			if _, ok := s.get(o.key); ok {
+				res.hits++
+			}
+		case opWrite:
+			s.set(o.key, i)
+		case opScan:
+			s.scan(o.key, scanLen, visit)
+		}
+	}
+	res.run = time.Since(start)
+	runtime.ReadMemStats(&after)
+	if n := float64(len(w.ops)); n > 0 {
+		res.allocsPerOp = float64(after.Mallocs-mid.Mallocs) / n
+		res.bytesPerOp = float64(after.TotalAlloc-mid.TotalAlloc) / n
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if after.HeapAlloc > before.HeapAlloc {
+		res.heap = after.HeapAlloc - before.HeapAlloc
+	}
+	runtime.KeepAlive(s)
+	_ = sum
+	return res
+}
+
+var header = []string{"impl", "load", "ops/s", "ns/op", "allocs/op", "B/op", "heap MB", "hits"}
+
+// row formats r for output.
+func (r result) row() []string {
+	opsPerSec, nsPerOp := 0.0, 0.0
+	if r.ops > 0 {
+		opsPerSec = float64(r.ops) / r.run.Seconds()
+		nsPerOp = float64(r.run.Nanoseconds()) / float64(r.ops)
+	}
+	return []string{
+		r.name,
+		r.load.Round(time.Microsecond).String(),
+		fmt.Sprintf("%.0f", opsPerSec),
+		fmt.Sprintf("%.1f", nsPerOp),
+		fmt.Sprintf("%.2f", r.allocsPerOp),
+		fmt.Sprintf("%.1f", r.bytesPerOp),
+		fmt.Sprintf("%.1f", float64(r.heap)/(1<<20)),
+		strconv.Itoa(r.hits),
+	}
+}
+
+func writeTable(w io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, r := range results {
+		fmt.Fprintln(tw, strings.Join(r.row(), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, results []result) error {
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, r := range results {
+		cw.Write(r.row())
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestStoresAgree checks that every store gives the same results for the same
+// workload.
+func TestStoresAgree(t *testing.T) {
+	stores, err := parseStores("btree,slice,map", "2,3,16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dist := range []string{"uniform", "sequential", "zipf"} {
+		w, err := newWorkload(dist, 200, 2000, 0.4, 0.2, 1.1, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []int
+		for _, newStore := range stores {
+			s := newStore()
+			for _, k := range w.load {
+				s.set(k, k)
+			}
+			var got []int
+			for i, o := range w.ops {
+				switch o.kind {
+				case opRead:
+					v, ok := s.get(o.key)
+					if !ok {
+						v = -1
+					}
+					got = append(got, v)
+				case opWrite:
+					s.set(o.key, i)
+				case opScan:
+					s.scan(o.key, 5, func(k, v int) { got = append(got, k, v) })
+				}
+			}
+			if want == nil {
+				want = got
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, %s: results differ from the first store's", dist, s.name())
+			}
+		}
+	}
+}
+
+func TestParseStoresErrors(t *testing.T) {
+	for _, test := range []struct{ impls, degrees string }{
+		{"btree", "1"},
+		{"btree", "x"},
+		{"list", "2"},
+	} {
+		if _, err := parseStores(test.impls, test.degrees); err == nil {
+			t.Errorf("parseStores(%q, %q): got nil error", test.impls, test.degrees)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	w, err := newWorkload("uniform", 100, 1000, 0.5, 0.1, 1.1, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores, err := parseStores("btree,map", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []result
+	for _, newStore := range stores {
+		results = append(results, w.run(newStore, 10))
+	}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "btree/4,") || !strings.HasPrefix(lines[2], "map,") {
+		t.Errorf("got CSV\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := writeTable(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 3 {
+		t.Errorf("got %d table lines, want 3:\n%s", got, buf.String())
+	}
+}
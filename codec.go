@@ -0,0 +1,162 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Codec converts keys or values to and from bytes. Codecs are used by the
+// parts of this package that write trees or their mutations to external storage.
+type Codec interface {
+	// Append appends the encoding of x to b and returns the extended slice.
+	Append(b []byte, x interface{}) ([]byte, error)
+	// Decode decodes b, which holds exactly one encoding produced by Append.
+	// It must not retain b.
+	Decode(b []byte) (interface{}, error)
+}
+
+var (
+	// IntCodec encodes values of type int as varints.
+	IntCodec Codec = intCodec{}
+
+	// Int64Codec encodes values of type int64 as varints.
+	Int64Codec Codec = int64Codec{}
+
+	// StringCodec encodes values of type string as their bytes.
+	StringCodec Codec = stringCodec{}
+
+	// BytesCodec encodes values of type []byte as themselves.
+	BytesCodec Codec = bytesCodec{}
+)
+
+type intCodec struct{}
+
+func (intCodec) Append(b []byte, x interface{}) ([]byte, error) {
+	i, ok := x.(int)
+	if !ok {
+		return nil, fmt.Errorf("btree: IntCodec: got %T, want int", x)
+	}
+	return appendVarint(b, int64(i)), nil
+}
+
+func (intCodec) Decode(b []byte) (interface{}, error) {
+	i, err := decodeVarint(b)
+	return int(i), err
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Append(b []byte, x interface{}) ([]byte, error) {
+	i, ok := x.(int64)
+	if !ok {
+		return nil, fmt.Errorf("btree: Int64Codec: got %T, want int64", x)
+	}
+	return appendVarint(b, i), nil
+}
+
+func (int64Codec) Decode(b []byte) (interface{}, error) {
+	return decodeVarint(b)
+}
+
+func appendVarint(b []byte, i int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], i)]...)
+}
+
+func appendUvarint(b []byte, u uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], u)]...)
+}
+
+func decodeVarint(b []byte) (int64, error) {
+	i, n := binary.Varint(b)
+	if n <= 0 || n != len(b) {
+		return 0, errors.New("btree: bad varint")
+	}
+	return i, nil
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Append(b []byte, x interface{}) ([]byte, error) {
+	s, ok := x.(string)
+	if !ok {
+		return nil, fmt.Errorf("btree: StringCodec: got %T, want string", x)
+	}
+	return append(b, s...), nil
+}
+
+func (stringCodec) Decode(b []byte) (interface{}, error) {
+	return string(b), nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Append(b []byte, x interface{}) ([]byte, error) {
+	p, ok := x.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("btree: BytesCodec: got %T, want []byte", x)
+	}
+	return append(b, p...), nil
+}
+
+func (bytesCodec) Decode(b []byte) (interface{}, error) {
+	return append([]byte(nil), b...), nil
+}
+
+// appendField appends the encoding of x, preceded by its length, to b.
+func appendField(b []byte, c Codec, x interface{}) ([]byte, error) {
+	// Encode into the tail of b, then shift it to make room for the length.
+	start := len(b)
+	b, err := c.Append(b, x)
+	if err != nil {
+		return nil, err
+	}
+	n := len(b) - start
+	var lenbuf [binary.MaxVarintLen64]byte
+	ln := binary.PutUvarint(lenbuf[:], uint64(n))
+	b = append(b, lenbuf[:ln]...)
+	copy(b[start+ln:], b[start:start+n])
+	copy(b[start:], lenbuf[:ln])
+	return b, nil
+}
+
+// readField reads a length-prefixed field from the front of b, as written by appendField.
+// It returns the field's bytes and the remainder of b.
+func readField(b []byte) (field, rest []byte, err error) {
+	n, ln := binary.Uvarint(b)
+	if ln <= 0 || uint64(len(b)-ln) < n {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	b = b[ln:]
+	return b[:n], b[n:], nil
+}
+
+// decodeField decodes a length-prefixed field from the front of b with c.
+func decodeField(b []byte, c Codec) (x interface{}, rest []byte, err error) {
+	field, rest, err := readField(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	x, err = c.Decode(field)
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, rest, nil
+}
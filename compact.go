@@ -0,0 +1,74 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+// Compact rebuilds t so that its nodes are packed with items, releasing the
+// memory of underfull nodes and of spare slice capacity. Each node except the
+// root will hold about fill times the maximum number of items, but no fewer
+// than the minimum; fill must be in the range (0, 1]. A fill of 1 uses the
+// least memory, but the next insertions will split nodes, so a fill somewhat
+// less than 1 suits a tree that will keep growing.
+//
+// Compact takes time linear in the size of t. Nodes that t owns are returned
+// to its free list; nodes shared with clones are left to them.
+func (t *BTree) Compact(fill float64) {
+	if !(fill > 0 && fill <= 1) {
+		panic("btree: Compact fill out of range")
+	}
+	if t.root == nil {
+		return
+	}
+	per := int(fill*float64(t.maxItems()) + 0.5)
+	if per < t.minItems() {
+		per = t.minItems()
+	}
+	s := make([]item, 0, t.Len())
+	s = t.root.appendItems(s)
+	t.cow.freeTree(t.root)
+	t.root = t.cow.buildSorted(s, per, t.minItems())
+	t.appended = false
+	t.count = len(s)
+	t.version++
+	if t.hasher != nil {
+		t.hasher.hash(t.root)
+	}
+}
+
+// appendItems appends the items of the subtree rooted at n to s in order.
+func (n *node) appendItems(s []item) []item {
+	if len(n.children) == 0 {
+		return append(s, n.items...)
+	}
+	for i, c := range n.children {
+		s = c.appendItems(s)
+		if i < len(n.items) && !n.cow.bplus {
+			s = append(s, n.items[i])
+		}
+	}
+	return s
+}
+
+// freeTree frees the nodes of the subtree rooted at n that c owns. The nodes
+// below a node that c doesn't own are older than it, so c doesn't own them
+// either.
+func (c *copyOnWriteContext) freeTree(n *node) {
+	if n.cow != c {
+		return
+	}
+	for _, child := range n.children {
+		c.freeTree(child)
+	}
+	c.freeNode(n)
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompact(t *testing.T) {
+	for _, bplus := range []bool{false, true} {
+		for _, fill := range []float64{0.1, 0.5, 1} {
+			tr := NewWithOptions(4, less, Options{BPlus: bplus})
+			for _, m := range perm(5000) {
+				tr.Set(m.Key, m.Value)
+			}
+			for _, m := range perm(5000) {
+				if m.Key.(int)%10 != 0 {
+					tr.Delete(m.Key)
+				}
+			}
+			want := all(tr.BeforeIndex(0))
+			before := tr.Stats()
+			clone := tr.Clone()
+			tr.Compact(fill)
+			if bplus {
+				checkTree(t, tr)
+			} else {
+				checkTree(t, tr)
+			}
+			if got := all(tr.BeforeIndex(0)); !cmp.Equal(got, want) {
+				t.Fatalf("bplus=%t, fill=%g: contents changed", bplus, fill)
+			}
+			if got := all(clone.BeforeIndex(0)); !cmp.Equal(got, want) {
+				t.Fatalf("bplus=%t, fill=%g: clone changed", bplus, fill)
+			}
+			after := tr.Stats()
+			if after.ItemBytes >= before.ItemBytes {
+				t.Errorf("bplus=%t, fill=%g: item bytes %d, was %d", bplus, fill, after.ItemBytes, before.ItemBytes)
+			}
+			if fill == 1 {
+				// The leaves are as full as they can be while evenly filled.
+				leaves := after.Levels[len(after.Levels)-1]
+				if n := leaves.Fill[FillBuckets-2] + leaves.Fill[FillBuckets-1]; n != leaves.Nodes {
+					t.Errorf("bplus=%t: leaves not full: %+v", bplus, leaves)
+				}
+			}
+			// The tree is still writable.
+			for _, m := range perm(100) {
+				tr.Set(m.Key, m.Value)
+			}
+			if bplus {
+				checkTree(t, tr)
+			} else {
+				checkTree(t, tr)
+			}
+		}
+	}
+}
+
+func TestCompactFree(t *testing.T) {
+	fl := NewFreeList(1000)
+	tr := NewWithFreeList(2, less, fl)
+	for _, m := range perm(200) {
+		tr.Set(m.Key, m.Value)
+	}
+	nodes := tr.Stats().Nodes
+	tr.Compact(1)
+	// Compacting frees the old nodes, and the new tree reuses some of them.
+	if got, want := fl.Len()+int(fl.Hits()), nodes; got != want {
+		t.Errorf("freed %d nodes, want %d", got, want)
+	}
+
+	tr = New(2, less)
+	tr.Compact(0.5)
+	if tr.Len() != 0 {
+		t.Error("empty tree not empty")
+	}
+	tr.Set(1, 1)
+	tr.Delete(1)
+	tr.Compact(0.5)
+	if tr.Len() != 0 || tr.root != nil {
+		t.Error("tree with empty root not empty")
+	}
+}
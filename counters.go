@@ -0,0 +1,99 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import "sync/atomic"
+
+// Counters count the work done by the trees that use them, to help choose a
+// degree or compare workloads. Give Counters to a tree with Options; the
+// tree's clones share them. A tree without Counters does no counting.
+//
+// Counters are safe for concurrent use, but counting slows down every
+// comparison.
+type Counters struct {
+	counts Counts
+}
+
+// Counts holds the values of Counters.
+type Counts struct {
+	Compares uint64 // calls to the less function
+	Allocs   uint64 // nodes created, including copies
+	Copies   uint64 // nodes copied because they were shared with a clone
+	Splits   uint64 // splits of full nodes
+	Steals   uint64 // items moved to an underfull node from a sibling
+	Merges   uint64 // merges of an underfull node with a sibling
+}
+
+// Counts returns the current counts of c.
+func (c *Counters) Counts() Counts {
+	return Counts{
+		Compares: atomic.LoadUint64(&c.counts.Compares),
+		Allocs:   atomic.LoadUint64(&c.counts.Allocs),
+		Copies:   atomic.LoadUint64(&c.counts.Copies),
+		Splits:   atomic.LoadUint64(&c.counts.Splits),
+		Steals:   atomic.LoadUint64(&c.counts.Steals),
+		Merges:   atomic.LoadUint64(&c.counts.Merges),
+	}
+}
+
+// Reset sets the counts of c to zero.
+func (c *Counters) Reset() {
+	for _, p := range []*uint64{
+		&c.counts.Compares, &c.counts.Allocs, &c.counts.Copies,
+		&c.counts.Splits, &c.counts.Steals, &c.counts.Merges,
+	} {
+		atomic.StoreUint64(p, 0)
+	}
+}
+
+// countingLess returns a function that calls less and counts the calls in c.
+func (c *Counters) countingLess(less lessFunc) lessFunc {
+	return func(a, b interface{}) bool {
+		atomic.AddUint64(&c.counts.Compares, 1)
+		return less(a, b)
+	}
+}
+
+// The count methods count an event in the tree of c, if it has counters.
+
+func (c *copyOnWriteContext) countAlloc() {
+	if c.counters != nil {
+		atomic.AddUint64(&c.counters.counts.Allocs, 1)
+	}
+}
+
+func (c *copyOnWriteContext) countCopy() {
+	if c.counters != nil {
+		atomic.AddUint64(&c.counters.counts.Copies, 1)
+	}
+}
+
+func (c *copyOnWriteContext) countSplit() {
+	if c.counters != nil {
+		atomic.AddUint64(&c.counters.counts.Splits, 1)
+	}
+}
+
+func (c *copyOnWriteContext) countSteal() {
+	if c.counters != nil {
+		atomic.AddUint64(&c.counters.counts.Steals, 1)
+	}
+}
+
+func (c *copyOnWriteContext) countMerge() {
+	if c.counters != nil {
+		atomic.AddUint64(&c.counters.counts.Merges, 1)
+	}
+}
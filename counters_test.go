@@ -0,0 +1,81 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import "testing"
+
+func TestCounters(t *testing.T) {
+	var calls uint64
+	countingLess := func(a, b interface{}) bool {
+		calls++
+		return less(a, b)
+	}
+	c := new(Counters)
+	tr := NewWithOptions(2, countingLess, Options{Counters: c})
+	for i := 0; i < 3; i++ {
+		tr.Set(i, i)
+	}
+	if got, want := c.Counts(), (Counts{Compares: calls, Allocs: 1}); got != want {
+		t.Fatalf("before split: got %+v, want %+v", got, want)
+	}
+	// The root is full, so it splits, and gets a new sibling and parent.
+	tr.Set(3, 3)
+	if got, want := c.Counts(), (Counts{Compares: calls, Allocs: 3, Splits: 1}); got != want {
+		t.Fatalf("after split: got %+v, want %+v", got, want)
+	}
+
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	c.Reset()
+	calls = 0
+	if got := c.Counts(); got != (Counts{}) {
+		t.Fatalf("after Reset: %+v", got)
+	}
+	// A write of a new key to a clone copies the path to a leaf, and the clone
+	// shares the counters.
+	clone := tr.Clone()
+	clone.Set(100, 0)
+	if got, want := c.Counts().Copies, uint64(tr.Stats().Height); got != want {
+		t.Errorf("copies: got %d, want %d", got, want)
+	}
+	for _, m := range perm(100) {
+		tr.Delete(m.Key)
+	}
+	checkTree(t, tr)
+	cs := c.Counts()
+	if cs.Steals == 0 || cs.Merges == 0 || cs.Compares != calls {
+		t.Errorf("after deletes: got %+v, with %d comparisons", cs, calls)
+	}
+}
+
+func TestCountersBPlus(t *testing.T) {
+	c := new(Counters)
+	tr := NewWithOptions(2, less, Options{BPlus: true, Counters: c})
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	for _, m := range perm(100) {
+		tr.Delete(m.Key)
+	}
+	cs := c.Counts()
+	if cs.Splits == 0 || cs.Steals == 0 || cs.Merges == 0 {
+		t.Errorf("got %+v", cs)
+	}
+	// A node is allocated by each split, by each new root, and for the first root.
+	if cs.Allocs < cs.Splits+1 {
+		t.Errorf("%d allocs for %d splits", cs.Allocs, cs.Splits)
+	}
+}
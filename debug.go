@@ -16,6 +16,8 @@
 package btree
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
@@ -36,3 +38,234 @@ func (n *node) print(w io.Writer, level int) {
 		c.print(w, level+1)
 	}
 }
+
+// DOTOptions holds options for WriteDOT and WriteStructureJSON.
+type DOTOptions struct {
+	// MaxDepth is the number of levels below the root to draw. The children of
+	// a node at the last level are summarized in a single box. Zero means no
+	// limit.
+	MaxDepth int
+
+	// MaxItems is the number of items to show in each node; the rest are
+	// counted. Zero means no limit.
+	MaxItems int
+
+	// Others holds more trees, usually clones of the tree being written, to draw
+	// in the same graph. Nodes that trees share are drawn once, with edges from
+	// each tree that has them. WriteStructureJSON ignores it.
+	Others []*BTree
+}
+
+// dotColors are the fill colors of the nodes owned by each tree drawn by
+// WriteDOT, in order. Nodes that no tree owns are gray.
+var dotColors = []string{"lightblue", "palegreen", "lightpink", "khaki", "plum", "lightsalmon"}
+
+// WriteDOT writes a drawing of the structure of t to w in the DOT language of
+// Graphviz (https://graphviz.org). Each node shows its keys, its size and its
+// copy-on-write owner, which is either one of the trees drawn or a context that
+// no tree owns any more, as with the nodes that Clone leaves shared. Nodes are
+// colored by owner. In a B+ tree, the links between leaves with the same owner
+// are drawn as dotted edges. A nil opts is the same as the zero DOTOptions.
+func (t *BTree) WriteDOT(w io.Writer, opts *DOTOptions) error {
+	d := &dotWriter{
+		ids:    map[*node]int{},
+		owners: map[*copyOnWriteContext]string{},
+		colors: map[*copyOnWriteContext]string{},
+	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	trees := append([]*BTree{t}, d.opts.Others...)
+	for i, tr := range trees {
+		if _, ok := d.owners[tr.cow]; !ok {
+			d.owners[tr.cow] = fmt.Sprintf("tree %d", i)
+			d.colors[tr.cow] = dotColors[i%len(dotColors)]
+		}
+	}
+	d.printf("digraph btree {\n")
+	d.printf("\tnode [shape=box, style=filled, fontname=monospace];\n")
+	for i, tr := range trees {
+		d.printf("\ttree%d [shape=plaintext, style=\"\", label=\"tree %d\\nlen %d\"];\n", i, i, tr.Len())
+		if tr.root != nil {
+			d.printf("\ttree%d -> n%d;\n", i, d.node(tr.root, 0))
+		}
+	}
+	for _, l := range d.leaves {
+		if id, ok := d.ids[l.next]; ok && l.next.cow == l.cow {
+			d.printf("\tn%d -> n%d [style=dotted, constraint=false];\n", d.ids[l], id)
+		}
+	}
+	d.printf("}\n")
+	_, err := w.Write(d.buf.Bytes())
+	return err
+}
+
+// dotWriter holds the state of a call to WriteDOT.
+type dotWriter struct {
+	buf    bytes.Buffer
+	opts   DOTOptions
+	ids    map[*node]int
+	owners map[*copyOnWriteContext]string
+	colors map[*copyOnWriteContext]string
+	leaves []*node // B+ tree leaves drawn
+	nextID int
+	shared int // number of owners that are not trees
+}
+
+func (d *dotWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&d.buf, format, args...)
+}
+
+// node draws n, which is at the given depth, and the part of its subtree that
+// the options allow, unless it has already been drawn. It returns n's ID.
+func (d *dotWriter) node(n *node, depth int) int {
+	if id, ok := d.ids[n]; ok {
+		return id
+	}
+	id := d.nextID
+	d.nextID++
+	d.ids[n] = id
+
+	var label strings.Builder
+	shown := len(n.items)
+	if d.opts.MaxItems > 0 && shown > d.opts.MaxItems {
+		shown = d.opts.MaxItems
+	}
+	for i, m := range n.items[:shown] {
+		if i > 0 {
+			label.WriteString(" ")
+		}
+		fmt.Fprint(&label, m.key)
+	}
+	if shown < len(n.items) {
+		fmt.Fprintf(&label, " ... (%d more)", len(n.items)-shown)
+	}
+	if !n.cow.noIndex {
+		fmt.Fprintf(&label, "\nsize %d", n.size)
+	}
+	owner, ok := d.owners[n.cow]
+	if !ok {
+		d.shared++
+		owner = fmt.Sprintf("shared %d", d.shared)
+		d.owners[n.cow] = owner
+	}
+	color := d.colors[n.cow]
+	if color == "" {
+		color = "lightgray"
+	}
+	fmt.Fprintf(&label, "\n%s", owner)
+	d.printf("\tn%d [label=%s, fillcolor=%s];\n", id, dotQuote(label.String()), color)
+
+	if len(n.children) == 0 {
+		if n.cow.bplus {
+			d.leaves = append(d.leaves, n)
+		}
+		return id
+	}
+	if d.opts.MaxDepth > 0 && depth >= d.opts.MaxDepth {
+		summary := fmt.Sprintf("%d children", len(n.children))
+		if !n.cow.noIndex {
+			summary += fmt.Sprintf("\nsize %d", n.childrenSize(len(n.children)))
+		}
+		d.printf("\tn%d_more [shape=plaintext, style=\"\", label=%s];\n", id, dotQuote(summary))
+		d.printf("\tn%d -> n%d_more [style=dashed];\n", id, id)
+		return id
+	}
+	for _, c := range n.children {
+		d.printf("\tn%d -> n%d;\n", id, d.node(c, depth+1))
+	}
+	return id
+}
+
+// dotQuote returns s as a DOT string, with newlines as line breaks.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// WriteStructureJSON writes the structure of t to w as JSON, for tools that
+// display or analyze trees. The output is an object with the tree's degree,
+// mode and length, and its root node, if any. Each node is an object with its
+// keys, its values (except for the separators of a B+ tree), its size (unless
+// t was created with NoIndex), its owner and its children. The owner is 0 for
+// the nodes t owns; nodes owned by the same other copy-on-write context, such
+// as those that Clone leaves shared, have the same positive number.
+//
+// The MaxDepth and MaxItems options limit the output as they do for WriteDOT.
+// A node with more items than MaxItems has only the first MaxItems keys and
+// values, and a moreItems field with the number left out. A node at depth
+// MaxDepth has no children, but fields giving the number of children left out
+// and, unless t was created with NoIndex, the number of items in their
+// subtrees. A nil opts is the same as the zero DOTOptions.
+//
+// Keys and values are encoded as if by json.Marshal.
+func (t *BTree) WriteStructureJSON(w io.Writer, opts *DOTOptions) error {
+	var o DOTOptions
+	if opts != nil {
+		o = *opts
+	}
+	owners := map[*copyOnWriteContext]int{t.cow: 0}
+	var conv func(n *node, depth int) *jsonNode
+	conv = func(n *node, depth int) *jsonNode {
+		j := &jsonNode{Keys: []interface{}{}}
+		shown := n.items
+		if o.MaxItems > 0 && len(shown) > o.MaxItems {
+			shown = shown[:o.MaxItems]
+			j.MoreItems = len(n.items) - len(shown)
+		}
+		for _, m := range shown {
+			j.Keys = append(j.Keys, m.key)
+		}
+		if !n.cow.bplus || len(n.children) == 0 {
+			j.Values = []interface{}{}
+			for _, m := range shown {
+				j.Values = append(j.Values, m.value)
+			}
+		}
+		if !n.cow.noIndex {
+			sz := n.size
+			j.Size = &sz
+		}
+		owner, ok := owners[n.cow]
+		if !ok {
+			owner = len(owners)
+			owners[n.cow] = owner
+		}
+		j.Owner = owner
+		if len(n.children) > 0 && o.MaxDepth > 0 && depth >= o.MaxDepth {
+			j.MoreChildren = len(n.children)
+			if !n.cow.noIndex {
+				j.MoreSize = n.childrenSize(len(n.children))
+			}
+			return j
+		}
+		for _, c := range n.children {
+			j.Children = append(j.Children, conv(c, depth+1))
+		}
+		return j
+	}
+	s := jsonStructure{Degree: t.degree, BPlus: t.cow.bplus, Len: t.Len()}
+	if t.root != nil {
+		s.Root = conv(t.root, 0)
+	}
+	return json.NewEncoder(w).Encode(s)
+}
+
+type jsonStructure struct {
+	Degree int       `json:"degree"`
+	BPlus  bool      `json:"bplus"`
+	Len    int       `json:"len"`
+	Root   *jsonNode `json:"root,omitempty"`
+}
+
+type jsonNode struct {
+	Keys     []interface{} `json:"keys"`
+	Values   []interface{} `json:"values,omitempty"`
+	Size     *int          `json:"size,omitempty"`
+	Owner    int           `json:"owner"`
+	Children []*jsonNode   `json:"children,omitempty"`
+	// Set for what the options leave out.
+	MoreItems    int `json:"moreItems,omitempty"`
+	MoreChildren int `json:"moreChildren,omitempty"`
+	MoreSize     int `json:"moreSize,omitempty"` // items in the subtrees of the children left out
+}
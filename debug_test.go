@@ -0,0 +1,205 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	tr := New(2, less)
+	for _, m := range rang(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	clone := tr.Clone()
+	clone.Set(1000, 1000)
+
+	var buf bytes.Buffer
+	if err := tr.WriteDOT(&buf, &DOTOptions{Others: []*BTree{clone}}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "digraph btree {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Fatalf("not a digraph:\n%s", out)
+	}
+	// Shared nodes are drawn once.
+	s := clone.Stats()
+	if got, want := strings.Count(out, "fillcolor="), tr.Stats().Nodes+s.Nodes-s.Shared; got != want {
+		t.Errorf("drew %d nodes, want %d", got, want)
+	}
+	if got, want := strings.Count(out, `\ntree 1"`), s.Nodes-s.Shared; got != want {
+		t.Errorf("%d nodes owned by the clone, want %d", got, want)
+	}
+	for _, want := range []string{"tree0 -> n0;", `label="tree 1\nlen 101"`, `\nshared 1"`, `\nsize 100\n`, "fillcolor=lightgray"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+	if strings.Contains(out, `\ntree 0"`) {
+		t.Error("tree 0 owns nodes after Clone")
+	}
+
+	buf.Reset()
+	if err := tr.WriteDOT(&buf, &DOTOptions{MaxDepth: 1, MaxItems: 1}); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	if got := strings.Count(out, "fillcolor="); got != 1+len(tr.root.children) {
+		t.Errorf("MaxDepth 1: drew %d nodes\n%s", got, out)
+	}
+	if !strings.Contains(out, "_more [shape=plaintext") || !strings.Contains(out, "more)") {
+		t.Errorf("missing summaries:\n%s", out)
+	}
+}
+
+func TestWriteDOTBPlus(t *testing.T) {
+	tr := newBPlus(2)
+	for _, m := range rang(20) {
+		tr.Set(m.Key, m.Value)
+	}
+	var buf bytes.Buffer
+	if err := tr.WriteDOT(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(buf.String(), "style=dotted"), tr.Stats().Leaves-1; got != want {
+		t.Errorf("drew %d leaf links, want %d", got, want)
+	}
+	if err := New(2, less).WriteDOT(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteStructureJSON(t *testing.T) {
+	tr := New(2, less)
+	for _, m := range rang(20) {
+		tr.Set(m.Key, m.Value)
+	}
+	clone := tr.Clone()
+	clone.Set(0, -1)
+
+	type node struct {
+		Keys     []int
+		Values   []int
+		Size     *int
+		Owner    int
+		Children []*node
+	}
+	var got struct {
+		Degree int
+		BPlus  bool
+		Len    int
+		Root   *node
+	}
+	var buf bytes.Buffer
+	if err := clone.WriteStructureJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Degree != 2 || got.BPlus || got.Len != 20 {
+		t.Errorf("got degree %d, bplus %t, len %d", got.Degree, got.BPlus, got.Len)
+	}
+	// Walk the tree, checking contents and that only the path to 0 is owned by
+	// the clone.
+	var keys []int
+	var walk func(n *node, owned bool)
+	walk = func(n *node, owned bool) {
+		if (n.Owner == 0) != owned {
+			t.Errorf("node %v has owner %d", n.Keys, n.Owner)
+		}
+		if n.Size == nil || len(n.Values) != len(n.Keys) {
+			t.Fatalf("node %v: missing size or values", n.Keys)
+		}
+		for i, k := range n.Keys {
+			if len(n.Children) > 0 {
+				walk(n.Children[i], owned && i == 0)
+			}
+			keys = append(keys, k)
+		}
+		if len(n.Children) > 0 {
+			walk(n.Children[len(n.Children)-1], false)
+		}
+	}
+	walk(got.Root, true)
+	if len(keys) != 20 || keys[0] != 0 || keys[19] != 19 {
+		t.Errorf("got keys %v", keys)
+	}
+
+	buf.Reset()
+	if err := NewWithOptions(2, less, Options{NoIndex: true}).WriteStructureJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"degree":2,"bplus":false,"len":0}`+"\n"; got != want {
+		t.Errorf("empty tree: got %q, want %q", got, want)
+	}
+}
+
+func TestWriteStructureJSONLimits(t *testing.T) {
+	type node struct {
+		Keys         []int
+		Values       []int
+		Size         *int
+		Children     []*node
+		MoreItems    int
+		MoreChildren int
+		MoreSize     *int
+	}
+	for _, noIndex := range []bool{false, true} {
+		tr := NewWithOptions(2, less, Options{NoIndex: noIndex})
+		for _, m := range rang(100) {
+			tr.Set(m.Key, m.Value)
+		}
+		var buf bytes.Buffer
+		if err := tr.WriteStructureJSON(&buf, &DOTOptions{MaxDepth: 1, MaxItems: 1}); err != nil {
+			t.Fatal(err)
+		}
+		var got struct{ Root *node }
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		// Every item is either shown or counted.
+		count := func(n *node) int { return len(n.Keys) + n.MoreItems }
+		root := got.Root
+		if len(root.Keys) != 1 || len(root.Values) != 1 || count(root) != len(tr.root.items) {
+			t.Errorf("noIndex=%t: root has keys %v and %d more, want 1 of %d", noIndex, root.Keys, root.MoreItems, len(tr.root.items))
+		}
+		if len(root.Children) != len(tr.root.children) || root.MoreChildren != 0 {
+			t.Fatalf("noIndex=%t: root has %d children and %d more", noIndex, len(root.Children), root.MoreChildren)
+		}
+		total := count(root)
+		for i, c := range root.Children {
+			if len(c.Keys) > 1 || len(c.Children) != 0 || c.MoreChildren != len(tr.root.children[i].children) {
+				t.Errorf("noIndex=%t: child %d has keys %v, %d children and %d more", noIndex, i, c.Keys, len(c.Children), c.MoreChildren)
+			}
+			if noIndex {
+				if c.MoreSize != nil {
+					t.Errorf("child %d has a size without an index", i)
+				}
+				continue
+			}
+			if c.MoreSize == nil || *c.Size != count(c)+*c.MoreSize {
+				t.Errorf("child %d: size %d, but %d items and %v more below", i, *c.Size, count(c), c.MoreSize)
+			}
+			total += *c.Size
+		}
+		if !noIndex && total != 100 {
+			t.Errorf("counted %d items, want 100", total)
+		}
+	}
+}
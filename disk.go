@@ -0,0 +1,1085 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+// A DiskBTree is a B+tree stored in fixed-size pages of a local file. Only the
+// pages in use are held in memory, in an LRU cache of bounded size, so a
+// DiskBTree can hold more data than fits in RAM.
+//
+// All items are stored in leaf pages. Internal pages hold separator keys and,
+// for each child, the number of items in its subtree, so that positional
+// operations like At and BeforeIndex take time proportional to the height of
+// the tree.
+//
+// Changes are grouped into transactions. Pages are never modified once they
+// have been committed: a write copies each page on the path from the root to the
+// affected leaf, as writes to a cloned BTree do. Commit writes the new pages and
+// then, in a single small write, a new meta record pointing to the new root. If
+// the process crashes, the file is left in the state of the last successful
+// Commit.
+//
+// The file format begins with a page holding two meta records, at offsets 0 and
+// 512, which are written alternately. The meta record with the highest
+// transaction number and a valid checksum is current.
+//
+// A DiskBTree is not safe for concurrent use.
+type DiskBTree struct {
+	f         *os.File
+	less      lessFunc
+	kc, vc    Codec
+	pageSize  int
+	cacheSize int
+	maxEntry  int // largest encoded leaf entry allowed
+
+	txid          uint64 // of the last commit
+	root          uint64 // page number of root, or 0 if the tree is empty
+	count         int
+	npages        uint64          // number of pages in the file, including the meta page
+	free          []uint64        // pages that can be allocated
+	pendingFree   []uint64        // pages freed in this transaction, which can be allocated after the commit
+	fresh         map[uint64]bool // pages allocated in this transaction, which can be modified in place
+	freelistPages []uint64        // pages holding the committed free list
+	changed       bool            // there are uncommitted changes
+
+	cache map[uint64]*list.Element // values are *diskPage
+	lru   *list.List               // front is most recently used
+}
+
+// DiskOptions configure a DiskBTree.
+type DiskOptions struct {
+	// PageSize is the size of a page in bytes. It must be a multiple of 512
+	// between 1024 and 65536. It is fixed when the file is created. If zero, the page size
+	// of an existing file is used, and new files get 4096.
+	PageSize int
+
+	// CacheSize is the maximum number of pages to keep in memory between operations.
+	// If zero, it is 1024.
+	CacheSize int
+}
+
+const (
+	diskMagic        = "jbabdisk"
+	diskVersion      = 1
+	diskMetaSize     = 60
+	diskMeta1Offset  = 512
+	defaultPageSize  = 4096
+	defaultCacheSize = 1024
+	minCacheSize     = 16
+
+	// Every page begins with a header of a type byte, an unused byte, a 2-byte count
+	// and a 4-byte CRC-32 of the rest of the page.
+	pageHeaderSize = 8
+	pageLeaf       = 1 // count items, each a length-prefixed key and length-prefixed value
+	pageInternal   = 2 // count children, each an 8-byte page number and 8-byte size; then count-1 length-prefixed keys
+	pageFreelist   = 3 // 8-byte page number of next freelist page; then count 8-byte page numbers
+)
+
+// ErrCorruptDisk is returned when a DiskBTree's file is malformed.
+var ErrCorruptDisk = errors.New("btree: corrupt disk tree file")
+
+// ErrItemTooLarge is returned by DiskBTree.Set when a key and value are too
+// large to fit four to a page.
+var ErrItemTooLarge = errors.New("btree: item too large for page size")
+
+// A diskPage is the in-memory form of a leaf or internal page.
+type diskPage struct {
+	id       uint64
+	leaf     bool
+	keys     []Key    // decoded keys
+	kbytes   [][]byte // encoded keys
+	vbytes   [][]byte // encoded values, for leaves
+	children []uint64 // for internal pages
+	sizes    []uint64 // sizes[i] is the number of items under children[i]
+	nbytes   int      // size when encoded
+	dirty    bool     // differs from the file
+
+	// For an internal page just split off from another, the separator that
+	// moved up to the parent.
+	promoted      Key
+	promotedBytes []byte
+}
+
+// OpenDisk opens the DiskBTree in the file at path, creating it if it doesn't
+// exist. Keys are ordered by less and encoded with keyCodec; values are encoded
+// with valueCodec. If opts is nil, defaults are used.
+func OpenDisk(path string, less func(interface{}, interface{}) bool, keyCodec, valueCodec Codec, opts *DiskOptions) (*DiskBTree, error) {
+	if opts == nil {
+		opts = &DiskOptions{}
+	}
+	if opts.PageSize != 0 && (opts.PageSize < 1024 || opts.PageSize > 65536 || opts.PageSize%512 != 0) {
+		return nil, fmt.Errorf("btree: bad page size %d", opts.PageSize)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	t := &DiskBTree{
+		f:         f,
+		less:      less,
+		kc:        keyCodec,
+		vc:        valueCodec,
+		cacheSize: opts.CacheSize,
+		fresh:     map[uint64]bool{},
+		cache:     map[uint64]*list.Element{},
+		lru:       list.New(),
+	}
+	if t.cacheSize == 0 {
+		t.cacheSize = defaultCacheSize
+	}
+	if t.cacheSize < minCacheSize {
+		t.cacheSize = minCacheSize
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		if fi.Size() == 0 {
+			err = t.create(opts.PageSize)
+		} else {
+			err = t.open(opts.PageSize)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.maxEntry = (t.pageSize - pageHeaderSize) / 4
+	return t, nil
+}
+
+// create initializes a new file.
+func (t *DiskBTree) create(pageSize int) error {
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	t.pageSize = pageSize
+	t.npages = 1
+	// Write both meta records, so neither slot holds garbage.
+	if err := t.writeMeta(0); err != nil {
+		return err
+	}
+	t.txid = 1
+	if err := t.writeMeta(0); err != nil {
+		return err
+	}
+	return t.f.Sync()
+}
+
+// open reads the current meta record and free list of an existing file.
+func (t *DiskBTree) open(pageSize int) error {
+	var best *diskMeta
+	for _, off := range []int64{0, diskMeta1Offset} {
+		var buf [diskMetaSize]byte
+		if _, err := t.f.ReadAt(buf[:], off); err != nil {
+			continue
+		}
+		if m, ok := decodeMeta(buf[:]); ok && (best == nil || m.txid > best.txid) {
+			best = &m
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("%w: no valid meta record", ErrCorruptDisk)
+	}
+	if pageSize != 0 && pageSize != best.pageSize {
+		return fmt.Errorf("btree: file has page size %d, not %d", best.pageSize, pageSize)
+	}
+	t.pageSize = best.pageSize
+	t.txid = best.txid
+	t.root = best.root
+	t.count = int(best.count)
+	t.npages = best.npages
+	for id := best.freelist; id != 0; {
+		buf, err := t.readPage(id, pageFreelist)
+		if err != nil {
+			return err
+		}
+		t.freelistPages = append(t.freelistPages, id)
+		n := int(binary.BigEndian.Uint16(buf[2:]))
+		id = binary.BigEndian.Uint64(buf[pageHeaderSize:])
+		for i := 0; i < n; i++ {
+			t.free = append(t.free, binary.BigEndian.Uint64(buf[pageHeaderSize+8+8*i:]))
+		}
+	}
+	return nil
+}
+
+// diskMeta is a meta record. Its encoding is the magic number, a version byte,
+// three unused bytes, the page size as 4 bytes, five 8-byte fields, and a CRC-32
+// of the preceding bytes, all big-endian.
+type diskMeta struct {
+	pageSize int
+	txid     uint64
+	root     uint64
+	count    uint64
+	npages   uint64
+	freelist uint64 // first page of free list, or 0
+}
+
+func decodeMeta(b []byte) (diskMeta, bool) {
+	var m diskMeta
+	if string(b[:8]) != diskMagic || b[8] != diskVersion {
+		return m, false
+	}
+	if crc32.ChecksumIEEE(b[:diskMetaSize-4]) != binary.BigEndian.Uint32(b[diskMetaSize-4:]) {
+		return m, false
+	}
+	m.pageSize = int(binary.BigEndian.Uint32(b[12:]))
+	m.txid = binary.BigEndian.Uint64(b[16:])
+	m.root = binary.BigEndian.Uint64(b[24:])
+	m.count = binary.BigEndian.Uint64(b[32:])
+	m.npages = binary.BigEndian.Uint64(b[40:])
+	m.freelist = binary.BigEndian.Uint64(b[48:])
+	return m, true
+}
+
+// writeMeta writes the meta record for the current state into the slot for t.txid.
+func (t *DiskBTree) writeMeta(freelist uint64) error {
+	var b [diskMetaSize]byte
+	copy(b[:], diskMagic)
+	b[8] = diskVersion
+	binary.BigEndian.PutUint32(b[12:], uint32(t.pageSize))
+	binary.BigEndian.PutUint64(b[16:], t.txid)
+	binary.BigEndian.PutUint64(b[24:], t.root)
+	binary.BigEndian.PutUint64(b[32:], uint64(t.count))
+	binary.BigEndian.PutUint64(b[40:], t.npages)
+	binary.BigEndian.PutUint64(b[48:], freelist)
+	binary.BigEndian.PutUint32(b[diskMetaSize-4:], crc32.ChecksumIEEE(b[:diskMetaSize-4]))
+	off := int64(0)
+	if t.txid%2 == 1 {
+		off = diskMeta1Offset
+	}
+	_, err := t.f.WriteAt(b[:], off)
+	return err
+}
+
+// Len returns the number of items in the tree.
+func (t *DiskBTree) Len() int {
+	return t.count
+}
+
+// Commit makes the changes since the last commit durable. If Commit returns an
+// error, the DiskBTree should be closed and reopened, which will restore the state
+// of the last successful commit.
+func (t *DiskBTree) Commit() error {
+	if !t.changed {
+		return nil
+	}
+	// Write the new pages.
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		if err := t.flush(e.Value.(*diskPage)); err != nil {
+			return err
+		}
+	}
+	// Write the new free list. After this commit, the pages freed in this
+	// transaction and the pages of the old free list will be free. The new free
+	// list can only be stored in pages that are free now.
+	perPage := uint64(t.pageSize-pageHeaderSize-8) / 8
+	entries := append(append(append([]uint64(nil), t.free...), t.pendingFree...), t.freelistPages...)
+	var flPages []uint64
+	for uint64(len(entries)) > uint64(len(flPages))*perPage {
+		if len(t.free) > len(flPages) {
+			// Take a page that was free in the last commit. It's at the start of
+			// entries, so drop it from there.
+			flPages = append(flPages, entries[0])
+			entries = entries[1:]
+		} else {
+			flPages = append(flPages, t.npages)
+			t.npages++
+		}
+	}
+	for i, id := range flPages {
+		buf := make([]byte, t.pageSize)
+		buf[0] = pageFreelist
+		n := uint64(len(entries))
+		if n > perPage {
+			n = perPage
+		}
+		binary.BigEndian.PutUint16(buf[2:], uint16(n))
+		if i+1 < len(flPages) {
+			binary.BigEndian.PutUint64(buf[pageHeaderSize:], flPages[i+1])
+		}
+		for j := uint64(0); j < n; j++ {
+			binary.BigEndian.PutUint64(buf[pageHeaderSize+8+8*j:], entries[j])
+		}
+		entries = entries[n:]
+		if err := t.writePage(id, buf); err != nil {
+			return err
+		}
+	}
+	if err := t.f.Sync(); err != nil {
+		return err
+	}
+	var freelist uint64
+	if len(flPages) > 0 {
+		freelist = flPages[0]
+	}
+	t.txid++
+	if err := t.writeMeta(freelist); err != nil {
+		return err
+	}
+	if err := t.f.Sync(); err != nil {
+		return err
+	}
+	// Now the commit is durable.
+	if len(flPages) < len(t.free) {
+		t.free = t.free[len(flPages):]
+	} else {
+		t.free = nil
+	}
+	t.free = append(append(t.free, t.pendingFree...), t.freelistPages...)
+	t.pendingFree = nil
+	t.freelistPages = flPages
+	t.fresh = map[uint64]bool{}
+	t.changed = false
+	return nil
+}
+
+// Close commits any changes and closes the file.
+func (t *DiskBTree) Close() error {
+	err := t.Commit()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readPage reads page id from the file and checks its header.
+func (t *DiskBTree) readPage(id uint64, typ byte) ([]byte, error) {
+	if id == 0 || id >= t.npages {
+		return nil, fmt.Errorf("%w: page %d out of range", ErrCorruptDisk, id)
+	}
+	buf := make([]byte, t.pageSize)
+	if _, err := t.f.ReadAt(buf, int64(id)*int64(t.pageSize)); err != nil {
+		return nil, err
+	}
+	if crc := pageCRC(buf); crc != binary.BigEndian.Uint32(buf[4:]) {
+		return nil, fmt.Errorf("%w: page %d: bad checksum", ErrCorruptDisk, id)
+	}
+	if typ != 0 && buf[0] != typ {
+		return nil, fmt.Errorf("%w: page %d: got type %d, want %d", ErrCorruptDisk, id, buf[0], typ)
+	}
+	return buf, nil
+}
+
+// writePage sets the checksum of buf and writes it to page id.
+func (t *DiskBTree) writePage(id uint64, buf []byte) error {
+	binary.BigEndian.PutUint32(buf[4:], pageCRC(buf))
+	_, err := t.f.WriteAt(buf, int64(id)*int64(t.pageSize))
+	return err
+}
+
+func pageCRC(buf []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(buf[:4]), crc32.IEEETable, buf[pageHeaderSize:])
+}
+
+// load returns the page with the given id, from the cache if possible.
+func (t *DiskBTree) load(id uint64) (*diskPage, error) {
+	if e, ok := t.cache[id]; ok {
+		t.lru.MoveToFront(e)
+		return e.Value.(*diskPage), nil
+	}
+	buf, err := t.readPage(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	p, err := t.decodePage(id, buf)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[id] = t.lru.PushFront(p)
+	return p, nil
+}
+
+func (t *DiskBTree) decodePage(id uint64, buf []byte) (*diskPage, error) {
+	p := &diskPage{id: id, nbytes: pageHeaderSize}
+	n := int(binary.BigEndian.Uint16(buf[2:]))
+	b := buf[pageHeaderSize:]
+	bad := func(what string) error {
+		return fmt.Errorf("%w: page %d: %s", ErrCorruptDisk, id, what)
+	}
+	addKey := func() error {
+		kb, rest, err := readField(b)
+		if err != nil {
+			return bad("truncated key")
+		}
+		k, err := t.kc.Decode(kb)
+		if err != nil {
+			return bad(err.Error())
+		}
+		p.kbytes = append(p.kbytes, kb)
+		p.keys = append(p.keys, k)
+		p.nbytes += len(b) - len(rest)
+		b = rest
+		return nil
+	}
+	switch buf[0] {
+	case pageLeaf:
+		p.leaf = true
+		for i := 0; i < n; i++ {
+			if err := addKey(); err != nil {
+				return nil, err
+			}
+			vb, rest, err := readField(b)
+			if err != nil {
+				return nil, bad("truncated value")
+			}
+			p.vbytes = append(p.vbytes, vb)
+			p.nbytes += len(b) - len(rest)
+			b = rest
+		}
+	case pageInternal:
+		if n == 0 || len(b) < 16*n {
+			return nil, bad("bad child count")
+		}
+		for i := 0; i < n; i++ {
+			p.children = append(p.children, binary.BigEndian.Uint64(b[16*i:]))
+			p.sizes = append(p.sizes, binary.BigEndian.Uint64(b[16*i+8:]))
+		}
+		p.nbytes += 16 * n
+		b = b[16*n:]
+		for i := 0; i < n-1; i++ {
+			if err := addKey(); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, bad(fmt.Sprintf("unexpected type %d", buf[0]))
+	}
+	return p, nil
+}
+
+func (t *DiskBTree) encodePage(p *diskPage) []byte {
+	buf := make([]byte, pageHeaderSize, t.pageSize)
+	if p.leaf {
+		buf[0] = pageLeaf
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(p.keys)))
+		for i, kb := range p.kbytes {
+			buf = appendBytesField(buf, kb)
+			buf = appendBytesField(buf, p.vbytes[i])
+		}
+	} else {
+		buf[0] = pageInternal
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(p.children)))
+		for i, c := range p.children {
+			var b [16]byte
+			binary.BigEndian.PutUint64(b[:], c)
+			binary.BigEndian.PutUint64(b[8:], p.sizes[i])
+			buf = append(buf, b[:]...)
+		}
+		for _, kb := range p.kbytes {
+			buf = appendBytesField(buf, kb)
+		}
+	}
+	return buf[:t.pageSize]
+}
+
+func appendBytesField(b, field []byte) []byte {
+	return append(appendUvarint(b, uint64(len(field))), field...)
+}
+
+// fieldSize returns the encoded size of a length-prefixed field.
+func fieldSize(b []byte) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(len(b))) + len(b)
+}
+
+// flush writes p to the file if it is dirty.
+func (t *DiskBTree) flush(p *diskPage) error {
+	if !p.dirty {
+		return nil
+	}
+	if err := t.writePage(p.id, t.encodePage(p)); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+// trim evicts least recently used pages until the cache is no larger than its
+// maximum size. It is called only between operations, so the pages an operation
+// is working on are never evicted out from under it.
+func (t *DiskBTree) trim() error {
+	for len(t.cache) > t.cacheSize {
+		e := t.lru.Back()
+		p := e.Value.(*diskPage)
+		// Dirty pages were allocated in this transaction, so it is safe to write
+		// them before the commit.
+		if err := t.flush(p); err != nil {
+			return err
+		}
+		t.lru.Remove(e)
+		delete(t.cache, p.id)
+	}
+	return nil
+}
+
+// alloc returns a new, empty page that can be modified in place.
+func (t *DiskBTree) alloc(leaf bool) *diskPage {
+	var id uint64
+	if n := len(t.free); n > 0 {
+		id = t.free[n-1]
+		t.free = t.free[:n-1]
+	} else {
+		id = t.npages
+		t.npages++
+	}
+	t.fresh[id] = true
+	p := &diskPage{id: id, leaf: leaf, nbytes: pageHeaderSize, dirty: true}
+	t.cache[id] = t.lru.PushFront(p)
+	return p
+}
+
+// release frees page p.
+func (t *DiskBTree) release(p *diskPage) {
+	if t.fresh[p.id] {
+		// Never committed, so it can be reused at once.
+		delete(t.fresh, p.id)
+		t.free = append(t.free, p.id)
+	} else {
+		t.pendingFree = append(t.pendingFree, p.id)
+	}
+	if e, ok := t.cache[p.id]; ok {
+		t.lru.Remove(e)
+		delete(t.cache, p.id)
+	}
+}
+
+// writable returns a version of p that can be modified: p itself if it was
+// allocated in this transaction, or else a copy.
+func (t *DiskBTree) writable(p *diskPage) *diskPage {
+	if t.fresh[p.id] {
+		p.dirty = true
+		return p
+	}
+	c := t.alloc(p.leaf)
+	c.keys = append([]Key(nil), p.keys...)
+	c.kbytes = append([][]byte(nil), p.kbytes...)
+	c.vbytes = append([][]byte(nil), p.vbytes...)
+	c.children = append([]uint64(nil), p.children...)
+	c.sizes = append([]uint64(nil), p.sizes...)
+	c.nbytes = p.nbytes
+	t.release(p)
+	return c
+}
+
+// search returns the index of the first key in p that is not less than k, and
+// whether that key equals k.
+func (p *diskPage) search(k Key, less lessFunc) (int, bool) {
+	i := sort.Search(len(p.keys), func(i int) bool { return !less(p.keys[i], k) })
+	return i, i < len(p.keys) && !less(k, p.keys[i])
+}
+
+// childIndex returns the index of the child of internal page p whose subtree
+// would hold k. Each separator key is the smallest key of the subtree to its right.
+func (p *diskPage) childIndex(k Key, less lessFunc) int {
+	return sort.Search(len(p.keys), func(i int) bool { return less(k, p.keys[i]) })
+}
+
+// A diskCursor is a position in a page.
+type diskCursor struct {
+	p *diskPage
+	i int // index of item in a leaf, or of child in an internal page
+}
+
+// descend returns the path from the root to the leaf where k belongs, along with
+// the index of k in the tree (or where it would be inserted) and whether it is present.
+// It returns a nil path if the tree is empty.
+func (t *DiskBTree) descend(k Key) (path []diskCursor, idx int, found bool, err error) {
+	for id := t.root; id != 0; {
+		p, err := t.load(id)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if p.leaf {
+			i, found := p.search(k, t.less)
+			return append(path, diskCursor{p, i}), idx + i, found, nil
+		}
+		i := p.childIndex(k, t.less)
+		for _, sz := range p.sizes[:i] {
+			idx += int(sz)
+		}
+		path = append(path, diskCursor{p, i})
+		id = p.children[i]
+	}
+	return nil, 0, false, nil
+}
+
+// descendIndex returns the path from the root to the item with index i,
+// which must be in the range [0, t.Len()).
+func (t *DiskBTree) descendIndex(i int) ([]diskCursor, error) {
+	var path []diskCursor
+	for id := t.root; ; {
+		p, err := t.load(id)
+		if err != nil {
+			return nil, err
+		}
+		if p.leaf {
+			return append(path, diskCursor{p, i}), nil
+		}
+		j := 0
+		for ; j < len(p.sizes)-1 && i >= int(p.sizes[j]); j++ {
+			i -= int(p.sizes[j])
+		}
+		path = append(path, diskCursor{p, j})
+		id = p.children[j]
+	}
+}
+
+// makeWritable replaces each page on path with a writable version, updating
+// the child pointers of the pages above it.
+func (t *DiskBTree) makeWritable(path []diskCursor) {
+	for i := range path {
+		path[i].p = t.writable(path[i].p)
+		if i > 0 {
+			path[i-1].p.children[path[i-1].i] = path[i].p.id
+		}
+	}
+	t.root = path[0].p.id
+	t.changed = true
+}
+
+// Get returns the value for k, or nil if k is not in the tree.
+func (t *DiskBTree) Get(k Key) (Value, error) {
+	v, _, err := t.GetWithIndex(k)
+	return v, err
+}
+
+// GetWithIndex returns the value and index of k, or nil and -1 if k is not in the tree.
+func (t *DiskBTree) GetWithIndex(k Key) (Value, int, error) {
+	path, idx, found, err := t.descend(k)
+	if err == nil {
+		err = t.trim()
+	}
+	if err != nil || !found {
+		return nil, -1, err
+	}
+	top := path[len(path)-1]
+	v, err := t.vc.Decode(top.p.vbytes[top.i])
+	if err != nil {
+		return nil, -1, err
+	}
+	return v, idx, nil
+}
+
+// At returns the key and value at index i. The minimum item has index 0.
+// If i is outside the range [0, t.Len()), At panics.
+func (t *DiskBTree) At(i int) (Key, Value, error) {
+	if i < 0 || i >= t.count {
+		panic("btree: index out of range")
+	}
+	path, err := t.descendIndex(i)
+	if err == nil {
+		err = t.trim()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	top := path[len(path)-1]
+	v, err := t.vc.Decode(top.p.vbytes[top.i])
+	if err != nil {
+		return nil, nil, err
+	}
+	return top.p.keys[top.i], v, nil
+}
+
+// Set sets the value of k to v. If k was present, it returns its old value and true.
+// The change is not durable until Commit is called.
+func (t *DiskBTree) Set(k Key, v Value) (old Value, present bool, err error) {
+	kb, err := t.kc.Append(nil, k)
+	if err != nil {
+		return nil, false, err
+	}
+	vb, err := t.vc.Append(nil, v)
+	if err != nil {
+		return nil, false, err
+	}
+	if fieldSize(kb)+fieldSize(vb) > t.maxEntry {
+		return nil, false, ErrItemTooLarge
+	}
+	// Read everything we need before changing anything, so an I/O error leaves
+	// the tree as it was.
+	path, _, found, err := t.descend(k)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		top := path[len(path)-1]
+		if old, err = t.vc.Decode(top.p.vbytes[top.i]); err != nil {
+			return nil, false, err
+		}
+	}
+	if path == nil {
+		p := t.alloc(true)
+		t.root = p.id
+		path = []diskCursor{{p, 0}}
+	}
+	t.makeWritable(path)
+	leaf := path[len(path)-1]
+	p := leaf.p
+	if found {
+		p.nbytes += fieldSize(vb) - fieldSize(p.vbytes[leaf.i])
+		p.vbytes[leaf.i] = vb
+	} else {
+		p.keys = append(p.keys, nil)
+		copy(p.keys[leaf.i+1:], p.keys[leaf.i:])
+		p.keys[leaf.i] = k
+		p.kbytes = insertBytes(p.kbytes, leaf.i, kb)
+		p.vbytes = insertBytes(p.vbytes, leaf.i, vb)
+		p.nbytes += fieldSize(kb) + fieldSize(vb)
+		t.count++
+	}
+	// Fix up the ancestors, splitting pages that have grown too large.
+	right := t.maybeSplit(p)
+	for i := len(path) - 2; i >= 0; i-- {
+		p, ci := path[i].p, path[i].i
+		if right != nil {
+			c := path[i+1].p
+			p.sizes[ci] = pageSize(c)
+			p.children = insertUint64(p.children, ci+1, right.id)
+			p.sizes = insertUint64(p.sizes, ci+1, pageSize(right))
+			sep, sepb := firstKey(t, right)
+			p.keys = append(p.keys, nil)
+			copy(p.keys[ci+1:], p.keys[ci:])
+			p.keys[ci] = sep
+			p.kbytes = insertBytes(p.kbytes, ci, sepb)
+			p.nbytes += 16 + fieldSize(sepb)
+		} else if !found {
+			p.sizes[ci]++
+		}
+		right = t.maybeSplit(p)
+	}
+	if right != nil {
+		left := path[0].p
+		r := t.alloc(false)
+		r.children = []uint64{left.id, right.id}
+		r.sizes = []uint64{pageSize(left), pageSize(right)}
+		sep, sepb := firstKey(t, right)
+		r.keys = []Key{sep}
+		r.kbytes = [][]byte{sepb}
+		r.nbytes += 32 + fieldSize(sepb)
+		t.root = r.id
+	}
+	return old, found, t.trim()
+}
+
+// firstKey returns the smallest key in the subtree rooted at p, which must be in
+// the cache. For internal pages, that is the separator that was promoted when p
+// was split off.
+func firstKey(t *DiskBTree, p *diskPage) (Key, []byte) {
+	if p.leaf {
+		return p.keys[0], p.kbytes[0]
+	}
+	return p.promoted, p.promotedBytes
+}
+
+// pageSize returns the number of items in the subtree rooted at p.
+func pageSize(p *diskPage) uint64 {
+	if p.leaf {
+		return uint64(len(p.keys))
+	}
+	var n uint64
+	for _, s := range p.sizes {
+		n += s
+	}
+	return n
+}
+
+// maybeSplit splits p if it is too large to fit in a page, returning the new
+// page holding its upper half, or nil.
+func (t *DiskBTree) maybeSplit(p *diskPage) *diskPage {
+	if p.nbytes <= t.pageSize {
+		return nil
+	}
+	r := t.alloc(p.leaf)
+	half := (p.nbytes - pageHeaderSize) / 2
+	if p.leaf {
+		// Keep at least one item on each side.
+		n, i := 0, 0
+		for ; i < len(p.keys)-1; i++ {
+			sz := fieldSize(p.kbytes[i]) + fieldSize(p.vbytes[i])
+			if i > 0 && n+sz > half {
+				break
+			}
+			n += sz
+		}
+		r.keys = append(r.keys, p.keys[i:]...)
+		r.kbytes = append(r.kbytes, p.kbytes[i:]...)
+		r.vbytes = append(r.vbytes, p.vbytes[i:]...)
+		p.keys, p.kbytes, p.vbytes = p.keys[:i:i], p.kbytes[:i:i], p.vbytes[:i:i]
+		r.nbytes += p.nbytes - pageHeaderSize - n
+		p.nbytes = pageHeaderSize + n
+		return r
+	}
+	// Internal page: children[:m] stay, keys[m-1] moves up, children[m:] move
+	// to r. Keep at least one child on each side.
+	n, m := 16, 1
+	for ; m < len(p.children)-1; m++ {
+		sz := 16 + fieldSize(p.kbytes[m-1])
+		if n+sz > half {
+			break
+		}
+		n += sz
+	}
+	r.promoted, r.promotedBytes = p.keys[m-1], p.kbytes[m-1]
+	r.children = append(r.children, p.children[m:]...)
+	r.sizes = append(r.sizes, p.sizes[m:]...)
+	r.keys = append(r.keys, p.keys[m:]...)
+	r.kbytes = append(r.kbytes, p.kbytes[m:]...)
+	r.nbytes += p.nbytes - pageHeaderSize - n - fieldSize(p.kbytes[m-1])
+	p.children, p.sizes = p.children[:m:m], p.sizes[:m:m]
+	p.keys, p.kbytes = p.keys[:m-1:m-1], p.kbytes[:m-1:m-1]
+	p.nbytes = pageHeaderSize + n
+	return r
+}
+
+func insertBytes(s [][]byte, i int, b []byte) [][]byte {
+	s = append(s, nil)
+	copy(s[i+1:], s[i:])
+	s[i] = b
+	return s
+}
+
+func insertUint64(s []uint64, i int, u uint64) []uint64 {
+	s = append(s, 0)
+	copy(s[i+1:], s[i:])
+	s[i] = u
+	return s
+}
+
+// Delete removes k from the tree, returning its value and true if it was present.
+// Pages that become empty are freed, but pages are not otherwise merged.
+// The change is not durable until Commit is called.
+func (t *DiskBTree) Delete(k Key) (Value, bool, error) {
+	path, _, found, err := t.descend(k)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	top := path[len(path)-1]
+	old, err := t.vc.Decode(top.p.vbytes[top.i])
+	if err != nil {
+		return nil, false, err
+	}
+	t.makeWritable(path)
+	p, i := path[len(path)-1].p, path[len(path)-1].i
+	p.nbytes -= fieldSize(p.kbytes[i]) + fieldSize(p.vbytes[i])
+	p.keys = append(p.keys[:i], p.keys[i+1:]...)
+	p.kbytes = append(p.kbytes[:i], p.kbytes[i+1:]...)
+	p.vbytes = append(p.vbytes[:i], p.vbytes[i+1:]...)
+	t.count--
+	// Walk up the path, removing empty pages and decrementing sizes.
+	empty := len(p.keys) == 0
+	if empty {
+		t.release(p)
+	}
+	for j := len(path) - 2; j >= 0; j-- {
+		p, ci := path[j].p, path[j].i
+		if empty {
+			// Remove child ci and a separator next to it.
+			ki := ci - 1
+			if ci == 0 {
+				ki = 0
+			}
+			p.children = append(p.children[:ci], p.children[ci+1:]...)
+			p.sizes = append(p.sizes[:ci], p.sizes[ci+1:]...)
+			p.nbytes -= 16
+			if len(p.keys) > 0 {
+				p.nbytes -= fieldSize(p.kbytes[ki])
+				p.keys = append(p.keys[:ki], p.keys[ki+1:]...)
+				p.kbytes = append(p.kbytes[:ki], p.kbytes[ki+1:]...)
+			}
+			if empty = len(p.children) == 0; empty {
+				t.release(p)
+			}
+		} else {
+			p.sizes[ci]--
+		}
+	}
+	if empty {
+		t.root = 0
+	}
+	// Shorten the tree while the root has a single child.
+	for t.root != 0 {
+		r, err := t.load(t.root)
+		if err != nil {
+			return old, true, err
+		}
+		if r.leaf || len(r.children) > 1 {
+			break
+		}
+		t.root = r.children[0]
+		t.release(r)
+	}
+	return old, true, t.trim()
+}
+
+// A DiskIterator supports traversing the items in a DiskBTree. Its behavior is
+// undefined if the tree is modified during iteration.
+type DiskIterator struct {
+	Key   Key
+	Value Value
+	// Index is the position of the item in the tree viewed as a sequence.
+	// The minimum item has index zero.
+	Index int
+
+	t          *DiskBTree
+	cursors    []diskCursor
+	stay       bool
+	descending bool
+	err        error
+}
+
+// Err returns the error, if any, that caused Next to return false.
+func (it *DiskIterator) Err() error {
+	return it.err
+}
+
+// Before returns an iterator positioned just before k. After the first call to
+// Next, the iterator will be at k, or at the key just greater than k if k is not in
+// the tree. Subsequent calls to Next will traverse the tree's items in ascending order.
+func (t *DiskBTree) Before(k Key) *DiskIterator {
+	path, idx, _, err := t.descend(k)
+	it := &DiskIterator{t: t, cursors: path, err: err, stay: true, Index: idx}
+	if path != nil {
+		if top := path[len(path)-1]; top.i == len(top.p.keys) {
+			// Past the end of the leaf. Back up so that Next will move to the next leaf.
+			it.cursors[len(path)-1].i--
+			it.stay = false
+			it.Index--
+		}
+	}
+	return it
+}
+
+// After returns an iterator positioned just after k. After the first call to Next,
+// the iterator will be at k, or at the key just less than k if k is not in the tree.
+// Subsequent calls to Next will traverse the tree's items in descending order.
+func (t *DiskBTree) After(k Key) *DiskIterator {
+	path, idx, found, err := t.descend(k)
+	return &DiskIterator{t: t, cursors: path, err: err, stay: found, descending: true, Index: idx}
+}
+
+// BeforeIndex returns an iterator positioned just before the item with the given
+// index. The iterator will traverse the tree's items in ascending order.
+// If i is not in the range [0, t.Len()], BeforeIndex panics.
+func (t *DiskBTree) BeforeIndex(i int) *DiskIterator {
+	return t.indexIterator(i, false)
+}
+
+// AfterIndex returns an iterator positioned just after the item with the given
+// index. The iterator will traverse the tree's items in descending order.
+// If i is not in the range [0, t.Len()], AfterIndex panics.
+func (t *DiskBTree) AfterIndex(i int) *DiskIterator {
+	return t.indexIterator(i, true)
+}
+
+func (t *DiskBTree) indexIterator(i int, descending bool) *DiskIterator {
+	if i < 0 || i > t.count {
+		panic("btree: index out of range")
+	}
+	if i == t.count {
+		return &DiskIterator{}
+	}
+	path, err := t.descendIndex(i)
+	return &DiskIterator{t: t, cursors: path, err: err, stay: true, descending: descending, Index: i}
+}
+
+// Next advances the iterator to the next item in the tree. If Next returns true,
+// the iterator's Key, Value and Index fields refer to the next item. If Next
+// returns false, there are no more items or an error occurred; call Err to
+// distinguish the two.
+func (it *DiskIterator) Next() bool {
+	if it.err != nil || len(it.cursors) == 0 {
+		return false
+	}
+	switch {
+	case it.stay:
+		it.stay = false
+	case it.descending:
+		it.Index--
+		it.err = it.move(-1)
+	default:
+		it.Index++
+		it.err = it.move(1)
+	}
+	if it.err == nil {
+		it.err = it.t.trim()
+	}
+	if it.err != nil || len(it.cursors) == 0 {
+		return false
+	}
+	top := it.cursors[len(it.cursors)-1]
+	v, err := it.t.vc.Decode(top.p.vbytes[top.i])
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.Key = top.p.keys[top.i]
+	it.Value = v
+	return true
+}
+
+// move moves the iterator one item in direction dir, which is 1 or -1.
+// It empties the cursor stack if there are no more items.
+func (it *DiskIterator) move(dir int) error {
+	cs := it.cursors
+	n := len(cs) - 1
+	cs[n].i += dir
+	if cs[n].i >= 0 && cs[n].i < len(cs[n].p.keys) {
+		return nil
+	}
+	// Go up until there is a sibling in direction dir.
+	for {
+		cs = cs[:n]
+		n--
+		if n < 0 {
+			it.cursors = nil
+			return nil
+		}
+		cs[n].i += dir
+		if cs[n].i >= 0 && cs[n].i < len(cs[n].p.children) {
+			break
+		}
+	}
+	// Go down to the first (or last) item of that subtree.
+	for {
+		top := cs[len(cs)-1]
+		p, err := it.t.load(top.p.children[top.i])
+		if err != nil {
+			return err
+		}
+		var i int
+		if dir < 0 {
+			if p.leaf {
+				i = len(p.keys) - 1
+			} else {
+				i = len(p.children) - 1
+			}
+		}
+		cs = append(cs, diskCursor{p, i})
+		if p.leaf {
+			break
+		}
+	}
+	it.cursors = cs
+	return nil
+}
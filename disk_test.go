@@ -0,0 +1,243 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func diskAll(t *testing.T, it *DiskIterator) []itemWithIndex {
+	t.Helper()
+	var got []itemWithIndex
+	for it.Next() {
+		got = append(got, itemWithIndex{it.Key, it.Value, it.Index})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// checkDisk compares the contents of d with those of the in-memory tree want.
+func checkDisk(t *testing.T, d *DiskBTree, want *BTree) {
+	t.Helper()
+	if d.Len() != want.Len() {
+		t.Fatalf("Len: got %d, want %d", d.Len(), want.Len())
+	}
+	if got, w := diskAll(t, d.BeforeIndex(0)), all(want.BeforeIndex(0)); !cmp.Equal(got, w) {
+		t.Fatalf("ascending: got %v\nwant %v", got, w)
+	}
+	if got, w := diskAll(t, d.AfterIndex(d.Len()-1)), all(want.AfterIndex(want.Len()-1)); d.Len() > 0 && !cmp.Equal(got, w) {
+		t.Fatalf("descending: got %v\nwant %v", got, w)
+	}
+	for i := 0; i < 20 && want.Len() > 0; i++ {
+		j := rand.Intn(want.Len())
+		k, v, err := d.At(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wk, wv := want.At(j)
+		if k != wk || v != wv {
+			t.Fatalf("At(%d) = %v, %v; want %v, %v", j, k, v, wk, wv)
+		}
+		v, idx, err := d.GetWithIndex(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != wv || idx != j {
+			t.Fatalf("GetWithIndex(%v) = %v, %d; want %v, %d", k, v, idx, wv, j)
+		}
+	}
+}
+
+func TestDiskBTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	opts := &DiskOptions{PageSize: 1024, CacheSize: 16}
+	d, err := OpenDisk(path, less, IntCodec, IntCodec, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := New(4, less)
+	const size = 5000
+	for _, m := range perm(size) {
+		old, present, err := d.Set(m.Key, m.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wold, wpresent := want.Set(m.Key, m.Value)
+		if old != wold || present != wpresent {
+			t.Fatalf("Set(%v) = %v, %t; want %v, %t", m.Key, old, present, wold, wpresent)
+		}
+	}
+	checkDisk(t, d, want)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = OpenDisk(path, less, IntCodec, IntCodec, &DiskOptions{CacheSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDisk(t, d, want)
+	for i := 0; i < size; i++ {
+		k := rand.Intn(2 * size)
+		if rand.Intn(3) == 0 {
+			v, ok, err := d.Delete(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wv, wok := want.Delete(k)
+			if v != wv || ok != wok {
+				t.Fatalf("Delete(%d) = %v, %t; want %v, %t", k, v, ok, wv, wok)
+			}
+		} else {
+			if _, _, err := d.Set(k, -k); err != nil {
+				t.Fatal(err)
+			}
+			want.Set(k, -k)
+		}
+	}
+	checkDisk(t, d, want)
+	for _, k := range []int{-1, 17, size / 2, 2 * size} {
+		if got, w := diskAll(t, d.Before(k)), all(want.Before(k)); !cmp.Equal(got, w) {
+			t.Errorf("Before(%d): got %v\nwant %v", k, got, w)
+		}
+		if got, w := diskAll(t, d.After(k)), all(want.After(k)); !cmp.Equal(got, w) {
+			t.Errorf("After(%d): got %v\nwant %v", k, got, w)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Freed pages are reused, so the file doesn't grow without bound.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := fi.Size()
+	d, err = OpenDisk(path, less, IntCodec, IntCodec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 50; j++ {
+			k := rand.Intn(2 * size)
+			d.Set(k, k)
+			want.Set(k, k)
+		}
+		if err := d.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkDisk(t, d, want)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 2*before {
+		t.Errorf("file grew from %d to %d bytes", before, fi.Size())
+	}
+
+	// Deleting everything leaves an empty tree.
+	d, err = OpenDisk(path, less, IntCodec, IntCodec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for d.Len() > 0 {
+		k, _, err := d.At(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := d.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.root != 0 {
+		t.Errorf("empty tree has root page %d", d.root)
+	}
+	if d.Before(0).Next() {
+		t.Error("iterator over empty tree returned an item")
+	}
+	d.Close()
+}
+
+func TestDiskCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	d, err := OpenDisk(path, less, IntCodec, IntCodec, &DiskOptions{PageSize: 1024, CacheSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := New(4, less)
+	for _, m := range perm(1000) {
+		d.Set(m.Key, m.Value)
+		want.Set(m.Key, m.Value)
+	}
+	if err := d.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Make uncommitted changes, enough to evict dirty pages to the file, then
+	// "crash" by closing the file without committing.
+	for i := 0; i < 1000; i++ {
+		d.Set(i, -i)
+		d.Set(1000+i, i)
+	}
+	d.f.Close()
+
+	d, err = OpenDisk(path, less, IntCodec, IntCodec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDisk(t, d, want)
+
+	// A torn write of the newest meta record falls back to the previous one.
+	d.Set(5000, 5000)
+	if err := d.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	off := int64(0)
+	if d.txid%2 == 1 {
+		off = diskMeta1Offset
+	}
+	if _, err := d.f.WriteAt([]byte("garbage"), off+20); err != nil {
+		t.Fatal(err)
+	}
+	d.f.Close()
+	d, err = OpenDisk(path, less, IntCodec, IntCodec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDisk(t, d, want)
+	d.Close()
+}
+
+func TestDiskItemTooLarge(t *testing.T) {
+	d, err := OpenDisk(filepath.Join(t.TempDir(), "tree"), less, IntCodec, BytesCodec, &DiskOptions{PageSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, _, err := d.Set(1, make([]byte, 1000)); err != ErrItemTooLarge {
+		t.Errorf("got %v, want ErrItemTooLarge", err)
+	}
+}
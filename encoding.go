@@ -0,0 +1,180 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The less function and degree of a tree cannot be serialized. So decoding
+// requires a tree that was created with New, and is typically used like this:
+//
+//	type S struct {
+//		Tree *btree.BTree
+//	}
+//	s := S{Tree: btree.New(8, less)}
+//	err := json.Unmarshal(data, &s)
+//
+// Decoding replaces the existing contents of the tree.
+
+var errUninitialized = errors.New("btree: cannot decode into a BTree that was not created by New")
+
+// gobItem is the gob encoding of an item.
+type gobItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// GobEncode implements gob.GobEncoder. Keys and values are encoded as interface
+// values, so their concrete types must be registered with gob.Register. (The
+// basic types, like int and string, are registered by default.)
+func (t *BTree) GobEncode() ([]byte, error) {
+	s := make([]gobItem, 0, t.Len())
+	it := t.first()
+	for it.Next() {
+		s = append(s, gobItem{it.Key, it.Value})
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder. t must have been created with New.
+func (t *BTree) GobDecode(data []byte) error {
+	if t.less == nil {
+		return errUninitialized
+	}
+	var s []gobItem
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+	items := make([]item, len(s))
+	for i, g := range s {
+		items[i] = item{g.Key, g.Value}
+	}
+	t.replaceContents(items)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. The tree is encoded as a JSON array of
+// [key, value] pairs in ascending order of key.
+func (t *BTree) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	it := t.first()
+	for it.Next() {
+		if it.Index > 0 {
+			buf.WriteByte(',')
+		}
+		b, err := json.Marshal([2]interface{}{it.Key, it.Value})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. t must have been created with New.
+// It accepts the format written by MarshalJSON. By default, keys and values are
+// decoded as if by json.Unmarshal into an interface{}, so numbers become
+// float64s; use DecodeJSONWith to decode them to other types.
+func (t *BTree) UnmarshalJSON(data []byte) error {
+	if t.less == nil {
+		return errUninitialized
+	}
+	var pairs [][2]json.RawMessage
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return err
+	}
+	decodeKey, decodeValue := decodeJSONAny, decodeJSONAny
+	if t.jsonDecoders != nil {
+		decodeKey, decodeValue = t.jsonDecoders.key, t.jsonDecoders.value
+	}
+	items := make([]item, len(pairs))
+	for i, p := range pairs {
+		k, err := decodeKey(p[0])
+		if err != nil {
+			return fmt.Errorf("btree: decoding key %d: %v", i, err)
+		}
+		v, err := decodeValue(p[1])
+		if err != nil {
+			return fmt.Errorf("btree: decoding value %d: %v", i, err)
+		}
+		items[i] = item{k, v}
+	}
+	t.replaceContents(items)
+	return nil
+}
+
+type jsonDecoders struct {
+	key, value func(json.RawMessage) (interface{}, error)
+}
+
+// DecodeJSONWith sets the functions that UnmarshalJSON uses to decode each key
+// and value of t. A nil function leaves the default decoding in place.
+func (t *BTree) DecodeJSONWith(key, value func(json.RawMessage) (interface{}, error)) {
+	if key == nil {
+		key = decodeJSONAny
+	}
+	if value == nil {
+		value = decodeJSONAny
+	}
+	t.jsonDecoders = &jsonDecoders{key, value}
+}
+
+func decodeJSONAny(m json.RawMessage) (interface{}, error) {
+	var x interface{}
+	err := json.Unmarshal(m, &x)
+	return x, err
+}
+
+// replaceContents replaces the items of t with those of s. If s is sorted with
+// no duplicates, which is the usual case when decoding, the tree is built
+// directly from s; otherwise the items are inserted one at a time, so later
+// items replace earlier ones with the same key.
+func (t *BTree) replaceContents(s []item) {
+	sorted := true
+	for i := 1; i < len(s); i++ {
+		if !t.less(s[i-1].key, s[i].key) {
+			sorted = false
+			break
+		}
+	}
+	if sorted && t.watchers == nil {
+		t.root = t.cow.buildSorted(s, t.maxItems(), t.minItems())
+		t.appended = false
+		t.count = len(s)
+		t.version++
+		if t.hasher != nil {
+			t.hasher.hash(t.root)
+		}
+		return
+	}
+	// Go the slow way, which also notifies watchers.
+	for t.Len() > 0 {
+		t.DeleteMin()
+	}
+	for _, m := range s {
+		t.Set(m.key, m.value)
+	}
+}
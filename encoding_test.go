@@ -0,0 +1,102 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type withTree struct {
+	Name string
+	Tree *BTree
+}
+
+func TestGob(t *testing.T) {
+	in := withTree{Name: "x", Tree: New(3, less)}
+	for _, m := range perm(100) {
+		in.Tree.Set(m.Key, m.Value)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	out := withTree{Tree: New(5, less)}
+	out.Tree.Set(1000, 1000) // should be replaced
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "x" {
+		t.Errorf("got name %q", out.Name)
+	}
+	checkTree(t, out.Tree)
+	if got, want := all(out.Tree.BeforeIndex(0)), rang(100); !cmp.Equal(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tr := New(3, less)
+	for _, m := range perm(5) {
+		tr.Set(m.Key, m.Value.(int)*10)
+	}
+	data, err := json.Marshal(withTree{Tree: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), `{"Name":"","Tree":[[0,0],[1,10],[2,20],[3,30],[4,40]]}`; got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+
+	out := withTree{Tree: New(2, less)}
+	out.Tree.DecodeJSONWith(func(m json.RawMessage) (interface{}, error) {
+		var i int
+		err := json.Unmarshal(m, &i)
+		return i, err
+	}, nil)
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, out.Tree)
+	if got, want := all(out.Tree.BeforeIndex(0)), all(tr.BeforeIndex(0)); len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := out.Tree.Get(3), 30.0; got != want {
+		t.Errorf("Get(3) = %v (%[1]T), want %v", got, want)
+	}
+
+	// Out of order, with a duplicate.
+	unsorted := New(2, func(a, b interface{}) bool { return a.(string) < b.(string) })
+	if err := json.Unmarshal([]byte(`[["b",1],["a",2],["b",3]]`), unsorted); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := all(unsorted.BeforeIndex(0)), []itemWithIndex{{"a", 2.0, 0}, {"b", 3.0, 1}}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDecodeUninitialized(t *testing.T) {
+	var tr BTree
+	if err := json.Unmarshal([]byte(`[]`), &tr); err != errUninitialized {
+		t.Errorf("JSON: got %v, want errUninitialized", err)
+	}
+	if err := tr.GobDecode(nil); err != errUninitialized {
+		t.Errorf("gob: got %v, want errUninitialized", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import "time"
+
+// An ExpiringTree is a sorted map whose entries each have a deadline, after
+// which they expire. An entry expires when the time reaches its deadline; an
+// entry with the zero deadline never expires.
+//
+// Expired entries are hidden from Get and the iterators at once, but they stay
+// in the tree, and count toward Len, until Expire removes them. Expire is
+// efficient because the tree keeps a second ordering of its entries by
+// deadline. To evict entries in the background, call Expire periodically, for
+// example on each tick of a time.Ticker, from the goroutine that writes the
+// tree.
+//
+// Like a BTree, an ExpiringTree is safe for concurrent reads, but not for
+// concurrent reads and writes.
+type ExpiringTree struct {
+	items     *BTree // from keys to expiringEntries
+	deadlines *BTree // from deadlineKeys to nil, for entries with a deadline
+	now       func() time.Time
+}
+
+type expiringEntry struct {
+	value    Value
+	deadline time.Time
+}
+
+// A deadlineKey orders entries by deadline, and entries with the same deadline
+// by key.
+type deadlineKey struct {
+	deadline time.Time
+	key      Key
+}
+
+// NewExpiring creates a new ExpiringTree with the given degree and key
+// ordering; see New. Its clock is now, which returns the current time; if now
+// is nil, the clock is time.Now. Tests can pass a fake clock to control when
+// entries expire.
+func NewExpiring(degree int, less func(interface{}, interface{}) bool, now func() time.Time) *ExpiringTree {
+	if now == nil {
+		now = time.Now
+	}
+	deadlineLess := func(a, b interface{}) bool {
+		x, y := a.(deadlineKey), b.(deadlineKey)
+		if !x.deadline.Equal(y.deadline) {
+			return x.deadline.Before(y.deadline)
+		}
+		return less(x.key, y.key)
+	}
+	return &ExpiringTree{
+		items:     New(degree, less),
+		deadlines: New(degree, deadlineLess),
+		now:       now,
+	}
+}
+
+// expired reports whether e has expired at time now.
+func (e expiringEntry) expired(now time.Time) bool {
+	return !e.deadline.IsZero() && !now.Before(e.deadline)
+}
+
+// Set sets the value of k to v, expiring at deadline. It returns the previous
+// value of k and true if k had an unexpired entry, or nil and false otherwise.
+func (t *ExpiringTree) Set(k Key, v Value, deadline time.Time) (old Value, present bool) {
+	prev, ok := t.items.Set(k, expiringEntry{v, deadline})
+	if ok {
+		e := prev.(expiringEntry)
+		t.removeDeadline(k, e)
+		if !e.expired(t.now()) {
+			old, present = e.value, true
+		}
+	}
+	if !deadline.IsZero() {
+		t.deadlines.Set(deadlineKey{deadline, k}, nil)
+	}
+	return old, present
+}
+
+// SetTTL is like Set, but the entry expires after the duration ttl from now,
+// as given by the tree's clock.
+func (t *ExpiringTree) SetTTL(k Key, v Value, ttl time.Duration) (old Value, present bool) {
+	return t.Set(k, v, t.now().Add(ttl))
+}
+
+// Get returns the value of k, or nil if k has no entry or its entry has
+// expired.
+func (t *ExpiringTree) Get(k Key) Value {
+	v, _, ok := t.Lookup(k)
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// Lookup returns the value and deadline of k, and whether k has an unexpired
+// entry.
+func (t *ExpiringTree) Lookup(k Key) (Value, time.Time, bool) {
+	x := t.items.Get(k)
+	if x == nil {
+		return nil, time.Time{}, false
+	}
+	e := x.(expiringEntry)
+	if e.expired(t.now()) {
+		return nil, time.Time{}, false
+	}
+	return e.value, e.deadline, true
+}
+
+// Delete removes the entry for k. It returns the entry's value and true if it
+// had not expired, or nil and false otherwise.
+func (t *ExpiringTree) Delete(k Key) (Value, bool) {
+	x, ok := t.items.Delete(k)
+	if !ok {
+		return nil, false
+	}
+	e := x.(expiringEntry)
+	t.removeDeadline(k, e)
+	if e.expired(t.now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// removeDeadline removes the deadline of k's entry e from the deadline
+// ordering.
+func (t *ExpiringTree) removeDeadline(k Key, e expiringEntry) {
+	if !e.deadline.IsZero() {
+		t.deadlines.Delete(deadlineKey{e.deadline, k})
+	}
+}
+
+// Expire removes every entry that has expired at time now, and returns the
+// number removed. It takes time proportional to that number times the log of
+// the size of the tree.
+func (t *ExpiringTree) Expire(now time.Time) int {
+	n := 0
+	for t.deadlines.Len() > 0 {
+		x, _ := t.deadlines.Min()
+		dk := x.(deadlineKey)
+		if now.Before(dk.deadline) {
+			break
+		}
+		t.deadlines.DeleteMin()
+		t.items.Delete(dk.key)
+		n++
+	}
+	return n
+}
+
+// NextDeadline returns the earliest deadline of any entry in the tree, and
+// false if no entry has a deadline. It can be used to schedule the next call
+// to Expire.
+func (t *ExpiringTree) NextDeadline() (time.Time, bool) {
+	if t.deadlines.Len() == 0 {
+		return time.Time{}, false
+	}
+	x, _ := t.deadlines.Min()
+	return x.(deadlineKey).deadline, true
+}
+
+// Len returns the number of entries in the tree, including those that have
+// expired but have not been removed by Expire.
+func (t *ExpiringTree) Len() int {
+	return t.items.Len()
+}
+
+// Clone returns a copy of t that shares its clock. Like BTree.Clone, it is
+// cheap, and t and the clone may be written independently.
+func (t *ExpiringTree) Clone() *ExpiringTree {
+	return &ExpiringTree{
+		items:     t.items.Clone(),
+		deadlines: t.deadlines.Clone(),
+		now:       t.now,
+	}
+}
+
+// Before returns an iterator positioned just before k, which traverses the
+// unexpired entries in ascending order. See BTree.Before.
+func (t *ExpiringTree) Before(k Key) *ExpiringIterator {
+	return &ExpiringIterator{it: t.items.Before(k), now: t.now()}
+}
+
+// After returns an iterator positioned just after k, which traverses the
+// unexpired entries in descending order. See BTree.After.
+func (t *ExpiringTree) After(k Key) *ExpiringIterator {
+	return &ExpiringIterator{it: t.items.After(k), now: t.now()}
+}
+
+// An ExpiringIterator traverses the entries of an ExpiringTree, skipping those
+// that had expired when it was created.
+type ExpiringIterator struct {
+	Key      Key
+	Value    Value
+	Deadline time.Time
+
+	it  *Iterator
+	now time.Time // the time at which the iterator was created
+}
+
+// Next advances the iterator to the next unexpired entry. If Next returns true,
+// the iterator's Key, Value and Deadline fields refer to the entry. If Next
+// returns false, there are no more entries.
+//
+// If the tree is modified during iteration, the behavior is undefined.
+func (it *ExpiringIterator) Next() bool {
+	for it.it.Next() {
+		e := it.it.Value.(expiringEntry)
+		if !e.expired(it.now) {
+			it.Key, it.Value, it.Deadline = it.it.Key, e.value, e.deadline
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeClock is a clock for an ExpiringTree that moves only when told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newExpiringForTest() (*ExpiringTree, *fakeClock) {
+	c := &fakeClock{epoch}
+	return NewExpiring(2, less, c.now), c
+}
+
+// checkExpiring checks that the deadline ordering of et holds exactly the
+// entries of et that have deadlines.
+func checkExpiring(t *testing.T, et *ExpiringTree) {
+	t.Helper()
+	if err := et.items.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := et.deadlines.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it := et.items.BeforeIndex(0); it.Next(); {
+		e := it.Value.(expiringEntry)
+		if e.deadline.IsZero() {
+			continue
+		}
+		n++
+		if !et.deadlines.Has(deadlineKey{e.deadline, it.Key}) {
+			t.Fatalf("key %v: deadline %v missing from the deadline ordering", it.Key, e.deadline)
+		}
+	}
+	if got := et.deadlines.Len(); got != n {
+		t.Fatalf("deadline ordering has %d entries, want %d", got, n)
+	}
+}
+
+// expiringKeys returns the keys visited by it.
+func expiringKeys(it *ExpiringIterator) []Key {
+	var ks []Key
+	for it.Next() {
+		ks = append(ks, it.Key)
+	}
+	return ks
+}
+
+func TestExpiring(t *testing.T) {
+	et, clock := newExpiringForTest()
+	// Key i expires at i seconds; key 0 never expires.
+	for i := 0; i < 20; i++ {
+		var d time.Time
+		if i > 0 {
+			d = epoch.Add(time.Duration(i) * time.Second)
+		}
+		if _, present := et.Set(i, i*10, d); present {
+			t.Fatalf("Set(%d): present", i)
+		}
+	}
+	checkExpiring(t, et)
+	if got := et.Get(5); got != 50 {
+		t.Errorf("Get(5) = %v, want 50", got)
+	}
+	if d, ok := et.NextDeadline(); !ok || !d.Equal(epoch.Add(time.Second)) {
+		t.Errorf("NextDeadline() = %v, %t", d, ok)
+	}
+
+	clock.advance(5 * time.Second)
+	// Keys 1 through 5 have expired, but are still in the tree.
+	for _, k := range []int{1, 5} {
+		if got := et.Get(k); got != nil {
+			t.Errorf("Get(%d) = %v after expiry, want nil", k, got)
+		}
+		if _, _, ok := et.Lookup(k); ok {
+			t.Errorf("Lookup(%d) found an expired entry", k)
+		}
+	}
+	if v, d, ok := et.Lookup(6); v != 60 || !d.Equal(epoch.Add(6*time.Second)) || !ok {
+		t.Errorf("Lookup(6) = %v, %v, %t", v, d, ok)
+	}
+	if got := et.Get(0); got != 0 {
+		t.Errorf("Get(0) = %v, want 0", got)
+	}
+	if got, want := expiringKeys(et.Before(0)), []Key{0, 6, 7, 8}; !reflect.DeepEqual(got[:4], want) {
+		t.Errorf("Before(0): got %v, want prefix %v", got, want)
+	}
+	if got, want := expiringKeys(et.After(7)), []Key{7, 6, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("After(7): got %v, want %v", got, want)
+	}
+	if got := et.Len(); got != 20 {
+		t.Errorf("Len() = %d before Expire, want 20", got)
+	}
+
+	if got := et.Expire(clock.now()); got != 5 {
+		t.Errorf("Expire removed %d, want 5", got)
+	}
+	checkExpiring(t, et)
+	if got := et.Len(); got != 15 {
+		t.Errorf("Len() = %d after Expire, want 15", got)
+	}
+	if got := et.Expire(clock.now()); got != 0 {
+		t.Errorf("second Expire removed %d, want 0", got)
+	}
+
+	// Expire removes everything but the entry without a deadline.
+	if got := et.Expire(epoch.Add(time.Hour)); got != 14 {
+		t.Errorf("Expire removed %d, want 14", got)
+	}
+	checkExpiring(t, et)
+	if got := et.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+	if _, ok := et.NextDeadline(); ok {
+		t.Error("NextDeadline reported a deadline for a tree without one")
+	}
+}
+
+func TestExpiringUpdate(t *testing.T) {
+	et, clock := newExpiringForTest()
+	et.SetTTL(1, "a", time.Second)
+	et.SetTTL(2, "b", time.Second)
+	// Extending the deadline replaces the old one in the deadline ordering.
+	if old, present := et.SetTTL(1, "a2", time.Minute); old != "a" || !present {
+		t.Errorf("SetTTL(1) = %v, %t", old, present)
+	}
+	checkExpiring(t, et)
+	clock.advance(time.Second)
+	if got := et.Get(1); got != "a2" {
+		t.Errorf("Get(1) = %v, want a2", got)
+	}
+	// Setting an expired key reports it absent.
+	if old, present := et.Set(2, "b2", time.Time{}); old != nil || present {
+		t.Errorf("Set(2) over an expired entry = %v, %t", old, present)
+	}
+	checkExpiring(t, et)
+	if got := et.Expire(clock.now()); got != 0 {
+		t.Errorf("Expire removed %d, want 0", got)
+	}
+	if got := et.Get(2); got != "b2" {
+		t.Errorf("Get(2) = %v, want b2", got)
+	}
+
+	if v, ok := et.Delete(1); v != "a2" || !ok {
+		t.Errorf("Delete(1) = %v, %t", v, ok)
+	}
+	if v, ok := et.Delete(1); v != nil || ok {
+		t.Errorf("second Delete(1) = %v, %t", v, ok)
+	}
+	et.SetTTL(3, "c", time.Second)
+	clock.advance(time.Second)
+	if v, ok := et.Delete(3); v != nil || ok {
+		t.Errorf("Delete(3) of an expired entry = %v, %t", v, ok)
+	}
+	checkExpiring(t, et)
+	if got := et.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+}
+
+func TestExpiringSameDeadline(t *testing.T) {
+	et, clock := newExpiringForTest()
+	for _, m := range perm(50) {
+		et.SetTTL(m.Key, m.Value, time.Second)
+	}
+	checkExpiring(t, et)
+	if got := et.Expire(clock.now().Add(time.Second - 1)); got != 0 {
+		t.Errorf("Expire before the deadline removed %d", got)
+	}
+	if got := et.Expire(clock.now().Add(time.Second)); got != 50 {
+		t.Errorf("Expire at the deadline removed %d, want 50", got)
+	}
+	checkExpiring(t, et)
+}
+
+func TestExpiringClone(t *testing.T) {
+	et, clock := newExpiringForTest()
+	for i := 0; i < 10; i++ {
+		et.SetTTL(i, i, time.Duration(i+1)*time.Second)
+	}
+	clone := et.Clone()
+	clone.SetTTL(100, 100, time.Hour)
+	clock.advance(5 * time.Second)
+	if got := clone.Expire(clock.now()); got != 5 {
+		t.Errorf("clone Expire removed %d, want 5", got)
+	}
+	checkExpiring(t, et)
+	checkExpiring(t, clone)
+	if et.Len() != 10 || clone.Len() != 6 {
+		t.Errorf("Len: original %d, clone %d; want 10, 6", et.Len(), clone.Len())
+	}
+	// The clone shares the clock.
+	if got := et.Get(3); got != nil {
+		t.Errorf("original Get(3) = %v after expiry, want nil", got)
+	}
+}
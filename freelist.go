@@ -0,0 +1,114 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import "sync"
+
+// DefaultFreeListSize is the capacity of the free list shared by trees created
+// without one of their own.
+const DefaultFreeListSize = 32
+
+// A FreeList holds nodes that trees have discarded, so that later writes can
+// reuse them, along with their item and child slices, instead of allocating.
+// It retains at most a fixed number of nodes.
+//
+// A tree gives its free list to its clones.
+type FreeList struct {
+	mu       *sync.Mutex // nil for a private free list
+	freelist []*node
+	hits     uint64
+	misses   uint64
+}
+
+// defaultFreeList is used by trees created without a FreeList.
+var defaultFreeList = NewFreeList(DefaultFreeListSize)
+
+// NewFreeList creates a free list that retains up to size nodes. It is safe for
+// concurrent use, so it can be shared by any number of trees.
+func NewFreeList(size int) *FreeList {
+	return &FreeList{mu: new(sync.Mutex), freelist: make([]*node, 0, size)}
+}
+
+// NewPrivateFreeList creates a free list that retains up to size nodes. It does
+// no locking, so it may be used by only one tree, or by a tree and its clones if
+// they are not written concurrently. That is an exception to the rule that
+// clones can be used concurrently; see Clone.
+func NewPrivateFreeList(size int) *FreeList {
+	return &FreeList{freelist: make([]*node, 0, size)}
+}
+
+// NewWithFreeList is like New, but the tree takes and returns nodes from f.
+func NewWithFreeList(degree int, less func(interface{}, interface{}) bool, f *FreeList) *BTree {
+	return NewWithOptions(degree, less, Options{FreeList: f})
+}
+
+// Hits returns the number of nodes that were taken from f.
+func (f *FreeList) Hits() uint64 {
+	f.lock()
+	defer f.unlock()
+	return f.hits
+}
+
+// Misses returns the number of nodes that were allocated because f was empty.
+func (f *FreeList) Misses() uint64 {
+	f.lock()
+	defer f.unlock()
+	return f.misses
+}
+
+// Len returns the number of nodes that f holds.
+func (f *FreeList) Len() int {
+	f.lock()
+	defer f.unlock()
+	return len(f.freelist)
+}
+
+func (f *FreeList) lock() {
+	if f.mu != nil {
+		f.mu.Lock()
+	}
+}
+
+func (f *FreeList) unlock() {
+	if f.mu != nil {
+		f.mu.Unlock()
+	}
+}
+
+func (f *FreeList) newNode() *node {
+	f.lock()
+	defer f.unlock()
+	index := len(f.freelist) - 1
+	if index < 0 {
+		f.misses++
+		return new(node)
+	}
+	f.hits++
+	n := f.freelist[index]
+	f.freelist[index] = nil
+	f.freelist = f.freelist[:index]
+	return n
+}
+
+// freeNode adds n to f, reporting whether it was added.
+func (f *FreeList) freeNode(n *node) bool {
+	f.lock()
+	defer f.unlock()
+	if len(f.freelist) < cap(f.freelist) {
+		f.freelist = append(f.freelist, n)
+		return true
+	}
+	return false
+}
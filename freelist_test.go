@@ -0,0 +1,97 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFreeList(t *testing.T) {
+	for _, private := range []bool{false, true} {
+		fl := NewFreeList(8)
+		if private {
+			fl = NewPrivateFreeList(8)
+		}
+		tr := NewWithFreeList(2, less, fl)
+		for _, m := range perm(1000) {
+			tr.Set(m.Key, m.Value)
+		}
+		if fl.Hits() != 0 || fl.Len() != 0 {
+			t.Fatalf("private=%t: after inserts, got %d hits, len %d; want 0, 0", private, fl.Hits(), fl.Len())
+		}
+		allocated := fl.Misses()
+		if allocated == 0 {
+			t.Fatalf("private=%t: no misses", private)
+		}
+		for _, m := range perm(1000) {
+			tr.Delete(m.Key)
+		}
+		if got := fl.Len(); got != 8 {
+			t.Errorf("private=%t: after deletes, len %d, want 8", private, got)
+		}
+		// The next inserts reuse the retained nodes.
+		for _, m := range perm(1000) {
+			tr.Set(m.Key, m.Value)
+		}
+		if got := fl.Hits(); got != 8 {
+			t.Errorf("private=%t: got %d hits, want 8", private, got)
+		}
+		if got := all(tr.BeforeIndex(0)); !cmp.Equal(got, rang(1000)) {
+			t.Errorf("private=%t: wrong contents", private)
+		}
+		checkTree(t, tr)
+	}
+}
+
+func TestFreeListShared(t *testing.T) {
+	fl := NewFreeList(16)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr := NewWithFreeList(2, less, fl)
+			for i := 0; i < 3; i++ {
+				for _, m := range perm(500) {
+					tr.Set(m.Key, m.Value)
+				}
+				for _, m := range perm(500) {
+					tr.Delete(m.Key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if fl.Hits() == 0 {
+		t.Error("no hits")
+	}
+	if got := fl.Len(); got > 16 {
+		t.Errorf("len %d exceeds capacity", got)
+	}
+}
+
+func TestFreeListClone(t *testing.T) {
+	fl := NewPrivateFreeList(4)
+	tr := NewWithFreeList(2, less, fl)
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	if got := tr.Clone().cow.freelist; got != fl {
+		t.Error("clone does not share the free list")
+	}
+}
@@ -0,0 +1,610 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// The frozen format lays out the nodes of a tree so that they can be queried
+// in place, without deserializing them. It is:
+//
+//	magic   "jbabfrzn"
+//	version byte (1)
+//	nodes   each node after its children
+//	footer  root offset (8 bytes; 0 for an empty tree), item count (8 bytes),
+//	        CRC-32 of all preceding bytes (4 bytes), magic (8 bytes)
+//
+// A node is:
+//
+//	nitems     4 bytes
+//	nchildren  4 bytes (0 or nitems+1)
+//	size       8 bytes: number of items in the subtree
+//	children   for each child, its offset (8 bytes) and size (8 bytes)
+//	itemOffs   for each item, its offset from the start of the node (4 bytes)
+//	items      for each item, a length-prefixed key and length-prefixed value
+//
+// All integers are big-endian.
+
+const (
+	frozenMagic       = "jbabfrzn"
+	frozenVersion     = 1
+	frozenHeaderSize  = len(frozenMagic) + 1
+	frozenFooterSize  = 28
+	frozenNodeHdrSize = 16
+)
+
+// ErrCorruptFrozen is returned when a frozen tree file is malformed.
+var ErrCorruptFrozen = errors.New("btree: corrupt frozen tree")
+
+// Freeze writes t to w in the frozen format, which can be opened with
+// OpenFrozen. Keys are encoded with keyCodec and values with valueCodec.
+func Freeze(w io.Writer, t *BTree, keyCodec, valueCodec Codec) error {
+	if t.cow.bplus {
+		return errBPlusUnsupported("Freeze")
+	}
+	fw := &frozenWriter{w: bufio.NewWriter(w), h: crc32.NewIEEE(), kc: keyCodec, vc: valueCodec}
+	fw.write(append([]byte(frozenMagic), frozenVersion))
+	var root uint64
+	if t.root != nil {
+		var err error
+		if root, _, err = fw.node(t.root); err != nil {
+			return err
+		}
+	}
+	var footer [frozenFooterSize]byte
+	binary.BigEndian.PutUint64(footer[0:], root)
+	binary.BigEndian.PutUint64(footer[8:], uint64(t.Len()))
+	fw.write(footer[:16])
+	binary.BigEndian.PutUint32(footer[16:], fw.h.Sum32())
+	copy(footer[20:], frozenMagic)
+	fw.write(footer[16:])
+	if fw.err != nil {
+		return fw.err
+	}
+	return fw.w.Flush()
+}
+
+type frozenWriter struct {
+	w      *bufio.Writer
+	h      hash.Hash32
+	kc, vc Codec
+	off    uint64
+	buf    []byte
+	err    error
+}
+
+func (fw *frozenWriter) write(b []byte) {
+	if fw.err != nil {
+		return
+	}
+	if _, fw.err = fw.w.Write(b); fw.err == nil {
+		fw.h.Write(b)
+		fw.off += uint64(len(b))
+	}
+}
+
+// node writes the subtree rooted at n and returns its offset and size. It
+// computes sizes rather than using n.size, which a tree created with NoIndex
+// doesn't maintain.
+func (fw *frozenWriter) node(n *node) (uint64, int, error) {
+	offs := make([]uint64, len(n.children))
+	sizes := make([]int, len(n.children))
+	size := len(n.items)
+	for i, c := range n.children {
+		var err error
+		if offs[i], sizes[i], err = fw.node(c); err != nil {
+			return 0, 0, err
+		}
+		size += sizes[i]
+	}
+	tables := frozenNodeHdrSize + 16*len(n.children) + 4*len(n.items)
+	b := fw.buf[:0]
+	b = append(b, make([]byte, tables)...)
+	binary.BigEndian.PutUint32(b[0:], uint32(len(n.items)))
+	binary.BigEndian.PutUint32(b[4:], uint32(len(n.children)))
+	binary.BigEndian.PutUint64(b[8:], uint64(size))
+	for i := range n.children {
+		binary.BigEndian.PutUint64(b[frozenNodeHdrSize+16*i:], offs[i])
+		binary.BigEndian.PutUint64(b[frozenNodeHdrSize+16*i+8:], uint64(sizes[i]))
+	}
+	itemOffs := b[frozenNodeHdrSize+16*len(n.children):]
+	for i, m := range n.items {
+		binary.BigEndian.PutUint32(itemOffs[4*i:], uint32(len(b)))
+		var err error
+		if b, err = appendField(b, fw.kc, m.key); err != nil {
+			return 0, 0, err
+		}
+		if b, err = appendField(b, fw.vc, m.value); err != nil {
+			return 0, 0, err
+		}
+		itemOffs = b[frozenNodeHdrSize+16*len(n.children):]
+	}
+	off := fw.off
+	fw.write(b)
+	fw.buf = b
+	return off, size, fw.err
+}
+
+// A FrozenTree is a read-only tree in the format written by Freeze. Its nodes
+// are read in place from the file, which is memory-mapped where the operating
+// system supports it, so a FrozenTree needs little memory of its own and any
+// number of processes can share the file's pages. Opening one is not free:
+// OpenFrozen reads the whole file once to verify its checksum.
+//
+// A FrozenTree is safe for concurrent use.
+type FrozenTree struct {
+	data   []byte
+	less   lessFunc
+	kc, vc Codec
+	root   uint64
+	count  int
+	unmap  func() error
+}
+
+// OpenFrozen opens a file written by Freeze. Keys are ordered by less, which
+// must be the ordering of the tree that was frozen, and decoded with keyCodec;
+// values are decoded with valueCodec. The file's checksum is verified, which
+// reads it once in its entirety.
+//
+// Call Close to release the file when done.
+func OpenFrozen(path string, less func(interface{}, interface{}) bool, keyCodec, valueCodec Codec) (*FrozenTree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mapFile(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	t := &FrozenTree{data: data, less: less, kc: keyCodec, vc: valueCodec, unmap: unmap}
+	if err := t.init(); err != nil {
+		unmap()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *FrozenTree) init() error {
+	d := t.data
+	if len(d) < frozenHeaderSize+frozenFooterSize || string(d[:len(frozenMagic)]) != frozenMagic || string(d[len(d)-len(frozenMagic):]) != frozenMagic {
+		return fmt.Errorf("%w: bad magic number", ErrCorruptFrozen)
+	}
+	if v := d[len(frozenMagic)]; v != frozenVersion {
+		return fmt.Errorf("btree: unsupported frozen tree version %d", v)
+	}
+	footer := d[len(d)-frozenFooterSize:]
+	if crc32.ChecksumIEEE(d[:len(d)-frozenFooterSize+16]) != binary.BigEndian.Uint32(footer[16:]) {
+		return fmt.Errorf("%w: bad checksum", ErrCorruptFrozen)
+	}
+	t.root = binary.BigEndian.Uint64(footer)
+	t.count = int(binary.BigEndian.Uint64(footer[8:]))
+	return nil
+}
+
+// Close releases the file. The tree must not be used afterwards.
+func (t *FrozenTree) Close() error {
+	t.data = nil
+	return t.unmap()
+}
+
+// Len returns the number of items in the tree.
+func (t *FrozenTree) Len() int {
+	return t.count
+}
+
+// frozenNode is a view of a node in the data.
+type frozenNode struct {
+	off       uint64
+	nitems    int
+	nchildren int
+}
+
+func (t *FrozenTree) node(off uint64) (frozenNode, error) {
+	end := uint64(len(t.data) - frozenFooterSize)
+	if off < uint64(frozenHeaderSize) || off+frozenNodeHdrSize > end {
+		return frozenNode{}, fmt.Errorf("%w: node offset %d out of range", ErrCorruptFrozen, off)
+	}
+	b := t.data[off:]
+	n := frozenNode{
+		off:       off,
+		nitems:    int(binary.BigEndian.Uint32(b)),
+		nchildren: int(binary.BigEndian.Uint32(b[4:])),
+	}
+	if (n.nchildren != 0 && n.nchildren != n.nitems+1) || off+uint64(frozenNodeHdrSize+16*n.nchildren+4*n.nitems) > end {
+		return frozenNode{}, fmt.Errorf("%w: bad node at %d", ErrCorruptFrozen, off)
+	}
+	return n, nil
+}
+
+// child returns the offset and size of the ith child of n. Since Freeze writes
+// each node after its children, a child's offset must be less than n's, which
+// also keeps a corrupt file from making a cycle.
+func (t *FrozenTree) child(n frozenNode, i int) (uint64, int, error) {
+	if i < 0 || i >= n.nchildren {
+		return 0, 0, fmt.Errorf("%w: child %d of node at %d out of range", ErrCorruptFrozen, i, n.off)
+	}
+	b := t.data[n.off+uint64(frozenNodeHdrSize+16*i):]
+	off, size := binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])
+	if off >= n.off || size > uint64(t.count) {
+		return 0, 0, fmt.Errorf("%w: bad child %d of node at %d", ErrCorruptFrozen, i, n.off)
+	}
+	return off, int(size), nil
+}
+
+// itemBytes returns the encoded key and value of the ith item of n.
+func (t *FrozenTree) itemBytes(n frozenNode, i int) (kb, vb []byte, err error) {
+	if i < 0 || i >= n.nitems {
+		return nil, nil, fmt.Errorf("%w: item %d of node at %d out of range", ErrCorruptFrozen, i, n.off)
+	}
+	tables := n.off + uint64(frozenNodeHdrSize+16*n.nchildren)
+	start := n.off + uint64(binary.BigEndian.Uint32(t.data[tables+uint64(4*i):]))
+	end := uint64(len(t.data) - frozenFooterSize)
+	if start < tables+uint64(4*n.nitems) || start >= end {
+		return nil, nil, fmt.Errorf("%w: item %d of node at %d out of range", ErrCorruptFrozen, i, n.off)
+	}
+	b := t.data[start:end]
+	if kb, b, err = readField(b); err == nil {
+		vb, _, err = readField(b)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: item %d of node at %d", ErrCorruptFrozen, i, n.off)
+	}
+	return kb, vb, nil
+}
+
+func (t *FrozenTree) key(n frozenNode, i int) (Key, error) {
+	kb, _, err := t.itemBytes(n, i)
+	if err != nil {
+		return nil, err
+	}
+	return t.kc.Decode(kb)
+}
+
+func (t *FrozenTree) item(n frozenNode, i int) (Key, Value, error) {
+	kb, vb, err := t.itemBytes(n, i)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := t.kc.Decode(kb)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := t.vc.Decode(vb)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, v, nil
+}
+
+// find is like items.find: it returns the index where k would be inserted into
+// n's items, and whether k is present at that index.
+func (t *FrozenTree) find(n frozenNode, k Key) (index int, found bool, err error) {
+	i := sort.Search(n.nitems, func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var ki Key
+		ki, err = t.key(n, i)
+		return err == nil && t.less(k, ki)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if i > 0 {
+		ki, err := t.key(n, i-1)
+		if err != nil {
+			return 0, false, err
+		}
+		if !t.less(ki, k) {
+			return i - 1, true, nil
+		}
+	}
+	return i, false, nil
+}
+
+// partialSize returns the size of the internal node n up to but not including child i.
+func (t *FrozenTree) partialSize(n frozenNode, i int) (int, error) {
+	var sz int
+	for j := 0; j < i; j++ {
+		_, s, err := t.child(n, j)
+		if err != nil {
+			return 0, err
+		}
+		sz += s + 1
+	}
+	return sz, nil
+}
+
+// Get returns the value for k, or nil if k is not in the tree.
+func (t *FrozenTree) Get(k Key) (Value, error) {
+	v, _, err := t.GetWithIndex(k)
+	return v, err
+}
+
+// GetWithIndex returns the value and index of k, or nil and -1 if k is not in the tree.
+func (t *FrozenTree) GetWithIndex(k Key) (Value, int, error) {
+	cs, found, idx, err := t.cursorsForKey(k)
+	if err != nil || !found {
+		return nil, -1, err
+	}
+	top := cs[len(cs)-1]
+	_, v, err := t.item(top.node, top.index)
+	if err != nil {
+		return nil, -1, err
+	}
+	return v, idx, nil
+}
+
+// At returns the key and value at index i. The minimum item has index 0.
+// If i is outside the range [0, t.Len()), At panics.
+func (t *FrozenTree) At(i int) (Key, Value, error) {
+	if i < 0 || i >= t.count {
+		panic("btree: index out of range")
+	}
+	cs, err := t.cursorsForIndex(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	top := cs[len(cs)-1]
+	return t.item(top.node, top.index)
+}
+
+type frozenCursor struct {
+	node  frozenNode
+	index int
+}
+
+// cursorsForKey is like node.cursorStackForKey.
+func (t *FrozenTree) cursorsForKey(k Key) (cs []frozenCursor, found bool, idx int, err error) {
+	for off := t.root; off != 0; {
+		n, err := t.node(off)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		i, found, err := t.find(n, k)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		cs = append(cs, frozenCursor{n, i})
+		if found {
+			if n.nchildren > 0 {
+				sz, err := t.partialSize(n, i+1)
+				if err != nil {
+					return nil, false, 0, err
+				}
+				i = sz - 1
+			}
+			return cs, true, idx + i, nil
+		}
+		if n.nchildren == 0 {
+			return cs, false, idx + i, nil
+		}
+		sz, err := t.partialSize(n, i)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		idx += sz
+		if off, _, err = t.child(n, i); err != nil {
+			return nil, false, 0, err
+		}
+	}
+	return nil, false, 0, nil
+}
+
+// cursorsForIndex is like node.cursorStackForIndex. i must be in range.
+func (t *FrozenTree) cursorsForIndex(i int) ([]frozenCursor, error) {
+	var cs []frozenCursor
+	off := t.root
+	for {
+		n, err := t.node(off)
+		if err != nil {
+			return nil, err
+		}
+		if n.nchildren == 0 {
+			if i >= n.nitems {
+				return nil, fmt.Errorf("%w: bad size in node at %d", ErrCorruptFrozen, n.off)
+			}
+			return append(cs, frozenCursor{n, i}), nil
+		}
+		j := 0
+		for ; j < n.nchildren; j++ {
+			c, size, err := t.child(n, j)
+			if err != nil {
+				return nil, err
+			}
+			if i < size {
+				cs = append(cs, frozenCursor{n, j})
+				off = c
+				break
+			}
+			i -= size
+			if i == 0 {
+				return append(cs, frozenCursor{n, j}), nil
+			}
+			i--
+		}
+		if j == n.nchildren {
+			return nil, fmt.Errorf("%w: bad size in node at %d", ErrCorruptFrozen, n.off)
+		}
+	}
+}
+
+// Before returns an iterator positioned just before k. After the first call to Next,
+// the iterator will be at k, or at the key just greater than k if k is not in the tree.
+// Subsequent calls to Next will traverse the tree's items in ascending order.
+func (t *FrozenTree) Before(k Key) *FrozenIterator {
+	cs, found, idx, err := t.cursorsForKey(k)
+	it := &FrozenIterator{t: t, cursors: cs, err: err, Index: idx}
+	if len(cs) > 0 {
+		// See BTree.Before.
+		if top := cs[len(cs)-1]; found || top.index < top.node.nitems {
+			it.stay = true
+		} else {
+			it.Index--
+		}
+	}
+	return it
+}
+
+// After returns an iterator positioned just after k. After the first call to Next,
+// the iterator will be at k, or at the key just less than k if k is not in the tree.
+// Subsequent calls to Next will traverse the tree's items in descending order.
+func (t *FrozenTree) After(k Key) *FrozenIterator {
+	cs, found, idx, err := t.cursorsForKey(k)
+	return &FrozenIterator{t: t, cursors: cs, err: err, stay: found, descending: true, Index: idx}
+}
+
+// BeforeIndex returns an iterator positioned just before the item with the given index.
+// The iterator will traverse the tree's items in ascending order.
+// If i is not in the range [0, t.Len()], BeforeIndex panics.
+func (t *FrozenTree) BeforeIndex(i int) *FrozenIterator {
+	return t.indexIterator(i, false)
+}
+
+// AfterIndex returns an iterator positioned just after the item with the given index.
+// The iterator will traverse the tree's items in descending order.
+// If i is not in the range [0, t.Len()], AfterIndex panics.
+func (t *FrozenTree) AfterIndex(i int) *FrozenIterator {
+	return t.indexIterator(i, true)
+}
+
+func (t *FrozenTree) indexIterator(i int, descending bool) *FrozenIterator {
+	if i < 0 || i > t.count {
+		panic("btree: index out of range")
+	}
+	if i == t.count {
+		return &FrozenIterator{}
+	}
+	cs, err := t.cursorsForIndex(i)
+	return &FrozenIterator{t: t, cursors: cs, err: err, stay: true, descending: descending, Index: i}
+}
+
+// A FrozenIterator supports traversing the items in a FrozenTree.
+type FrozenIterator struct {
+	Key   Key
+	Value Value
+	// Index is the position of the item in the tree viewed as a sequence.
+	// The minimum item has index zero.
+	Index int
+
+	t          *FrozenTree
+	cursors    []frozenCursor
+	stay       bool
+	descending bool
+	err        error
+}
+
+// Err returns the error, if any, that caused Next to return false.
+func (it *FrozenIterator) Err() error {
+	return it.err
+}
+
+// Next advances the iterator to the next item in the tree. If Next returns true,
+// the iterator's Key, Value and Index fields refer to the next item. If Next
+// returns false, there are no more items or an error occurred; call Err to
+// distinguish the two.
+func (it *FrozenIterator) Next() bool {
+	if it.err != nil || len(it.cursors) == 0 {
+		return false
+	}
+	var more bool
+	switch {
+	case it.stay:
+		it.stay = false
+		more = true
+	case it.descending:
+		more, it.err = it.dec()
+	default:
+		more, it.err = it.inc()
+	}
+	if !more || it.err != nil {
+		return false
+	}
+	top := it.cursors[len(it.cursors)-1]
+	it.Key, it.Value, it.err = it.t.item(top.node, top.index)
+	return it.err == nil
+}
+
+// inc is like Iterator.inc.
+func (it *FrozenIterator) inc() (bool, error) {
+	it.Index++
+	cs := it.cursors
+	cs[len(cs)-1].index++
+	top := cs[len(cs)-1]
+	for top.node.nchildren > 0 {
+		off, _, err := it.t.child(top.node, top.index)
+		if err != nil {
+			return false, err
+		}
+		n, err := it.t.node(off)
+		if err != nil {
+			return false, err
+		}
+		top = frozenCursor{n, 0}
+		cs = append(cs, top)
+	}
+	for top.index >= top.node.nitems {
+		cs = cs[:len(cs)-1]
+		if len(cs) == 0 {
+			it.cursors = cs
+			return false, nil
+		}
+		top = cs[len(cs)-1]
+	}
+	it.cursors = cs
+	return true, nil
+}
+
+// dec is like Iterator.dec.
+func (it *FrozenIterator) dec() (bool, error) {
+	it.Index--
+	cs := it.cursors
+	top := cs[len(cs)-1]
+	for top.node.nchildren > 0 {
+		off, _, err := it.t.child(top.node, top.index)
+		if err != nil {
+			return false, err
+		}
+		n, err := it.t.node(off)
+		if err != nil {
+			return false, err
+		}
+		top = frozenCursor{n, n.nitems}
+		cs = append(cs, top)
+	}
+	cs[len(cs)-1].index--
+	top = cs[len(cs)-1]
+	for top.index < 0 {
+		cs = cs[:len(cs)-1]
+		if len(cs) == 0 {
+			it.cursors = cs
+			return false, nil
+		}
+		cs[len(cs)-1].index--
+		top = cs[len(cs)-1]
+	}
+	it.cursors = cs
+	return true, nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func frozenAll(t *testing.T, it *FrozenIterator) []itemWithIndex {
+	t.Helper()
+	var got []itemWithIndex
+	for it.Next() {
+		got = append(got, itemWithIndex{it.Key, it.Value, it.Index})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func freezeToFile(t *testing.T, tr *BTree) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "frozen")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Freeze(f, tr, IntCodec, IntCodec); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFrozen(t *testing.T) {
+	for _, size := range []int{0, 1, 10, 1000} {
+		tr := New(3, less)
+		for _, m := range perm(size) {
+			tr.Set(m.Key, m.Value)
+		}
+		ft, err := OpenFrozen(freezeToFile(t, tr), less, IntCodec, IntCodec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ft.Len() != size {
+			t.Fatalf("size %d: Len = %d", size, ft.Len())
+		}
+		for i := 0; i < size; i++ {
+			k, v, err := ft.At(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k != i || v != i {
+				t.Fatalf("At(%d) = %v, %v", i, k, v)
+			}
+			v, idx, err := ft.GetWithIndex(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != i || idx != i {
+				t.Fatalf("GetWithIndex(%d) = %v, %d", i, v, idx)
+			}
+		}
+		if v, err := ft.Get(size); v != nil || err != nil {
+			t.Errorf("Get(%d) = %v, %v; want nil, nil", size, v, err)
+		}
+		for _, k := range []int{-1, 0, size / 2, size - 1, size} {
+			if got, want := frozenAll(t, ft.Before(k)), all(tr.Before(k)); !cmp.Equal(got, want) {
+				t.Errorf("size %d: Before(%d): got %v\nwant %v", size, k, got, want)
+			}
+			if got, want := frozenAll(t, ft.After(k)), all(tr.After(k)); !cmp.Equal(got, want) {
+				t.Errorf("size %d: After(%d): got %v\nwant %v", size, k, got, want)
+			}
+		}
+		for _, i := range []int{0, size / 2, size} {
+			if got, want := frozenAll(t, ft.BeforeIndex(i)), all(tr.BeforeIndex(i)); !cmp.Equal(got, want) {
+				t.Errorf("size %d: BeforeIndex(%d): got %v\nwant %v", size, i, got, want)
+			}
+			if got, want := frozenAll(t, ft.AfterIndex(i)), all(tr.AfterIndex(i)); !cmp.Equal(got, want) {
+				t.Errorf("size %d: AfterIndex(%d): got %v\nwant %v", size, i, got, want)
+			}
+		}
+		if err := ft.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFrozenCorrupt(t *testing.T) {
+	tr := New(3, less)
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	path := freezeToFile(t, tr)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFrozen(path, less, IntCodec, IntCodec); !errors.Is(err, ErrCorruptFrozen) {
+		t.Errorf("got %v, want ErrCorruptFrozen", err)
+	}
+}
+
+// TestFrozenBadOffsets checks that offsets and sizes in a frozen file that are
+// out of range are reported as corruption, even when the checksum is right.
+func TestFrozenBadOffsets(t *testing.T) {
+	tr := New(3, less)
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	good, err := ioutil.ReadFile(freezeToFile(t, tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer := len(good) - frozenFooterSize
+	root := binary.BigEndian.Uint64(good[footer:])
+	nitems := int(binary.BigEndian.Uint32(good[root:]))
+	nchildren := int(binary.BigEndian.Uint32(good[root+4:]))
+	if nchildren == 0 {
+		t.Fatal("root is a leaf")
+	}
+	children := int(root) + frozenNodeHdrSize
+	itemOffs := children + 16*nchildren
+	for _, test := range []struct {
+		name    string
+		corrupt func(d []byte)
+	}{
+		{"item offset", func(d []byte) {
+			for i := 0; i < nitems; i++ {
+				binary.BigEndian.PutUint32(d[itemOffs+4*i:], 1<<31)
+			}
+		}},
+		{"item offset in tables", func(d []byte) {
+			for i := 0; i < nitems; i++ {
+				binary.BigEndian.PutUint32(d[itemOffs+4*i:], frozenNodeHdrSize)
+			}
+		}},
+		{"child offset cycle", func(d []byte) {
+			for i := 0; i < nchildren; i++ {
+				binary.BigEndian.PutUint64(d[children+16*i:], root)
+			}
+		}},
+		{"child size", func(d []byte) {
+			for i := 0; i < nchildren; i++ {
+				binary.BigEndian.PutUint64(d[children+16*i+8:], 1<<62)
+			}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := append([]byte(nil), good...)
+			test.corrupt(data)
+			binary.BigEndian.PutUint32(data[footer+16:], crc32.ChecksumIEEE(data[:footer+16]))
+			path := filepath.Join(t.TempDir(), "frozen")
+			if err := ioutil.WriteFile(path, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			ft, err := OpenFrozen(path, less, IntCodec, IntCodec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ft.Close()
+			check := func(op string, err error) {
+				t.Helper()
+				if !errors.Is(err, ErrCorruptFrozen) {
+					t.Errorf("%s: got %v, want ErrCorruptFrozen", op, err)
+				}
+			}
+			_, err = ft.Get(50)
+			check("Get", err)
+			// At(0) goes straight to the first leaf; At(at) stops at the
+			// root's first item.
+			at := tr.root.children[0].size
+			_, _, err0 := ft.At(0)
+			_, _, err1 := ft.At(at)
+			if !errors.Is(err0, ErrCorruptFrozen) && !errors.Is(err1, ErrCorruptFrozen) {
+				t.Errorf("At(0), At(%d): got %v, %v; want ErrCorruptFrozen", at, err0, err1)
+			}
+			it := ft.BeforeIndex(0)
+			for it.Next() {
+			}
+			check("iteration", it.Err())
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package btree_test
+
+import (
+	"testing"
+
+	"github.com/jba/btree"
+	"github.com/jba/btree/btreetest"
+)
+
+// The fuzz input is a header byte followed by operations of two bytes each: an
+// opcode and an argument. The header chooses the degree (2, 3 or 4), whether
+// the tree is a B+ tree, and whether it has no index. The opcode is a
+// btreetest.OpKind, modulo the number of kinds; in a tree without an index, the
+// operations by index become their counterparts by key, and At becomes Get.
+// Keys are the argument modulo fuzzKeys, so that they collide often.
+const (
+	fuzzBPlus   = 1
+	fuzzNoIndex = 2
+
+	fuzzKinds = int(btreetest.OpClone) + 1 // OpClone is the last kind
+	fuzzKeys  = 64
+)
+
+func intLess(a, b interface{}) bool { return a.(int) < b.(int) }
+
+// fuzzInput encodes a header and operations as fuzz input.
+func fuzzInput(header byte, ops ...[]byte) []byte {
+	b := []byte{header}
+	for _, o := range ops {
+		b = append(b, o...)
+	}
+	return b
+}
+
+// fuzzOps returns operations of the given kind with the arguments in args.
+func fuzzOps(kind btreetest.OpKind, args ...byte) []byte {
+	var b []byte
+	for _, a := range args {
+		b = append(b, byte(kind), a)
+	}
+	return b
+}
+
+// fuzzByKey maps the operations by index to their counterparts by key, for
+// trees without an index.
+var fuzzByKey = map[btreetest.OpKind]btreetest.OpKind{
+	btreetest.OpAt:           btreetest.OpGet,
+	btreetest.OpSetWithIndex: btreetest.OpSet,
+	btreetest.OpGetWithIndex: btreetest.OpGet,
+	btreetest.OpBeforeIndex:  btreetest.OpBefore,
+	btreetest.OpAfterIndex:   btreetest.OpAfter,
+}
+
+// decodeFuzzOps decodes the operations of fuzz input.
+func decodeFuzzOps(data []byte, noIndex bool) []btreetest.Op {
+	var ops []btreetest.Op
+	for i := 0; i+1 < len(data); i += 2 {
+		kind := btreetest.OpKind(int(data[i]) % fuzzKinds)
+		if k, ok := fuzzByKey[kind]; ok && noIndex {
+			kind = k
+		}
+		arg := int(data[i+1])
+		ops = append(ops, btreetest.Op{
+			Kind:  kind,
+			Key:   arg % fuzzKeys,
+			Value: i, // distinct, so that replaced values show
+			Index: arg,
+			N:     1 + arg%4,
+		})
+	}
+	return ops
+}
+
+func FuzzBTree(f *testing.F) {
+	upTo := func(n byte) []byte {
+		var ks []byte
+		for k := byte(0); k < n; k++ {
+			ks = append(ks, k)
+		}
+		return ks
+	}
+	down := func(n byte) []byte {
+		var ks []byte
+		for k := n; k > 0; k-- {
+			ks = append(ks, k-1)
+		}
+		return ks
+	}
+	full := fuzzOps(btreetest.OpSet, upTo(16)...)
+	del := func(ks ...byte) []byte { return fuzzOps(btreetest.OpDelete, ks...) }
+	// Degree 2: deleting from the left steals from the right sibling until it
+	// must merge; deleting from the right steals from the left.
+	f.Add(fuzzInput(0, full, del(upTo(16)...)))
+	f.Add(fuzzInput(0, full, del(down(16)...)))
+	// Deleting keys in internal nodes takes their predecessors.
+	f.Add(fuzzInput(0, full, del(7, 3, 11, 1, 5, 9, 13)))
+	// Merges that empty the root, in both modes.
+	f.Add(fuzzInput(0, fuzzOps(btreetest.OpSet, 0, 1, 2, 3), del(1, 2, 0, 3)))
+	f.Add(fuzzInput(fuzzBPlus, fuzzOps(btreetest.OpSet, 0, 1, 2, 3), del(1, 2, 0, 3)))
+	f.Add(fuzzInput(fuzzBPlus, full, del(upTo(16)...)))
+	// DeleteMin and DeleteMax, on empty trees too.
+	f.Add(fuzzInput(fuzzBPlus, fuzzOps(btreetest.OpDeleteMin, 0), fuzzOps(btreetest.OpDeleteMax, 0), full,
+		fuzzOps(btreetest.OpDeleteMin, 0), fuzzOps(btreetest.OpDeleteMax, 0), fuzzOps(btreetest.OpDeleteMin, 0)))
+	// Clones, with writes to the clone.
+	f.Add(fuzzInput(0, full, fuzzOps(btreetest.OpClone, 0), del(3), fuzzOps(btreetest.OpClone, 0), fuzzOps(btreetest.OpSet, 40)))
+	f.Add(fuzzInput(fuzzBPlus, full, fuzzOps(btreetest.OpClone, 0), del(8), fuzzOps(btreetest.OpBefore, 2), fuzzOps(btreetest.OpAfter, 30)))
+	// Reads, by key and by index.
+	f.Add(fuzzInput(4, full, fuzzOps(btreetest.OpAt, 9), fuzzOps(btreetest.OpGet, 4, 99), fuzzOps(btreetest.OpBefore, 7),
+		fuzzOps(btreetest.OpAfter, 70), fuzzOps(btreetest.OpGetWithIndex, 5, 50), fuzzOps(btreetest.OpBeforeIndex, 3, 16),
+		fuzzOps(btreetest.OpAfterIndex, 12, 16)))
+	// Writes by index, Append, hints and Compact.
+	f.Add(fuzzInput(0, fuzzOps(btreetest.OpSetWithIndex, 5, 1, 9, 5), fuzzOps(btreetest.OpAppend, 3, 10, 11, 12, 13, 14)))
+	f.Add(fuzzInput(fuzzBPlus, fuzzOps(btreetest.OpAppend, upTo(20)...), fuzzOps(btreetest.OpSet, 7), fuzzOps(btreetest.OpAppend, 30)))
+	f.Add(fuzzInput(fuzzBPlus, fuzzOps(btreetest.OpSetHint, upTo(20)...), fuzzOps(btreetest.OpDeleteHint, down(20)...)))
+	f.Add(fuzzInput(0, full, fuzzOps(btreetest.OpCompact, 99), fuzzOps(btreetest.OpSetHint, 40), del(2),
+		fuzzOps(btreetest.OpCompact, 50), fuzzOps(btreetest.OpDeleteHint, 40)))
+	// Trees without an index.
+	f.Add(fuzzInput(fuzzNoIndex, full, fuzzOps(btreetest.OpAt, 3), fuzzOps(btreetest.OpAppend, 20), fuzzOps(btreetest.OpClone, 0), del(5)))
+	f.Add(fuzzInput(fuzzNoIndex|fuzzBPlus, full, fuzzOps(btreetest.OpBeforeIndex, 3), del(upTo(16)...)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		opts := btree.Options{BPlus: data[0]&fuzzBPlus != 0, NoIndex: data[0]&fuzzNoIndex != 0}
+		degree := 2 + int(data[0]>>2)%3
+		h := &btreetest.Harness{
+			New:         func() btreetest.Tree { return btree.NewWithOptions(degree, intLess, opts) },
+			Check:       func(tr btreetest.Tree) error { return tr.(*btree.BTree).Verify() },
+			IgnoreIndex: opts.NoIndex,
+		}
+		if err := h.Run(decodeFuzzOps(data[1:], opts.NoIndex)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
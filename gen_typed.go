@@ -0,0 +1,85 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// This program generates the specialized trees from int64tree.go, and their
+// tests from int64tree_test.go. Run it with go generate.
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// A keyType is a key type to generate a tree for.
+type keyType struct {
+	name  string // the Go type
+	title string // the type in exported names
+	file  string
+	test  string // the file for the tests
+}
+
+var keyTypes = []keyType{
+	{"string", "String", "stringtree.go", "stringtree_test.go"},
+}
+
+func main() {
+	src, err := ioutil.ReadFile("int64tree.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	testSrc, err := ioutil.ReadFile("int64tree_test.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, kt := range keyTypes {
+		if err := generate(src, "int64tree.go", kt.file, kt); err != nil {
+			log.Fatalf("%s: %v", kt.file, err)
+		}
+		if err := generate(testSrc, "int64tree_test.go", kt.test, kt); err != nil {
+			log.Fatalf("%s: %v", kt.test, err)
+		}
+	}
+}
+
+// generate writes the file dst for kt from src, the contents of the file from.
+func generate(src []byte, from, dst string, kt keyType) error {
+	s := string(src)
+	// Remove the comment about generation, which applies only to the source.
+	if i := strings.Index(s, "// This file is the source"); i >= 0 {
+		s = s[:i] + s[i+strings.Index(s[i:], "\n\n")+2:]
+	}
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(s, "\n") {
+		switch {
+		case strings.HasPrefix(line, "//go:generate"):
+			continue
+		case strings.HasPrefix(line, "package "):
+			buf.WriteString("// Code generated by gen_typed.go from " + from + "; DO NOT EDIT.\n\n")
+		}
+		line = strings.Replace(line, "Int64", kt.title, -1)
+		line = strings.Replace(line, "int64", kt.name, -1)
+		buf.WriteString(line)
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, out, 0644)
+}
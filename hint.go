@@ -0,0 +1,239 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+// A Hint speeds up a sequence of operations on keys that are close to one
+// another. It remembers the path from the root to the key of the last operation
+// that used it, along with the range of keys below each node on the path. An
+// operation with a hint starts at the lowest node on the path whose range
+// holds the new key, rather than at the root, so when keys are close together
+// it looks at only a node or two.
+//
+// A write that doesn't use the hint makes it stale, and the next operation
+// with it starts from the root. So does a hinted write that may have to split
+// or merge nodes above the one it starts at.
+//
+// The zero Hint is ready to use. A Hint may be used with only one tree at a
+// time, and must not be used concurrently. Until its next use, it holds on to
+// the nodes of its path.
+type Hint struct {
+	t       *BTree
+	version uint64 // t.version when path was recorded
+	path    []hintNode
+}
+
+// A hintNode is a node on the path of a Hint. All keys in the subtree of n are
+// greater than lo (or, in a B+ tree, greater than or equal to it) and less than
+// hi. A missing bound means the range is unbounded on that side.
+type hintNode struct {
+	n            *node
+	lo, hi       Key
+	hasLo, hasHi bool
+}
+
+// covers reports whether k belongs in the subtree of h.
+func (h *hintNode) covers(k Key, less lessFunc, bplus bool) bool {
+	if h.hasHi && !less(k, h.hi) {
+		return false
+	}
+	switch {
+	case !h.hasLo:
+		return true
+	case bplus:
+		return !less(k, h.lo)
+	default:
+		return less(h.lo, k)
+	}
+}
+
+// child returns the hintNode for child i of h.n.
+func (h *hintNode) child(i int) hintNode {
+	c := hintNode{n: h.n.children[i], lo: h.lo, hi: h.hi, hasLo: h.hasLo, hasHi: h.hasHi}
+	if i > 0 {
+		c.lo, c.hasLo = h.n.items[i-1].key, true
+	}
+	if i < len(h.n.items) {
+		c.hi, c.hasHi = h.n.items[i].key, true
+	}
+	return c
+}
+
+// start truncates the path of h after the lowest node whose subtree covers k,
+// and returns that node's index. If the path isn't current for t, start resets
+// it to t's root and returns 0. t must have a root.
+func (h *Hint) start(t *BTree, k Key) int {
+	if h.t != t || h.version != t.version || len(h.path) == 0 {
+		h.reset(t)
+		return 0
+	}
+	j := len(h.path) - 1
+	for j > 0 && !h.path[j].covers(k, t.less, t.cow.bplus) {
+		j--
+	}
+	h.path = h.path[:j+1]
+	return j
+}
+
+// reset makes the path of h consist of t's root alone.
+func (h *Hint) reset(t *BTree) {
+	h.t = t
+	h.version = t.version
+	h.path = append(h.path[:0], hintNode{n: t.root})
+}
+
+// descend extends the path of h from its last node down to the node where k is
+// or would be, and returns the item for k and whether it was found.
+func (h *Hint) descend(k Key, less lessFunc, bplus bool) (item, bool) {
+	for {
+		last := &h.path[len(h.path)-1]
+		n := last.n
+		var i int
+		if bplus && len(n.children) > 0 {
+			i = n.childIndexBPlus(k, less)
+		} else {
+			var found bool
+			i, found = n.items.find(k, less)
+			if found {
+				return n.items[i], true
+			}
+			if len(n.children) == 0 {
+				return item{}, false
+			}
+		}
+		h.path = append(h.path, last.child(i))
+	}
+}
+
+// GetHint is like Get, but starts its search from the path recorded in h, and
+// records the path to k in h.
+func (t *BTree) GetHint(k Key, h *Hint) Value {
+	var z Value
+	if t.root == nil {
+		return z
+	}
+	h.start(t, k)
+	m, ok := h.descend(k, t.less, t.cow.bplus)
+	if !ok {
+		return z
+	}
+	return m.value
+}
+
+// SetHint is like Set, but starts from the path recorded in h, and records the
+// path to k in h.
+func (t *BTree) SetHint(k Key, v Value, h *Hint) (old Value, present bool) {
+	if t.root == nil {
+		old, present = t.Set(k, v)
+		h.reset(t)
+		return old, present
+	}
+	j := h.start(t, k)
+	// Start from a node that t may modify and that has room for an item that
+	// a split of one of its children would move up.
+	for j > 0 && (h.path[j].n.cow != t.cow || len(h.path[j].n.items) >= t.maxItems()) {
+		j--
+	}
+	if j == 0 {
+		old, present = t.Set(k, v)
+		h.reset(t)
+	} else {
+		h.path = h.path[:j+1]
+		n := h.startWrite(t)
+		if t.cow.bplus {
+			old, present, _ = n.insertBPlus(item{k, v}, t.maxItems(), t.less, false)
+		} else {
+			old, present, _ = n.insert(item{k, v}, t.maxItems(), t.less, false)
+		}
+		if !present {
+			h.addSize(1)
+			t.count++
+		}
+		h.finishWrite(t)
+		if t.watchers != nil {
+			t.notifySet(k, v, old, present)
+		}
+	}
+	h.descend(k, t.less, t.cow.bplus)
+	return old, present
+}
+
+// DeleteHint is like Delete, but starts from the path recorded in h, and
+// records the path to where k was in h.
+func (t *BTree) DeleteHint(k Key, h *Hint) (Value, bool) {
+	if t.root == nil {
+		var z Value
+		return z, false
+	}
+	j := h.start(t, k)
+	// Start from a node that t may modify and that can lose an item to a merge
+	// of two of its children.
+	for j > 0 && (h.path[j].n.cow != t.cow || len(h.path[j].n.items) <= t.minItems()) {
+		j--
+	}
+	var out item
+	var removed bool
+	if j == 0 {
+		out, removed = t.deleteItem(k, removeItem)
+		h.reset(t)
+	} else {
+		h.path = h.path[:j+1]
+		n := h.startWrite(t)
+		if t.cow.bplus {
+			out, removed = n.removeBPlus(k, t.minItems(), removeItem, t.less)
+		} else {
+			out, removed = n.remove(k, t.minItems(), removeItem, t.less)
+		}
+		if removed {
+			h.addSize(-1)
+			t.count--
+		}
+		h.finishWrite(t)
+		if removed && t.watchers != nil {
+			t.notify(Event{Kind: EventDelete, Key: out.key, Old: out.value})
+		}
+	}
+	h.descend(k, t.less, t.cow.bplus)
+	return out.value, removed
+}
+
+// startWrite prepares the nodes on the path of h, which t must own, for a write
+// to the subtree of the last one, and returns that node. Since t owns the last
+// node, it owns the others, which were made writable on the way down to it.
+func (h *Hint) startWrite(t *BTree) *node {
+	var n *node
+	for _, p := range h.path {
+		// Clear the hash and cum of each node, as a write from the root would.
+		n = p.n.mutableFor(t.cow)
+	}
+	return n
+}
+
+// addSize adds d to the sizes of the nodes above the last one on the path of h.
+func (h *Hint) addSize(d int) {
+	for _, p := range h.path[:len(h.path)-1] {
+		p.n.size += d
+	}
+}
+
+// finishWrite does what a write from the root does at the end, and marks the
+// path of h current.
+func (h *Hint) finishWrite(t *BTree) {
+	t.version++
+	t.root.updateCum()
+	if t.hasher != nil {
+		t.hasher.hash(t.root)
+	}
+	h.version = t.version
+}
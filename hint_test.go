@@ -0,0 +1,178 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHint(t *testing.T) {
+	const size = 2000
+	for _, bplus := range []bool{false, true} {
+		for _, degree := range []int{2, 3, 8} {
+			tr := NewWithOptions(degree, less, Options{BPlus: bplus})
+			model := map[int]int{}
+			var h Hint
+			// Keys wander, so most operations are near the previous one.
+			k := size / 2
+			for i := 0; i < 20*size; i++ {
+				k += rand.Intn(11) - 5
+				if k < 0 || k >= size {
+					k = rand.Intn(size)
+				}
+				switch rand.Intn(4) {
+				case 0, 1:
+					old, present := tr.SetHint(k, i, &h)
+					wold, wpresent := model[k]
+					if present != wpresent || (present && old != wold) {
+						t.Fatalf("SetHint(%d) = %v, %t; want %v, %t", k, old, present, wold, wpresent)
+					}
+					model[k] = i
+				case 2:
+					old, removed := tr.DeleteHint(k, &h)
+					wold, wpresent := model[k]
+					if removed != wpresent || (removed && old != wold) {
+						t.Fatalf("DeleteHint(%d) = %v, %t; want %v, %t", k, old, removed, wold, wpresent)
+					}
+					delete(model, k)
+				case 3:
+					got := tr.GetHint(k, &h)
+					if want, ok := model[k]; (ok && got != want) || (!ok && got != nil) {
+						t.Fatalf("GetHint(%d) = %v, want %v", k, got, want)
+					}
+				}
+				if i%500 == 0 {
+					// An unhinted write makes the hint stale.
+					tr.Set(-1, -1)
+					tr.Delete(-1)
+				}
+				if i%2000 == 0 {
+					checkHintTree(t, tr)
+				}
+			}
+			checkHintTree(t, tr)
+			if tr.Len() != len(model) {
+				t.Fatalf("Len = %d, want %d", tr.Len(), len(model))
+			}
+			for k, v := range model {
+				if got := tr.Get(k); got != v {
+					t.Fatalf("Get(%d) = %v, want %d", k, got, v)
+				}
+			}
+		}
+	}
+}
+
+func checkHintTree(t *testing.T, tr *BTree) {
+	t.Helper()
+	if tr.cow.bplus {
+		checkTree(t, tr)
+	} else {
+		checkTree(t, tr)
+	}
+}
+
+func TestHintSequential(t *testing.T) {
+	// Sequential access with a hint rarely goes back to the root.
+	tr := New(8, less)
+	var h Hint
+	for _, m := range perm(5000) {
+		tr.SetHint(m.Key, m.Value, &h)
+	}
+	checkTree(t, tr)
+	starts := 0
+	for i := 0; i < 5000; i++ {
+		if got := tr.GetHint(i, &h); got != i {
+			t.Fatalf("GetHint(%d) = %v", i, got)
+		}
+		if h.start(tr, i+1) == 0 {
+			starts++
+		}
+	}
+	if starts > 5000/10 {
+		t.Errorf("%d of 5000 lookups started at the root", starts)
+	}
+	for i := 0; i < 5000; i += 2 {
+		if _, ok := tr.DeleteHint(i, &h); !ok {
+			t.Fatalf("DeleteHint(%d) not found", i)
+		}
+	}
+	checkTree(t, tr)
+	var want []itemWithIndex
+	for i := 1; i < 5000; i += 2 {
+		want = append(want, itemWithIndex{i, i, len(want)})
+	}
+	if got := all(tr.BeforeIndex(0)); !cmp.Equal(got, want) {
+		t.Fatalf("got %d items, want %d", len(got), len(want))
+	}
+}
+
+func TestHintCloneHashWatch(t *testing.T) {
+	tr := New(3, less)
+	tr.EnableHashing(IntCodec, IntCodec)
+	var events []Event
+	tr.Watch(nil, nil, func(e Event) { events = append(events, e) })
+	var h Hint
+	for _, m := range rang(300) {
+		tr.SetHint(m.Key, m.Value, &h)
+	}
+	clone := tr.Clone()
+	for i := 100; i < 200; i++ {
+		tr.DeleteHint(i, &h)
+		tr.SetHint(i+1000, i, &h)
+	}
+	checkTree(t, tr)
+	if got := all(clone.BeforeIndex(0)); !cmp.Equal(got, rang(300)) {
+		t.Fatal("hinted writes changed a clone")
+	}
+	// Hinted writes keep the hash up to date.
+	fresh := tr.Clone()
+	fresh.EnableHashing(IntCodec, IntCodec)
+	if tr.RootHash() != fresh.RootHash() {
+		t.Error("incrementally maintained hash differs from a fresh one")
+	}
+	if got, want := len(events), 300+200; got != want {
+		t.Errorf("got %d events, want %d", got, want)
+	}
+
+	// A hint follows the tree it is used with.
+	if got := clone.GetHint(150, &h); got != 150 {
+		t.Errorf("clone.GetHint(150) = %v", got)
+	}
+	if got := tr.GetHint(150, &h); got != nil {
+		t.Errorf("tr.GetHint(150) = %v", got)
+	}
+}
+
+func TestHintEmpty(t *testing.T) {
+	var h Hint
+	tr := New(2, less)
+	if got := tr.GetHint(1, &h); got != nil {
+		t.Errorf("GetHint on empty tree = %v", got)
+	}
+	if _, ok := tr.DeleteHint(1, &h); ok {
+		t.Error("DeleteHint on empty tree succeeded")
+	}
+	tr.SetHint(1, 1, &h)
+	if _, ok := tr.DeleteHint(1, &h); !ok {
+		t.Error("DeleteHint(1) failed")
+	}
+	if got := tr.GetHint(1, &h); got != nil || tr.Len() != 0 {
+		t.Errorf("GetHint(1) = %v, Len = %d", got, tr.Len())
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Incremental snapshots take advantage of copy-on-write. Once a snapshot of a
+// tree is written, the tree's nodes are frozen, so later writes to the tree copy
+// them instead of modifying them. A node that appears in a later snapshot is
+// therefore either identical to one already written, or new.
+//
+// Each node written is given an ID that is unique within a chain of snapshots.
+// The format of a snapshot is:
+//
+//	magic    the 8 bytes "jbabtinc"
+//	version  one byte, currently 1
+//	chain    8 bytes identifying the chain of snapshots
+//	seq      uvarint, the position of this snapshot in the chain; 0 for the base snapshot
+//	degree   uvarint, the degree of the tree
+//	count    uvarint, the number of nodes that follow
+//	nodes    count nodes, each of which is
+//	             id        uvarint
+//	             nitems    uvarint
+//	             items     nitems pairs of length-prefixed key and length-prefixed value
+//	             nchildren uvarint, either 0 or nitems+1
+//	             children  nchildren uvarint IDs of nodes in this or an earlier snapshot
+//	root     uvarint ID of the root, or 0 for an empty tree
+//	checksum big-endian CRC-32 (IEEE) of all preceding bytes
+//
+// Nodes are written children first, so every child ID refers to a node that has
+// already been read.
+const (
+	incrementalMagic   = "jbabtinc"
+	incrementalVersion = 1
+)
+
+// ErrCorruptIncremental is returned by Restorer.Apply when its input is not a
+// valid incremental snapshot, or not the next one in the chain.
+var ErrCorruptIncremental = errors.New("btree: corrupt or out-of-sequence incremental snapshot")
+
+// minPrune is the smallest number of remembered nodes at which Snapshotter and
+// Restorer forget unreachable nodes.
+const minPrune = 1024
+
+// A Snapshotter writes a chain of snapshots of a tree. The first is a base
+// snapshot holding the whole tree. Each later one is a delta holding only the
+// nodes created since the previous snapshot, so its size is proportional to the
+// amount of change rather than to the size of the tree.
+//
+// Writing a snapshot freezes the tree, as Clone does: its subsequent writes will
+// copy nodes rather than modify them.
+type Snapshotter struct {
+	kc, vc  Codec
+	chain   [8]byte
+	seq     uint64
+	nextID  uint64
+	ids     map[*node]uint64 // nodes already written
+	root    *node            // root of the last snapshot
+	pruneAt int              // prune ids when it gets this big
+}
+
+// NewSnapshotter returns a Snapshotter that starts a new chain, encoding keys and
+// values with keyCodec and valueCodec.
+func NewSnapshotter(keyCodec, valueCodec Codec) *Snapshotter {
+	s := &Snapshotter{
+		kc:      keyCodec,
+		vc:      valueCodec,
+		nextID:  1,
+		ids:     map[*node]uint64{},
+		pruneAt: minPrune,
+	}
+	if _, err := rand.Read(s.chain[:]); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Write writes the next snapshot of t in the chain to w.
+func (s *Snapshotter) Write(w io.Writer, t *BTree) error {
+	if t.cow.bplus {
+		return errBPlusUnsupported("incremental snapshot")
+	}
+	t.freeze()
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	mw := io.MultiWriter(bw, crc)
+
+	// Collect the nodes that haven't been written, children first.
+	var fresh []*node
+	var collect func(n *node)
+	collect = func(n *node) {
+		if _, ok := s.ids[n]; ok {
+			return
+		}
+		for _, c := range n.children {
+			collect(c)
+		}
+		fresh = append(fresh, n)
+	}
+	if t.root != nil {
+		collect(t.root)
+	}
+
+	buf := append([]byte(incrementalMagic), incrementalVersion)
+	buf = append(buf, s.chain[:]...)
+	buf = appendUvarint(buf, s.seq)
+	buf = appendUvarint(buf, uint64(t.degree))
+	buf = appendUvarint(buf, uint64(len(fresh)))
+	newIDs := make(map[*node]uint64, len(fresh))
+	id := func(n *node) uint64 {
+		if id, ok := s.ids[n]; ok {
+			return id
+		}
+		return newIDs[n]
+	}
+	nextID := s.nextID
+	for _, n := range fresh {
+		newIDs[n] = nextID
+		buf = appendUvarint(buf, nextID)
+		nextID++
+		buf = appendUvarint(buf, uint64(len(n.items)))
+		for _, m := range n.items {
+			var err error
+			if buf, err = appendField(buf, s.kc, m.key); err != nil {
+				return err
+			}
+			if buf, err = appendField(buf, s.vc, m.value); err != nil {
+				return err
+			}
+		}
+		buf = appendUvarint(buf, uint64(len(n.children)))
+		for _, c := range n.children {
+			buf = appendUvarint(buf, id(c))
+		}
+		if len(buf) >= 4096 {
+			if _, err := mw.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	var rootID uint64
+	if t.root != nil {
+		rootID = id(t.root)
+	}
+	buf = appendUvarint(buf, rootID)
+	if _, err := mw.Write(buf); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err := bw.Write(sum[:]); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	// The snapshot is written; commit to it.
+	for n, id := range newIDs {
+		s.ids[n] = id
+	}
+	s.nextID = nextID
+	s.seq++
+	s.root = t.root
+	if len(s.ids) >= s.pruneAt {
+		s.ids = pruneIDs(s.ids, s.root)
+		s.pruneAt = maxInt(2*len(s.ids), minPrune)
+	}
+	return nil
+}
+
+// pruneIDs returns the entries of ids for nodes reachable from root.
+func pruneIDs(ids map[*node]uint64, root *node) map[*node]uint64 {
+	live := map[*node]uint64{}
+	var walk func(n *node)
+	walk = func(n *node) {
+		live[n] = ids[n]
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	if root != nil {
+		walk(root)
+	}
+	return live
+}
+
+// A Restorer rebuilds a tree from a chain of snapshots written by a Snapshotter.
+type Restorer struct {
+	less    lessFunc
+	kc, vc  Codec
+	cow     *copyOnWriteContext // owns the restored nodes
+	chain   [8]byte
+	seq     uint64 // sequence number of the next snapshot
+	degree  int
+	nodes   map[uint64]*node
+	maxID   uint64 // largest node ID seen
+	root    *node
+	pruneAt int
+}
+
+// NewRestorer returns a Restorer for a tree with the given less function,
+// decoding keys and values with keyCodec and valueCodec.
+func NewRestorer(less func(interface{}, interface{}) bool, keyCodec, valueCodec Codec) *Restorer {
+	return &Restorer{
+		less:    less,
+		kc:      keyCodec,
+		vc:      valueCodec,
+		cow:     &copyOnWriteContext{},
+		nodes:   map[uint64]*node{},
+		pruneAt: minPrune,
+	}
+}
+
+// Apply reads the next snapshot in the chain from rd. The first call must be
+// given a base snapshot, and each later call the delta that followed it. If Apply
+// returns an error, the Restorer is unchanged.
+func (r *Restorer) Apply(rd io.Reader) error {
+	cr := &checksumReader{r: bufio.NewReader(rd), h: crc32.NewIEEE()}
+	var hdr [len(incrementalMagic) + 1 + 8]byte
+	if _, err := io.ReadFull(cr, hdr[:]); err != nil {
+		return r.corrupt(err)
+	}
+	if string(hdr[:len(incrementalMagic)]) != incrementalMagic {
+		return fmt.Errorf("%w: bad magic number", ErrCorruptIncremental)
+	}
+	if v := hdr[len(incrementalMagic)]; v != incrementalVersion {
+		return fmt.Errorf("btree: unsupported incremental snapshot version %d", v)
+	}
+	var chain [8]byte
+	copy(chain[:], hdr[len(incrementalMagic)+1:])
+	seq, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return r.corrupt(err)
+	}
+	if seq != r.seq || (seq > 0 && chain != r.chain) {
+		return fmt.Errorf("%w: got snapshot %d of chain %x, want snapshot %d of chain %x",
+			ErrCorruptIncremental, seq, chain, r.seq, r.chain)
+	}
+	degree, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return r.corrupt(err)
+	}
+	if degree < 2 {
+		return fmt.Errorf("%w: bad degree %d", ErrCorruptIncremental, degree)
+	}
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return r.corrupt(err)
+	}
+	lookup := func(id uint64, fresh map[uint64]*node) *node {
+		if n, ok := fresh[id]; ok {
+			return n
+		}
+		return r.nodes[id]
+	}
+	fresh := map[uint64]*node{}
+	var buf []byte
+	for i := uint64(0); i < count; i++ {
+		id, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return r.corrupt(err)
+		}
+		if id == 0 || lookup(id, fresh) != nil {
+			return fmt.Errorf("%w: duplicate node ID %d", ErrCorruptIncremental, id)
+		}
+		n := &node{cow: r.cow}
+		nitems, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return r.corrupt(err)
+		}
+		if nitems > uint64(2*degree-1) {
+			return fmt.Errorf("%w: node %d has %d items", ErrCorruptIncremental, id, nitems)
+		}
+		n.items = make(items, nitems)
+		for j := range n.items {
+			var k, v interface{}
+			if k, buf, err = readCodecField(cr, r.kc, buf); err != nil {
+				return r.corrupt(err)
+			}
+			if v, buf, err = readCodecField(cr, r.vc, buf); err != nil {
+				return r.corrupt(err)
+			}
+			n.items[j] = item{k, v}
+		}
+		nchildren, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return r.corrupt(err)
+		}
+		if nchildren != 0 && nchildren != nitems+1 {
+			return fmt.Errorf("%w: node %d has %d items and %d children", ErrCorruptIncremental, id, nitems, nchildren)
+		}
+		if nchildren > 0 {
+			n.children = make(children, nchildren)
+		}
+		for j := range n.children {
+			cid, err := binary.ReadUvarint(cr)
+			if err != nil {
+				return r.corrupt(err)
+			}
+			if n.children[j] = lookup(cid, fresh); n.children[j] == nil {
+				return fmt.Errorf("%w: node %d has unknown child %d", ErrCorruptIncremental, id, cid)
+			}
+		}
+		n.size = n.computeSize()
+		n.updateCum()
+		fresh[id] = n
+	}
+	rootID, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return r.corrupt(err)
+	}
+	var root *node
+	if rootID != 0 {
+		if root = lookup(rootID, fresh); root == nil {
+			return fmt.Errorf("%w: unknown root %d", ErrCorruptIncremental, rootID)
+		}
+	}
+	want := cr.h.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(cr.r, sum[:]); err != nil {
+		return r.corrupt(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return fmt.Errorf("%w: bad checksum", ErrCorruptIncremental)
+	}
+
+	// The snapshot is valid; commit to it.
+	for id, n := range fresh {
+		r.nodes[id] = n
+		if id > r.maxID {
+			r.maxID = id
+		}
+	}
+	r.chain = chain
+	r.seq++
+	r.degree = int(degree)
+	r.root = root
+	if len(r.nodes) >= r.pruneAt {
+		r.prune()
+		r.pruneAt = maxInt(2*len(r.nodes), minPrune)
+	}
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// prune forgets nodes that are not reachable from the root.
+func (r *Restorer) prune() {
+	ids := map[*node]uint64{}
+	for id, n := range r.nodes {
+		ids[n] = id
+	}
+	r.nodes = map[uint64]*node{}
+	for n, id := range pruneIDs(ids, r.root) {
+		r.nodes[id] = n
+	}
+}
+
+func (r *Restorer) corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", ErrCorruptIncremental, io.ErrUnexpectedEOF)
+	}
+	if errors.Is(err, ErrCorruptSnapshot) {
+		// From readCodecField.
+		return fmt.Errorf("%w: %v", ErrCorruptIncremental, err)
+	}
+	return err
+}
+
+// Tree returns a tree holding the contents of the most recently applied
+// snapshot. It returns nil if no snapshot has been applied.
+//
+// The tree shares nodes with the Restorer but is safe to modify, since writes
+// to it copy the shared nodes. Calling Tree again after another call to Apply
+// returns a new tree.
+func (r *Restorer) Tree() *BTree {
+	if r.seq == 0 {
+		return nil
+	}
+	t := New(r.degree, r.less)
+	t.root = r.root
+	return t
+}
+
+// Snapshotter returns a Snapshotter that continues the chain that r has read, so
+// that a process restored from a chain of snapshots can go on adding deltas to it.
+// The tree passed to the Snapshotter's first Write should be one returned by r.Tree,
+// possibly with modifications.
+func (r *Restorer) Snapshotter() *Snapshotter {
+	s := &Snapshotter{
+		kc:      r.kc,
+		vc:      r.vc,
+		chain:   r.chain,
+		seq:     r.seq,
+		nextID:  r.maxID + 1,
+		ids:     map[*node]uint64{},
+		root:    r.root,
+		pruneAt: r.pruneAt,
+	}
+	for id, n := range r.nodes {
+		s.ids[n] = id
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIncrementalSnapshots(t *testing.T) {
+	const size = 20000 // big enough to exercise pruning
+	tr := New(8, less)
+	for _, m := range perm(size) {
+		tr.Set(m.Key, m.Value)
+	}
+	s := NewSnapshotter(IntCodec, IntCodec)
+	var snaps [][]byte
+	var wants [][]itemWithIndex
+	write := func() {
+		var buf bytes.Buffer
+		if err := s.Write(&buf, tr); err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, buf.Bytes())
+		wants = append(wants, all(tr.BeforeIndex(0)))
+	}
+	write()
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 20; j++ {
+			k := rand.Intn(2 * size)
+			if rand.Intn(2) == 0 {
+				tr.Set(k, -k)
+			} else {
+				tr.Delete(k)
+			}
+		}
+		write()
+		if got, base := len(snaps[len(snaps)-1]), len(snaps[0]); got > base/4 {
+			t.Errorf("delta %d is %d bytes; base is %d", i, got, base)
+		}
+	}
+
+	r := NewRestorer(less, IntCodec, IntCodec)
+	if r.Tree() != nil {
+		t.Fatal("got tree before applying a snapshot")
+	}
+	for i, snap := range snaps {
+		if err := r.Apply(bytes.NewReader(snap)); err != nil {
+			t.Fatalf("snapshot %d: %v", i, err)
+		}
+		got := r.Tree()
+		checkTree(t, got)
+		if !cmp.Equal(all(got.BeforeIndex(0)), wants[i]) {
+			t.Fatalf("snapshot %d: contents differ", i)
+		}
+	}
+	// Modifying a restored tree must not affect the Restorer.
+	r.Tree().Set(-1, -1)
+	if r.Tree().Has(-1) {
+		t.Fatal("modification of restored tree is visible in the Restorer")
+	}
+
+	// Continue the chain after restoring.
+	tr2 := r.Tree()
+	tr2.Set(-1, 1)
+	var buf bytes.Buffer
+	s2 := r.Snapshotter()
+	if err := s2.Write(&buf, tr2); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > len(snaps[0])/4 {
+		t.Errorf("delta after restore is %d bytes; base is %d", buf.Len(), len(snaps[0]))
+	}
+	if err := r.Apply(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Tree().Get(-1); got != 1 {
+		t.Errorf("got %v, want 1", got)
+	}
+}
+
+func TestIncrementalErrors(t *testing.T) {
+	tr := New(2, less)
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	s := NewSnapshotter(IntCodec, IntCodec)
+	var base, delta bytes.Buffer
+	if err := s.Write(&base, tr); err != nil {
+		t.Fatal(err)
+	}
+	tr.Set(200, 200)
+	if err := s.Write(&delta, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRestorer(less, IntCodec, IntCodec)
+	if err := r.Apply(bytes.NewReader(delta.Bytes())); !errors.Is(err, ErrCorruptIncremental) {
+		t.Errorf("delta without base: got %v", err)
+	}
+	other := NewSnapshotter(IntCodec, IntCodec)
+	var otherBase, otherDelta bytes.Buffer
+	other.Write(&otherBase, tr)
+	other.Write(&otherDelta, tr)
+	if err := r.Apply(bytes.NewReader(base.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Apply(bytes.NewReader(otherDelta.Bytes())); !errors.Is(err, ErrCorruptIncremental) {
+		t.Errorf("delta from another chain: got %v", err)
+	}
+	data := delta.Bytes()
+	if err := r.Apply(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrCorruptIncremental) {
+		t.Errorf("truncated delta: got %v", err)
+	}
+	if err := r.Apply(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Tree().Len(); got != 101 {
+		t.Errorf("got %d items, want 101", got)
+	}
+}
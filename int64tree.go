@@ -0,0 +1,663 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate go run gen_typed.go
+
+package btree
+
+// This file is the source for the other specialized trees, which gen_typed.go
+// generates from it by replacing int64 with the key type. So it must mention
+// int64 only as the key type.
+
+// Int64Tree is a BTree whose keys are int64s. Its nodes store keys in a
+// slice of int64 rather than as interfaces, which saves memory and boxing, and
+// it compares keys directly instead of calling a less function.
+//
+// Int64Tree has the same semantics as BTree, and the same methods for writing,
+// lookup, rank, iteration and cloning.
+type Int64Tree struct {
+	degree int
+	root   *int64Node
+	cow    *copyOnWriteContext
+}
+
+// NewInt64Tree creates a new Int64Tree with the given degree. See New.
+func NewInt64Tree(degree int) *Int64Tree {
+	if degree <= 1 {
+		panic("bad degree")
+	}
+	return &Int64Tree{degree: degree, cow: &copyOnWriteContext{}}
+}
+
+// int64Node is a node of Int64Tree. It keeps the invariants of node, with
+// keys[i] and values[i] together playing the part of items[i].
+type int64Node struct {
+	keys     []int64
+	values   []Value
+	children []*int64Node
+	size     int
+	cow      *copyOnWriteContext
+}
+
+// find returns the index where k is or would be inserted, and whether it is there.
+func (n *int64Node) find(k int64) (int, bool) {
+	i, j := 0, len(n.keys)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		if n.keys[h] < k {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	return i, i < len(n.keys) && n.keys[i] == k
+}
+
+func (n *int64Node) insertItemAt(i int, k int64, v Value) {
+	var zero int64
+	n.keys = append(n.keys, zero)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = k
+	n.values = append(n.values, nil)
+	copy(n.values[i+1:], n.values[i:])
+	n.values[i] = v
+}
+
+func (n *int64Node) removeItemAt(i int) (int64, Value) {
+	k, v := n.keys[i], n.values[i]
+	var zero int64
+	last := len(n.keys) - 1
+	copy(n.keys[i:], n.keys[i+1:])
+	n.keys[last] = zero
+	n.keys = n.keys[:last]
+	copy(n.values[i:], n.values[i+1:])
+	n.values[last] = nil
+	n.values = n.values[:last]
+	return k, v
+}
+
+func (n *int64Node) insertChildAt(i int, c *int64Node) {
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = c
+}
+
+func (n *int64Node) removeChildAt(i int) *int64Node {
+	c := n.children[i]
+	last := len(n.children) - 1
+	copy(n.children[i:], n.children[i+1:])
+	n.children[last] = nil
+	n.children = n.children[:last]
+	return c
+}
+
+func (n *int64Node) computeSize() int {
+	sz := len(n.keys)
+	for _, c := range n.children {
+		sz += c.size
+	}
+	return sz
+}
+
+func (n *int64Node) mutableFor(cow *copyOnWriteContext) *int64Node {
+	if n.cow == cow {
+		return n
+	}
+	out := &int64Node{
+		keys:   append(make([]int64, 0, cap(n.keys)), n.keys...),
+		values: append(make([]Value, 0, cap(n.values)), n.values...),
+		size:   n.size,
+		cow:    cow,
+	}
+	if len(n.children) > 0 {
+		out.children = append(make([]*int64Node, 0, cap(n.children)), n.children...)
+	}
+	return out
+}
+
+func (n *int64Node) mutableChild(i int) *int64Node {
+	c := n.children[i].mutableFor(n.cow)
+	n.children[i] = c
+	return c
+}
+
+// split is like node.split.
+func (n *int64Node) split(i int) (int64, Value, *int64Node) {
+	k, v := n.keys[i], n.values[i]
+	next := &int64Node{cow: n.cow}
+	next.keys = append(next.keys, n.keys[i+1:]...)
+	next.values = append(next.values, n.values[i+1:]...)
+	n.truncate(i)
+	if len(n.children) > 0 {
+		next.children = append(next.children, n.children[i+1:]...)
+		for j := i + 1; j < len(n.children); j++ {
+			n.children[j] = nil
+		}
+		n.children = n.children[:i+1]
+	}
+	n.size = n.computeSize()
+	next.size = next.computeSize()
+	return k, v, next
+}
+
+// truncate reduces n to its first i items, clearing the rest to allow GC.
+func (n *int64Node) truncate(i int) {
+	var zero int64
+	for j := i; j < len(n.keys); j++ {
+		n.keys[j] = zero
+		n.values[j] = nil
+	}
+	n.keys = n.keys[:i]
+	n.values = n.values[:i]
+}
+
+// maybeSplitChild is like node.maybeSplitChild.
+func (n *int64Node) maybeSplitChild(i, maxItems int) bool {
+	if len(n.children[i].keys) < maxItems {
+		return false
+	}
+	first := n.mutableChild(i)
+	k, v, second := first.split(maxItems / 2)
+	n.insertItemAt(i, k, v)
+	n.insertChildAt(i+1, second)
+	return true
+}
+
+// insert is like node.insert.
+func (n *int64Node) insert(k int64, v Value, maxItems int, withIndex bool) (old Value, present bool, idx int) {
+	i, found := n.find(k)
+	if !found && len(n.children) > 0 && n.maybeSplitChild(i, maxItems) {
+		switch {
+		case k < n.keys[i]:
+			// no change, we want first split node
+		case n.keys[i] < k:
+			i++ // we want second split node
+		default:
+			found = true
+		}
+	}
+	if found {
+		old, n.values[i] = n.values[i], v
+		if withIndex {
+			idx = n.itemIndex(i)
+		}
+		return old, true, idx
+	}
+	if len(n.children) == 0 {
+		n.insertItemAt(i, k, v)
+		n.size++
+		return old, false, i
+	}
+	old, present, idx = n.mutableChild(i).insert(k, v, maxItems, withIndex)
+	if !present {
+		n.size++
+	}
+	if withIndex {
+		idx += n.partialSize(i)
+	}
+	return old, present, idx
+}
+
+// get is like node.get.
+func (n *int64Node) get(k int64, withIndex bool) (Value, bool, int) {
+	idx := 0
+	for {
+		i, found := n.find(k)
+		if found {
+			return n.values[i], true, idx + n.itemIndex(i)
+		}
+		if len(n.children) == 0 {
+			return nil, false, -1
+		}
+		if withIndex {
+			idx += n.partialSize(i)
+		}
+		n = n.children[i]
+	}
+}
+
+// itemIndex is like node.itemIndex.
+func (n *int64Node) itemIndex(i int) int {
+	if len(n.children) == 0 {
+		return i
+	}
+	return n.partialSize(i+1) - 1
+}
+
+// partialSize is like node.partialSize.
+func (n *int64Node) partialSize(i int) int {
+	sz := i
+	for _, c := range n.children[:i] {
+		sz += c.size
+	}
+	return sz
+}
+
+// remove is like node.remove.
+func (n *int64Node) remove(k int64, minItems int, typ toRemove) (int64, Value, bool) {
+	var i int
+	var found bool
+	switch typ {
+	case removeMax:
+		i = len(n.keys)
+		if len(n.children) == 0 {
+			i, found = i-1, true
+		}
+	case removeMin:
+		found = len(n.children) == 0
+	case removeItem:
+		i, found = n.find(k)
+	default:
+		panic("invalid type")
+	}
+	if len(n.children) == 0 {
+		if !found {
+			return k, nil, false
+		}
+		n.size--
+		outk, outv := n.removeItemAt(i)
+		return outk, outv, true
+	}
+	if len(n.children[i].keys) <= minItems {
+		return n.growChildAndRemove(i, k, minItems, typ)
+	}
+	child := n.mutableChild(i)
+	if found {
+		// Replace the item with its predecessor.
+		outk, outv := n.keys[i], n.values[i]
+		n.keys[i], n.values[i], _ = child.remove(k, minItems, removeMax)
+		n.size--
+		return outk, outv, true
+	}
+	outk, outv, removed := child.remove(k, minItems, typ)
+	if removed {
+		n.size--
+	}
+	return outk, outv, removed
+}
+
+// growChildAndRemove is like node.growChildAndRemove.
+func (n *int64Node) growChildAndRemove(i int, k int64, minItems int, typ toRemove) (int64, Value, bool) {
+	if i > 0 && len(n.children[i-1].keys) > minItems {
+		// Steal from left child
+		child := n.mutableChild(i)
+		stealFrom := n.mutableChild(i - 1)
+		sk, sv := stealFrom.removeItemAt(len(stealFrom.keys) - 1)
+		stealFrom.size--
+		child.insertItemAt(0, n.keys[i-1], n.values[i-1])
+		child.size++
+		n.keys[i-1], n.values[i-1] = sk, sv
+		if len(stealFrom.children) > 0 {
+			c := stealFrom.removeChildAt(len(stealFrom.children) - 1)
+			stealFrom.size -= c.size
+			child.insertChildAt(0, c)
+			child.size += c.size
+		}
+	} else if i < len(n.keys) && len(n.children[i+1].keys) > minItems {
+		// Steal from right child
+		child := n.mutableChild(i)
+		stealFrom := n.mutableChild(i + 1)
+		sk, sv := stealFrom.removeItemAt(0)
+		stealFrom.size--
+		child.insertItemAt(len(child.keys), n.keys[i], n.values[i])
+		child.size++
+		n.keys[i], n.values[i] = sk, sv
+		if len(stealFrom.children) > 0 {
+			c := stealFrom.removeChildAt(0)
+			stealFrom.size -= c.size
+			child.children = append(child.children, c)
+			child.size += c.size
+		}
+	} else {
+		if i >= len(n.keys) {
+			i--
+		}
+		child := n.mutableChild(i)
+		// Merge with right child
+		mk, mv := n.removeItemAt(i)
+		mergeChild := n.removeChildAt(i + 1)
+		child.keys = append(append(child.keys, mk), mergeChild.keys...)
+		child.values = append(append(child.values, mv), mergeChild.values...)
+		child.children = append(child.children, mergeChild.children...)
+		child.size = child.computeSize()
+	}
+	return n.remove(k, minItems, typ)
+}
+
+// cursorStackForKey is like node.cursorStackForKey.
+func (n *int64Node) cursorStackForKey(k int64, cs []int64Cursor) ([]int64Cursor, bool, int) {
+	idx := 0
+	for {
+		i, found := n.find(k)
+		cs = append(cs, int64Cursor{n, i})
+		if found {
+			return cs, true, idx + n.itemIndex(i)
+		}
+		if len(n.children) == 0 {
+			return cs, false, idx + i
+		}
+		idx += n.partialSize(i)
+		n = n.children[i]
+	}
+}
+
+// cursorStackForIndex is like node.cursorStackForIndex.
+func (n *int64Node) cursorStackForIndex(i int, cs []int64Cursor) []int64Cursor {
+	for len(n.children) > 0 {
+		j := 0
+		for ; i >= n.children[j].size; j++ {
+			i -= n.children[j].size
+			if i == 0 {
+				return append(cs, int64Cursor{n, j})
+			}
+			i--
+		}
+		cs = append(cs, int64Cursor{n, j})
+		n = n.children[j]
+	}
+	return append(cs, int64Cursor{n, i})
+}
+
+// Clone clones the tree lazily. See BTree.Clone.
+func (t *Int64Tree) Clone() *Int64Tree {
+	cow1, cow2 := *t.cow, *t.cow
+	out := *t
+	t.cow = &cow1
+	out.cow = &cow2
+	return &out
+}
+
+func (t *Int64Tree) maxItems() int {
+	return t.degree*2 - 1
+}
+
+func (t *Int64Tree) minItems() int {
+	return t.degree - 1
+}
+
+// Set sets the given key to the given value in the tree. See BTree.Set.
+func (t *Int64Tree) Set(k int64, v Value) (old Value, present bool) {
+	old, present, _ = t.set(k, v, false)
+	return old, present
+}
+
+// SetWithIndex is like Set, but also returns the index of k. See BTree.SetWithIndex.
+func (t *Int64Tree) SetWithIndex(k int64, v Value) (old Value, present bool, index int) {
+	return t.set(k, v, true)
+}
+
+func (t *Int64Tree) set(k int64, v Value, withIndex bool) (old Value, present bool, idx int) {
+	if t.root == nil {
+		t.root = &int64Node{keys: []int64{k}, values: []Value{v}, size: 1, cow: t.cow}
+		return old, false, 0
+	}
+	t.root = t.root.mutableFor(t.cow)
+	if len(t.root.keys) >= t.maxItems() {
+		sz := t.root.size
+		k2, v2, second := t.root.split(t.maxItems() / 2)
+		oldroot := t.root
+		t.root = &int64Node{
+			keys:     []int64{k2},
+			values:   []Value{v2},
+			children: []*int64Node{oldroot, second},
+			size:     sz,
+			cow:      t.cow,
+		}
+	}
+	return t.root.insert(k, v, t.maxItems(), withIndex)
+}
+
+// Delete removes the item with the given key, returning its value. The second
+// return value reports whether the key was found.
+func (t *Int64Tree) Delete(k int64) (Value, bool) {
+	_, v, removed := t.deleteItem(k, removeItem)
+	return v, removed
+}
+
+// DeleteMin removes the smallest item in the tree and returns its key and value.
+// If the tree is empty, it returns zero values.
+func (t *Int64Tree) DeleteMin() (int64, Value) {
+	var zero int64
+	k, v, _ := t.deleteItem(zero, removeMin)
+	return k, v
+}
+
+// DeleteMax removes the largest item in the tree and returns its key and value.
+// If the tree is empty, it returns zero values.
+func (t *Int64Tree) DeleteMax() (int64, Value) {
+	var zero int64
+	k, v, _ := t.deleteItem(zero, removeMax)
+	return k, v
+}
+
+func (t *Int64Tree) deleteItem(k int64, typ toRemove) (int64, Value, bool) {
+	if t.root == nil || len(t.root.keys) == 0 {
+		var zero int64
+		return zero, nil, false
+	}
+	t.root = t.root.mutableFor(t.cow)
+	outk, outv, removed := t.root.remove(k, t.minItems(), typ)
+	if len(t.root.keys) == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+	if !removed {
+		var zero int64
+		return zero, nil, false
+	}
+	return outk, outv, true
+}
+
+// Get returns the value for the given key in the tree, or nil if the key is not
+// in the tree.
+func (t *Int64Tree) Get(k int64) Value {
+	if t.root == nil {
+		return nil
+	}
+	v, _, _ := t.root.get(k, false)
+	return v
+}
+
+// GetWithIndex returns the value and index for the given key in the tree, or
+// nil and -1 if the key is not in the tree.
+func (t *Int64Tree) GetWithIndex(k int64) (Value, int) {
+	if t.root == nil {
+		return nil, -1
+	}
+	v, _, idx := t.root.get(k, true)
+	return v, idx
+}
+
+// Has reports whether the given key is in the tree.
+func (t *Int64Tree) Has(k int64) bool {
+	if t.root == nil {
+		return false
+	}
+	_, ok, _ := t.root.get(k, false)
+	return ok
+}
+
+// At returns the key and value at index i. The minimum item has index 0.
+// If i is outside the range [0, t.Len()), At panics.
+func (t *Int64Tree) At(i int) (int64, Value) {
+	if i < 0 || i >= t.Len() {
+		panic("btree: index out of range")
+	}
+	cs := t.root.cursorStackForIndex(i, nil)
+	top := cs[len(cs)-1]
+	return top.node.keys[top.index], top.node.values[top.index]
+}
+
+// Min returns the smallest key in the tree and its value. If the tree is empty,
+// it returns zero values.
+func (t *Int64Tree) Min() (int64, Value) {
+	if t.Len() == 0 {
+		var zero int64
+		return zero, nil
+	}
+	n := t.root
+	for len(n.children) > 0 {
+		n = n.children[0]
+	}
+	return n.keys[0], n.values[0]
+}
+
+// Max returns the largest key in the tree and its value. If the tree is empty,
+// it returns zero values.
+func (t *Int64Tree) Max() (int64, Value) {
+	if t.Len() == 0 {
+		var zero int64
+		return zero, nil
+	}
+	n := t.root
+	for len(n.children) > 0 {
+		n = n.children[len(n.children)-1]
+	}
+	return n.keys[len(n.keys)-1], n.values[len(n.values)-1]
+}
+
+// Len returns the number of items currently in the tree.
+func (t *Int64Tree) Len() int {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.size
+}
+
+// Before returns an iterator positioned just before k. See BTree.Before.
+func (t *Int64Tree) Before(k int64) *Int64Iterator {
+	if t.root == nil {
+		return &Int64Iterator{}
+	}
+	cs, found, idx := t.root.cursorStackForKey(k, nil)
+	top := cs[len(cs)-1]
+	stay := found || top.index < len(top.node.keys)
+	if !stay {
+		idx--
+	}
+	return &Int64Iterator{cursors: cs, stay: stay, Index: idx}
+}
+
+// After returns an iterator positioned just after k. See BTree.After.
+func (t *Int64Tree) After(k int64) *Int64Iterator {
+	if t.root == nil {
+		return &Int64Iterator{}
+	}
+	cs, found, idx := t.root.cursorStackForKey(k, nil)
+	return &Int64Iterator{cursors: cs, stay: found, descending: true, Index: idx}
+}
+
+// BeforeIndex returns an iterator positioned just before the item with the
+// given index. See BTree.BeforeIndex.
+func (t *Int64Tree) BeforeIndex(i int) *Int64Iterator {
+	return t.indexIterator(i, false)
+}
+
+// AfterIndex returns an iterator positioned just after the item with the given
+// index. See BTree.AfterIndex.
+func (t *Int64Tree) AfterIndex(i int) *Int64Iterator {
+	return t.indexIterator(i, true)
+}
+
+func (t *Int64Tree) indexIterator(i int, descending bool) *Int64Iterator {
+	if i < 0 || i > t.Len() {
+		panic("btree: index out of range")
+	}
+	if i == t.Len() {
+		return &Int64Iterator{}
+	}
+	cs := t.root.cursorStackForIndex(i, nil)
+	return &Int64Iterator{cursors: cs, stay: true, descending: descending, Index: i}
+}
+
+type int64Cursor struct {
+	node  *int64Node
+	index int
+}
+
+// Int64Iterator is an Iterator for Int64Tree.
+type Int64Iterator struct {
+	Key   int64
+	Value Value
+	// Index is the position of the item in the tree viewed as a sequence.
+	// The minimum item has index zero.
+	Index int
+
+	cursors    []int64Cursor
+	stay       bool
+	descending bool
+}
+
+// Next advances the iterator to the next item in the tree. See Iterator.Next.
+func (it *Int64Iterator) Next() bool {
+	var more bool
+	switch {
+	case len(it.cursors) == 0:
+		more = false
+	case it.stay:
+		it.stay = false
+		more = true
+	case it.descending:
+		more = it.dec()
+	default:
+		more = it.inc()
+	}
+	if !more {
+		return false
+	}
+	top := it.cursors[len(it.cursors)-1]
+	it.Key = top.node.keys[top.index]
+	it.Value = top.node.values[top.index]
+	return true
+}
+
+// inc is like Iterator.inc.
+func (it *Int64Iterator) inc() bool {
+	it.Index++
+	it.cursors[len(it.cursors)-1].index++
+	top := it.cursors[len(it.cursors)-1]
+	for len(top.node.children) > 0 {
+		top = int64Cursor{top.node.children[top.index], 0}
+		it.cursors = append(it.cursors, top)
+	}
+	for top.index >= len(top.node.keys) {
+		it.cursors = it.cursors[:len(it.cursors)-1]
+		if len(it.cursors) == 0 {
+			return false
+		}
+		top = it.cursors[len(it.cursors)-1]
+	}
+	return true
+}
+
+// dec is like Iterator.dec.
+func (it *Int64Iterator) dec() bool {
+	it.Index--
+	top := it.cursors[len(it.cursors)-1]
+	for len(top.node.children) > 0 {
+		c := top.node.children[top.index]
+		top = int64Cursor{c, len(c.keys)}
+		it.cursors = append(it.cursors, top)
+	}
+	it.cursors[len(it.cursors)-1].index--
+	top = it.cursors[len(it.cursors)-1]
+	for top.index < 0 {
+		it.cursors = it.cursors[:len(it.cursors)-1]
+		if len(it.cursors) == 0 {
+			return false
+		}
+		it.cursors[len(it.cursors)-1].index--
+		top = it.cursors[len(it.cursors)-1]
+	}
+	return true
+}
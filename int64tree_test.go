@@ -0,0 +1,131 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+// This file is the source for the tests of the other specialized trees, which
+// gen_typed.go generates from it as it does the trees from int64tree.go. Keys
+// are converted to and from the int keys of the BTree that the tests compare
+// with by int64Key and int64KeyInt, which typedkeys_test.go defines for each
+// key type in an order-preserving way.
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func int64Items(it *Int64Iterator) []itemWithIndex {
+	var out []itemWithIndex
+	for it.Next() {
+		out = append(out, itemWithIndex{int64KeyInt(it.Key), it.Value, it.Index})
+	}
+	return out
+}
+
+// checkInt64Tree checks that tr has the same contents as the BTree bt, whose
+// keys are ints.
+func checkInt64Tree(t *testing.T, tr *Int64Tree, bt *BTree) {
+	t.Helper()
+	if tr.Len() != bt.Len() {
+		t.Fatalf("Len: got %d, want %d", tr.Len(), bt.Len())
+	}
+	want := all(bt.BeforeIndex(0))
+	if got := int64Items(tr.BeforeIndex(0)); !cmp.Equal(got, want) {
+		t.Fatalf("BeforeIndex(0):\ngot  %v\nwant %v", got, want)
+	}
+	if bt.Len() == 0 {
+		return
+	}
+	gk, gv := tr.Min()
+	wk, wv := bt.Min()
+	if int64KeyInt(gk) != wk || gv != wv {
+		t.Fatalf("Min: got %v, %v; want %v, %v", gk, gv, wk, wv)
+	}
+	gk, gv = tr.Max()
+	wk, wv = bt.Max()
+	if int64KeyInt(gk) != wk || gv != wv {
+		t.Fatalf("Max: got %v, %v; want %v, %v", gk, gv, wk, wv)
+	}
+	for j := 0; j < 20; j++ {
+		i := rand.Intn(bt.Len())
+		if gk, gv := tr.At(i); int64KeyInt(gk) != want[i].Key || gv != want[i].Value {
+			t.Fatalf("At(%d) = %v, %v; want %v", i, gk, gv, want[i])
+		}
+		k := rand.Intn(bt.Len() * 2)
+		gv, gi := tr.GetWithIndex(int64Key(k))
+		wv, wi := bt.GetWithIndex(k)
+		if gv != wv || gi != wi || tr.Has(int64Key(k)) != bt.Has(k) || tr.Get(int64Key(k)) != wv {
+			t.Fatalf("GetWithIndex(%d) = %v, %d; want %v, %d", k, gv, gi, wv, wi)
+		}
+		if got, want := int64Items(tr.Before(int64Key(k))), all(bt.Before(k)); !cmp.Equal(got, want) {
+			t.Fatalf("Before(%d):\ngot  %v\nwant %v", k, got, want)
+		}
+		if got, want := int64Items(tr.After(int64Key(k))), all(bt.After(k)); !cmp.Equal(got, want) {
+			t.Fatalf("After(%d):\ngot  %v\nwant %v", k, got, want)
+		}
+		if got, want := int64Items(tr.AfterIndex(i)), all(bt.AfterIndex(i)); !cmp.Equal(got, want) {
+			t.Fatalf("AfterIndex(%d):\ngot  %v\nwant %v", i, got, want)
+		}
+	}
+}
+
+func TestInt64Tree(t *testing.T) {
+	const size = 2000
+	for _, degree := range []int{2, 3, 16} {
+		tr, bt := NewInt64Tree(degree), New(degree, less)
+		for _, m := range perm(size) {
+			_, gp, gi := tr.SetWithIndex(int64Key(m.Key.(int)), m.Value)
+			_, wp, wi := bt.SetWithIndex(m.Key, m.Value)
+			if gp != wp || gi != wi {
+				t.Fatalf("SetWithIndex(%v) = %t, %d; want %t, %d", m.Key, gp, gi, wp, wi)
+			}
+		}
+		checkInt64Tree(t, tr, bt)
+		clone, bclone := tr.Clone(), bt.Clone()
+		for i := 0; i < 5*size; i++ {
+			k := rand.Intn(size)
+			switch rand.Intn(4) {
+			case 0, 1:
+				gv, gp := tr.Set(int64Key(k), -k)
+				wv, wp := bt.Set(k, -k)
+				if gv != wv || gp != wp {
+					t.Fatalf("Set(%d) = %v, %t; want %v, %t", k, gv, gp, wv, wp)
+				}
+			case 2:
+				gv, gok := tr.Delete(int64Key(k))
+				wv, wok := bt.Delete(k)
+				if gv != wv || gok != wok {
+					t.Fatalf("Delete(%d) = %v, %t; want %v, %t", k, gv, gok, wv, wok)
+				}
+			case 3:
+				gk, gv := tr.DeleteMin()
+				wk, wv := bt.DeleteMin()
+				if int64KeyInt(gk) != wk || gv != wv {
+					t.Fatalf("DeleteMin = %v, %v; want %v, %v", gk, gv, wk, wv)
+				}
+			}
+		}
+		checkInt64Tree(t, tr, bt)
+		checkInt64Tree(t, clone, bclone)
+		for bt.Len() > 0 {
+			gk, _ := tr.DeleteMax()
+			if wk, _ := bt.DeleteMax(); int64KeyInt(gk) != wk {
+				t.Fatalf("DeleteMax = %v, want %v", gk, wk)
+			}
+		}
+		checkInt64Tree(t, tr, bt)
+	}
+}
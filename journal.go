@@ -0,0 +1,282 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A Journal records every mutation of a BTree to an io.Writer, so that the tree
+// can be rebuilt after a crash by replaying the journal.
+//
+// Each mutation is written as a single record before it is applied to the tree.
+// A record consists of an operation byte, the uvarint length of the payload, the
+// payload, and a big-endian CRC-32 (IEEE) of the operation byte and payload. The
+// payload of a Set record is the length-prefixed key followed by the
+// length-prefixed value; that of a Delete record is the length-prefixed key;
+// DeleteMin and DeleteMax records have empty payloads. A payload may be at
+// most maxJournalPayload (1 GiB) long.
+//
+// A Journal does not buffer or sync its writer. Callers that want durability
+// should arrange for that themselves, for example by passing an *os.File and
+// calling its Sync method.
+type Journal struct {
+	t      *BTree
+	w      io.Writer
+	kc, vc Codec
+	buf    []byte // scratch space for payloads
+	rec    []byte // scratch space for records
+}
+
+// Journal record operations.
+const (
+	journalSet byte = iota + 1
+	journalDelete
+	journalDeleteMin
+	journalDeleteMax
+)
+
+// maxJournalPayload bounds the length of a record's payload, so that Replay can
+// tell a corrupt length from a long record.
+const maxJournalPayload = 1 << 30
+
+// ErrCorruptJournal is returned by Replay when a record other than the last
+// fails its checksum or cannot be decoded.
+var ErrCorruptJournal = errors.New("btree: corrupt journal")
+
+// NewJournal returns a Journal that writes the mutations of t to w, using
+// keyCodec and valueCodec to encode keys and values.
+func NewJournal(t *BTree, w io.Writer, keyCodec, valueCodec Codec) *Journal {
+	return &Journal{t: t, w: w, kc: keyCodec, vc: valueCodec}
+}
+
+// Tree returns the tree underlying j. Reading from the tree directly is fine, but
+// changes made to it other than through j will not be journaled.
+func (j *Journal) Tree() *BTree {
+	return j.t
+}
+
+// Set records the operation and then calls Set on the underlying tree.
+// If the record cannot be written, the tree is not modified.
+func (j *Journal) Set(k Key, v Value) (old Value, present bool, err error) {
+	b, err := appendField(j.buf[:0], j.kc, k)
+	if err != nil {
+		return nil, false, err
+	}
+	if b, err = appendField(b, j.vc, v); err != nil {
+		return nil, false, err
+	}
+	if err := j.write(journalSet, b); err != nil {
+		return nil, false, err
+	}
+	old, present = j.t.Set(k, v)
+	return old, present, nil
+}
+
+// Delete records the operation and then calls Delete on the underlying tree.
+// If the record cannot be written, the tree is not modified.
+func (j *Journal) Delete(k Key) (Value, bool, error) {
+	b, err := appendField(j.buf[:0], j.kc, k)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := j.write(journalDelete, b); err != nil {
+		return nil, false, err
+	}
+	v, ok := j.t.Delete(k)
+	return v, ok, nil
+}
+
+// DeleteMin records the operation and then calls DeleteMin on the underlying tree.
+// If the record cannot be written, the tree is not modified. Nothing is recorded
+// if the tree is empty.
+func (j *Journal) DeleteMin() (Key, Value, error) {
+	if j.t.Len() == 0 {
+		return nil, nil, nil
+	}
+	if err := j.write(journalDeleteMin, nil); err != nil {
+		return nil, nil, err
+	}
+	k, v := j.t.DeleteMin()
+	return k, v, nil
+}
+
+// DeleteMax records the operation and then calls DeleteMax on the underlying tree.
+// If the record cannot be written, the tree is not modified. Nothing is recorded
+// if the tree is empty.
+func (j *Journal) DeleteMax() (Key, Value, error) {
+	if j.t.Len() == 0 {
+		return nil, nil, nil
+	}
+	if err := j.write(journalDeleteMax, nil); err != nil {
+		return nil, nil, err
+	}
+	k, v := j.t.DeleteMax()
+	return k, v, nil
+}
+
+// write writes a record with the given operation and payload to j's writer.
+func (j *Journal) write(op byte, payload []byte) error {
+	j.buf = payload[:0] // reuse the payload's storage for the next operation
+	if len(payload) > maxJournalPayload {
+		return fmt.Errorf("btree: journal record of %d bytes is too long", len(payload))
+	}
+	rec := append(j.rec[:0], op)
+	rec = appendUvarint(rec, uint64(len(payload)))
+	rec = append(rec, payload...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], recordCRC(op, payload))
+	rec = append(rec, crc[:]...)
+	j.rec = rec
+	_, err := j.w.Write(rec)
+	return err
+}
+
+// recordCRC returns the checksum of a record with the given operation and payload.
+func recordCRC(op byte, payload []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE([]byte{op}), crc32.IEEETable, payload)
+}
+
+// Replay reads records written by a Journal from r and applies them to the
+// underlying tree, without journaling them again.
+//
+// If the last record is incomplete, as it may be if the process writing the
+// journal crashed, it is ignored. Any other malformed record causes Replay to
+// return an error wrapping ErrCorruptJournal; records before it will have been
+// applied. That includes a record whose length is too large, even if it runs
+// to the end of the input: Replay takes the record to be the incomplete last
+// one only if no whole record follows its length.
+func (j *Journal) Replay(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var payload bytes.Buffer
+	for n := 0; ; n++ {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		plen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return truncated(err)
+		}
+		if plen > maxJournalPayload {
+			return fmt.Errorf("%w: record %d: length %d is too large", ErrCorruptJournal, n, plen)
+		}
+		// Copy rather than allocating plen bytes up front: if plen is garbage, we
+		// will hit EOF before using much memory.
+		payload.Reset()
+		if _, err := io.CopyN(&payload, br, int64(plen)); err != nil {
+			if err := truncated(err); err != nil {
+				return err
+			}
+			// The record runs past the end of the input. If it was torn, nothing
+			// follows it; if its length is corrupt, the bytes it claims hold
+			// later records.
+			if holdsRecord(payload.Bytes()) {
+				return fmt.Errorf("%w: record %d: length %d runs over later records", ErrCorruptJournal, n, plen)
+			}
+			return nil
+		}
+		var crcbuf [4]byte
+		if _, err := io.ReadFull(br, crcbuf[:]); err != nil {
+			return truncated(err)
+		}
+		if recordCRC(op, payload.Bytes()) != binary.BigEndian.Uint32(crcbuf[:]) {
+			if _, err := br.Peek(1); err == io.EOF {
+				// A torn write of the last record.
+				return nil
+			}
+			return fmt.Errorf("%w: record %d: bad checksum", ErrCorruptJournal, n)
+		}
+		if err := j.apply(op, payload.Bytes()); err != nil {
+			return fmt.Errorf("%w: record %d: %v", ErrCorruptJournal, n, err)
+		}
+	}
+}
+
+// holdsRecord reports whether a whole record with a good checksum starts
+// anywhere in b.
+func holdsRecord(b []byte) bool {
+	for i := range b {
+		if op := b[i]; op < journalSet || op > journalDeleteMax {
+			continue
+		}
+		plen, k := binary.Uvarint(b[i+1:])
+		if k <= 0 || plen > uint64(len(b)) {
+			continue
+		}
+		start := i + 1 + k
+		end := start + int(plen)
+		if end+4 > len(b) {
+			continue
+		}
+		if recordCRC(b[i], b[start:end]) == binary.BigEndian.Uint32(b[end:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncated converts an error encountered while reading a record into
+// the return value of Replay.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
+
+// apply applies a single record to the tree.
+func (j *Journal) apply(op byte, payload []byte) error {
+	switch op {
+	case journalSet:
+		k, rest, err := decodeField(payload, j.kc)
+		if err != nil {
+			return err
+		}
+		v, rest, err := decodeField(rest, j.vc)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return errors.New("extra bytes in set record")
+		}
+		j.t.Set(k, v)
+	case journalDelete:
+		k, rest, err := decodeField(payload, j.kc)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return errors.New("extra bytes in delete record")
+		}
+		j.t.Delete(k)
+	case journalDeleteMin:
+		j.t.DeleteMin()
+	case journalDeleteMax:
+		j.t.DeleteMax()
+	default:
+		return fmt.Errorf("unknown operation %d", op)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJournalReplay(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJournal(New(3, less), &buf, IntCodec, StringCodec)
+	var ends []int // offset of the end of each record
+	for i := 0; i < 500; i++ {
+		var err error
+		switch r := rand.Intn(10); {
+		case r < 6:
+			_, _, err = j.Set(rand.Intn(200), "v")
+		case r < 8:
+			_, _, err = j.Delete(rand.Intn(200))
+		case r < 9:
+			_, _, err = j.DeleteMin()
+		default:
+			_, _, err = j.DeleteMax()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ends) == 0 || ends[len(ends)-1] != buf.Len() {
+			ends = append(ends, buf.Len())
+		}
+	}
+	want := all(j.Tree().BeforeIndex(0))
+
+	j2 := NewJournal(New(5, less), nil, IntCodec, StringCodec)
+	if err := j2.Replay(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := all(j2.Tree().BeforeIndex(0)); !cmp.Equal(got, want) {
+		t.Fatalf("got %v\nwant %v", got, want)
+	}
+
+	// A truncated last record is ignored.
+	data := buf.Bytes()
+	last := ends[len(ends)-2]
+	for n := last; n < len(data); n++ {
+		j3 := NewJournal(New(2, less), nil, IntCodec, StringCodec)
+		if err := j3.Replay(bytes.NewReader(data[:n])); err != nil {
+			t.Fatalf("truncated to %d: %v", n, err)
+		}
+		if got, want := j3.Tree().Len(), j2.Tree().Len(); got != want && got != want+1 && got != want-1 {
+			t.Fatalf("truncated to %d: got len %d, want within 1 of %d", n, got, want)
+		}
+	}
+
+	// A corrupt record in the middle is an error.
+	bad := append([]byte(nil), data...)
+	bad[ends[10]-1] ^= 0xff // flip bits in the checksum of record 11
+	j4 := NewJournal(New(2, less), nil, IntCodec, StringCodec)
+	if err := j4.Replay(bytes.NewReader(bad)); !errors.Is(err, ErrCorruptJournal) {
+		t.Fatalf("got %v, want ErrCorruptJournal", err)
+	}
+
+	// So is a record in the middle whose length runs past the end of the
+	// input, over later records, or is too large to be real. Record 11 starts
+	// at ends[9], and its payload is short enough that its length is one byte.
+	for _, length := range [][]byte{{0x7f}, {0xff, 0xff, 0xff, 0xff, 0x7f}} {
+		bad := append([]byte(nil), data[:ends[9]+1]...)
+		bad = append(bad, length...)
+		bad = append(bad, data[ends[9]+2:ends[14]]...)
+		j5 := NewJournal(New(2, less), nil, IntCodec, StringCodec)
+		if err := j5.Replay(bytes.NewReader(bad)); !errors.Is(err, ErrCorruptJournal) {
+			t.Errorf("length %x: got %v, want ErrCorruptJournal", length, err)
+		}
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestJournalWriteError(t *testing.T) {
+	j := NewJournal(New(2, less), errWriter{}, IntCodec, IntCodec)
+	if _, _, err := j.Set(1, 1); err == nil {
+		t.Fatal("got nil, want error")
+	}
+	if j.Tree().Len() != 0 {
+		t.Error("tree was modified despite write error")
+	}
+	if _, _, err := j.Set("x", 1); err == nil {
+		t.Fatal("got nil for bad key type, want error")
+	}
+}
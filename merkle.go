@@ -0,0 +1,293 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// A tree with hashing enabled keeps a SHA-256 hash in every node, computed
+// from the node's items and the hashes of its children. Since writes copy or
+// modify only the nodes on the path to the change, only those nodes need to be
+// rehashed, which is done at the end of each write.
+//
+// A node's hash is the SHA-256 of
+//
+//	the number of items, as a uvarint
+//	a byte: 0 for a leaf, 1 for an internal node, 2 for an internal B+ tree node
+//	for each item i: the hash of child i (internal nodes only), then the
+//	    length-prefixed encodings of the item's key and value (only the
+//	    key, for the separators of an internal B+ tree node)
+//	the hash of the last child (internal nodes only)
+//
+// The hash depends on the shape of the tree as well as its contents, so two
+// trees with equal root hashes hold the same items, but trees holding the same
+// items may not have equal root hashes unless they were built by the same
+// sequence of operations with the same degree.
+
+// hasher computes node hashes.
+type hasher struct {
+	kc, vc Codec
+	buf    []byte
+}
+
+// EnableHashing makes t maintain a Merkle hash of its contents, available from
+// RootHash. Keys and values are hashed in the encodings given by keyCodec and
+// valueCodec; once hashing is enabled, writing an item that cannot be encoded
+// panics.
+//
+// EnableHashing computes the hashes of all nodes in t, including those it shares
+// with clones, so it must not be called while a clone of t is in use. Clones of
+// t made afterwards also maintain hashes.
+func (t *BTree) EnableHashing(keyCodec, valueCodec Codec) {
+	t.hasher = &hasher{kc: keyCodec, vc: valueCodec}
+	t.hasher.hash(t.root)
+}
+
+// RootHash returns the hash of t's contents. It panics if hashing was not
+// enabled with EnableHashing.
+func (t *BTree) RootHash() [sha256.Size]byte {
+	if t.hasher == nil {
+		panic("btree: RootHash called without EnableHashing")
+	}
+	if t.root == nil {
+		return emptyHash
+	}
+	return *t.root.hash
+}
+
+// emptyHash is the hash of an empty tree: that of a leaf with no items.
+var emptyHash = sha256.Sum256([]byte{0, 0})
+
+// hash computes the hashes of n and all nodes beneath it whose hashes are
+// missing, along with their sums (see rangeDigest). A node is missing its hash
+// only if it was modified by the current write, in which case it belongs to
+// the writing tree and it's safe to store into.
+func (h *hasher) hash(n *node) {
+	if n == nil || n.hash != nil {
+		return
+	}
+	for _, c := range n.children {
+		h.hash(c)
+	}
+	d := sha256.New()
+	// The separators of a B+ tree have no values.
+	separators := len(n.children) > 0 && n.cow.bplus
+	h.buf = appendUvarint(h.buf[:0], uint64(len(n.items)))
+	if separators {
+		h.buf = append(h.buf, 2)
+	} else {
+		h.buf = append(h.buf, boolByte(len(n.children) > 0))
+	}
+	d.Write(h.buf)
+	var sum rangeDigest
+	for i, m := range n.items {
+		if len(n.children) > 0 {
+			d.Write(n.children[i].hash[:])
+			sum.add(n.children[i].sum)
+		}
+		var err error
+		if h.buf, err = appendField(h.buf[:0], h.kc, m.key); err == nil && !separators {
+			h.buf, err = appendField(h.buf, h.vc, m.value)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("btree: hashing item with key %v: %v", m.key, err))
+		}
+		d.Write(h.buf)
+		if !separators {
+			sum.addItem(h.buf)
+		}
+	}
+	if len(n.children) > 0 {
+		last := n.children[len(n.children)-1]
+		d.Write(last.hash[:])
+		sum.add(last.sum)
+	}
+	var hash [sha256.Size]byte
+	d.Sum(hash[:0])
+	n.hash = &hash
+	n.sum = &sum
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// A Proof shows that a key is or is not in a tree with a given root hash.
+// It consists of the nodes on the path from the root to the key, or to the leaf
+// where the key would be.
+type Proof struct {
+	Key     Key
+	Value   Value // the key's value, if Present
+	Present bool
+	Path    []ProofNode
+}
+
+// A ProofNode is the content of one node in a Proof: the encoded keys and
+// values of its items, and the hashes of its children.
+type ProofNode struct {
+	Keys     [][]byte
+	Values   [][]byte
+	Children [][sha256.Size]byte
+}
+
+// Prove returns a proof that k is or is not in t, which can be checked against
+// t.RootHash with Proof.Verify. It panics if hashing was not enabled with
+// EnableHashing. Proofs are not supported for B+ trees.
+func (t *BTree) Prove(k Key) (*Proof, error) {
+	if t.hasher == nil {
+		panic("btree: Prove called without EnableHashing")
+	}
+	if t.cow.bplus {
+		return nil, errBPlusUnsupported("Prove")
+	}
+	p := &Proof{Key: k}
+	n := t.root
+	if n == nil {
+		p.Path = []ProofNode{{}}
+		return p, nil
+	}
+	for {
+		var pn ProofNode
+		for _, m := range n.items {
+			kb, err := t.hasher.kc.Append(nil, m.key)
+			if err != nil {
+				return nil, err
+			}
+			vb, err := t.hasher.vc.Append(nil, m.value)
+			if err != nil {
+				return nil, err
+			}
+			pn.Keys = append(pn.Keys, kb)
+			pn.Values = append(pn.Values, vb)
+		}
+		for _, c := range n.children {
+			pn.Children = append(pn.Children, *c.hash)
+		}
+		p.Path = append(p.Path, pn)
+		i, found := n.items.find(k, t.less)
+		if found {
+			p.Present = true
+			p.Value = n.items[i].value
+			return p, nil
+		}
+		if len(n.children) == 0 {
+			return p, nil
+		}
+		n = n.children[i]
+	}
+}
+
+// ErrBadProof is returned by Proof.Verify when the proof does not establish its claim.
+var ErrBadProof = errors.New("btree: invalid proof")
+
+// Verify checks that p proves its claim about p.Key against the given root
+// hash. If p.Present, the claim is that the tree maps p.Key to p.Value;
+// otherwise it is that p.Key is not in the tree. The less function and codecs
+// must be those of the tree that produced p.
+func (p *Proof) Verify(root [sha256.Size]byte, less func(interface{}, interface{}) bool, keyCodec, valueCodec Codec) error {
+	if len(p.Path) == 0 {
+		return fmt.Errorf("%w: empty path", ErrBadProof)
+	}
+	// Follow the path down, checking that it is the one a search for p.Key would take.
+	idxs := make([]int, len(p.Path))
+	for d, pn := range p.Path {
+		if len(pn.Values) != len(pn.Keys) || (len(pn.Children) != 0 && len(pn.Children) != len(pn.Keys)+1) {
+			return fmt.Errorf("%w: malformed node at depth %d", ErrBadProof, d)
+		}
+		keys := make(items, len(pn.Keys))
+		for i, kb := range pn.Keys {
+			k, err := keyCodec.Decode(kb)
+			if err != nil {
+				return fmt.Errorf("%w: depth %d: %v", ErrBadProof, d, err)
+			}
+			keys[i].key = k
+			if i > 0 && !less(keys[i-1].key, k) {
+				return fmt.Errorf("%w: keys out of order at depth %d", ErrBadProof, d)
+			}
+		}
+		i, found := keys.find(p.Key, less)
+		idxs[d] = i
+		last := d == len(p.Path)-1
+		switch {
+		case !last && len(pn.Children) == 0:
+			return fmt.Errorf("%w: path continues below a leaf", ErrBadProof)
+		case found && !last:
+			return fmt.Errorf("%w: key found above the end of the path", ErrBadProof)
+		case found:
+			if !p.Present {
+				return fmt.Errorf("%w: key is present", ErrBadProof)
+			}
+			vb, err := valueCodec.Append(nil, p.Value)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(vb, pn.Values[i]) {
+				return fmt.Errorf("%w: value differs", ErrBadProof)
+			}
+		case last:
+			if p.Present {
+				return fmt.Errorf("%w: key is absent", ErrBadProof)
+			}
+			if len(pn.Children) != 0 {
+				return fmt.Errorf("%w: path ends above a leaf", ErrBadProof)
+			}
+		}
+	}
+	// Hash back up the path.
+	var h [sha256.Size]byte
+	for d := len(p.Path) - 1; d >= 0; d-- {
+		pn := p.Path[d]
+		if d < len(p.Path)-1 && pn.Children[idxs[d]] != h {
+			return fmt.Errorf("%w: child hash mismatch at depth %d", ErrBadProof, d)
+		}
+		h = pn.hash()
+	}
+	if h != root {
+		return fmt.Errorf("%w: root hash mismatch", ErrBadProof)
+	}
+	return nil
+}
+
+// hash computes the hash of the node, as hasher.hash does.
+func (pn ProofNode) hash() [sha256.Size]byte {
+	d := sha256.New()
+	d.Write(append(appendUvarint(nil, uint64(len(pn.Keys))), boolByte(len(pn.Children) > 0)))
+	for i, kb := range pn.Keys {
+		if len(pn.Children) > 0 {
+			d.Write(pn.Children[i][:])
+		}
+		writeField(d, kb)
+		writeField(d, pn.Values[i])
+	}
+	if len(pn.Children) > 0 {
+		d.Write(pn.Children[len(pn.Children)-1][:])
+	}
+	var sum [sha256.Size]byte
+	d.Sum(sum[:0])
+	return sum
+}
+
+func writeField(h hash.Hash, b []byte) {
+	h.Write(appendUvarint(nil, uint64(len(b))))
+	h.Write(b)
+}
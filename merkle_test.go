@@ -0,0 +1,195 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// recomputeHashes discards all of n's hashes and computes them again from scratch.
+func recomputeHashes(h *hasher, n *node) {
+	if n == nil {
+		return
+	}
+	var clear func(*node)
+	clear = func(n *node) {
+		n.hash, n.sum = nil, nil
+		for _, c := range n.children {
+			clear(c)
+		}
+	}
+	clear(n)
+	h.hash(n)
+}
+
+func TestRootHash(t *testing.T) {
+	tr := New(2, less)
+	tr.EnableHashing(IntCodec, IntCodec)
+	if tr.RootHash() != emptyHash {
+		t.Fatal("empty tree has wrong hash")
+	}
+	for _, m := range perm(200) {
+		tr.Set(m.Key, m.Value)
+	}
+	clone := tr.Clone()
+	before := tr.RootHash()
+	for i := 0; i < 1000; i++ {
+		k := rand.Intn(300)
+		switch rand.Intn(3) {
+		case 0:
+			tr.Delete(k)
+		case 1:
+			tr.DeleteMin()
+		default:
+			tr.Set(k, -k)
+		}
+		got := tr.RootHash()
+		check := tr.Clone()
+		check.hasher = &hasher{kc: IntCodec, vc: IntCodec}
+		// Recompute in a private copy, so tr's shared nodes aren't disturbed.
+		check.root = deepCopy(check.root)
+		recomputeHashes(check.hasher, check.root)
+		if check.root != nil && got != *check.root.hash {
+			t.Fatalf("after op %d: incremental hash differs from full recomputation", i)
+		}
+	}
+	if clone.RootHash() != before {
+		t.Error("writes to a tree changed its clone's hash")
+	}
+
+	// Changing a value changes the hash.
+	a := New(3, less)
+	a.EnableHashing(IntCodec, IntCodec)
+	for _, m := range perm(50) {
+		a.Set(m.Key, m.Value)
+	}
+	c := a.Clone()
+	c.Set(7, 8)
+	if c.RootHash() == a.RootHash() {
+		t.Error("changing a value did not change the hash")
+	}
+}
+
+// TestRootHashConcurrentClones writes a hashed tree and its clone at the same
+// time. Run it with -race: each tree must hash with its own scratch space.
+func TestRootHashConcurrentClones(t *testing.T) {
+	tr := New(3, less)
+	tr.EnableHashing(IntCodec, IntCodec)
+	for _, m := range perm(200) {
+		tr.Set(m.Key, m.Value)
+	}
+	trees := []*BTree{tr, tr.Clone()}
+	var wg sync.WaitGroup
+	for i, x := range trees {
+		wg.Add(1)
+		go func(i int, x *BTree) {
+			defer wg.Done()
+			for k := 0; k < 500; k++ {
+				if k%3 == 0 {
+					x.Delete(k)
+				} else {
+					x.Set(k, k*(i+2))
+				}
+			}
+		}(i, x)
+	}
+	wg.Wait()
+	for i, x := range trees {
+		check := x.Clone()
+		check.root = deepCopy(check.root)
+		recomputeHashes(&hasher{kc: IntCodec, vc: IntCodec}, check.root)
+		if x.RootHash() != *check.root.hash {
+			t.Errorf("tree %d: incremental hash differs from full recomputation", i)
+		}
+	}
+	if trees[0].RootHash() == trees[1].RootHash() {
+		t.Error("trees with different values have the same hash")
+	}
+}
+
+func deepCopy(n *node) *node {
+	if n == nil {
+		return nil
+	}
+	out := &node{size: n.size, cow: n.cow}
+	out.items = append(items(nil), n.items...)
+	for _, c := range n.children {
+		out.children = append(out.children, deepCopy(c))
+	}
+	return out
+}
+
+func TestProof(t *testing.T) {
+	tr := New(2, less)
+	tr.EnableHashing(IntCodec, IntCodec)
+	verify := func(k int) error {
+		t.Helper()
+		p, err := tr.Prove(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Verify(tr.RootHash(), less, IntCodec, IntCodec)
+	}
+	if err := verify(1); err != nil {
+		t.Fatalf("empty tree: %v", err)
+	}
+	for i := 0; i < 100; i += 2 {
+		tr.Set(i, i*10)
+	}
+	root := tr.RootHash()
+	for k := -1; k <= 100; k++ {
+		p, err := tr.Prove(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Present != (k >= 0 && k < 100 && k%2 == 0) {
+			t.Fatalf("%d: Present = %t", k, p.Present)
+		}
+		if p.Present && p.Value != k*10 {
+			t.Fatalf("%d: Value = %v", k, p.Value)
+		}
+		if err := p.Verify(root, less, IntCodec, IntCodec); err != nil {
+			t.Fatalf("%d: %v", k, err)
+		}
+	}
+
+	// Tampered proofs fail.
+	for _, tamper := range []func(*Proof){
+		func(p *Proof) { p.Value = 1 },
+		func(p *Proof) { p.Present = false },
+		func(p *Proof) { p.Key = 11 },
+		func(p *Proof) { p.Path = p.Path[:len(p.Path)-1] },
+		func(p *Proof) { p.Path[0].Values[0] = []byte{1} },
+		func(p *Proof) { p.Path[len(p.Path)-1].Keys = p.Path[len(p.Path)-1].Keys[1:] },
+	} {
+		p, err := tr.Prove(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(p)
+		if err := p.Verify(root, less, IntCodec, IntCodec); !errors.Is(err, ErrBadProof) {
+			t.Errorf("tampered proof: got %v, want ErrBadProof", err)
+		}
+	}
+	// A proof is only good for its root.
+	p, _ := tr.Prove(10)
+	tr.Set(3, 3)
+	if err := p.Verify(tr.RootHash(), less, IntCodec, IntCodec); !errors.Is(err, ErrBadProof) {
+		t.Errorf("stale proof: got %v", err)
+	}
+}
@@ -0,0 +1,596 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"reflect"
+)
+
+// Reconciliation finds the differences between two trees held by peers
+// connected by a stream, exchanging little more than the differing items.
+//
+// The peers proceed in lock step, with the initiator driving. First each sends
+// a hello message holding its root hash, if it has hashing enabled. If both
+// hashes are present and equal, the trees are identical and reconciliation is
+// over. Otherwise the initiator starts with the range of all keys and, for each
+// range, sends the number of items it has in the range and their rangeDigest.
+// The responder answers whether its own summary matches. If it does not, the
+// initiator either splits the range at its median key and continues with each
+// half, or, if either side has only a few items in the range, sends its items,
+// and the responder replies with its own. Ranges are visited in key order, so
+// each side finds the differences in ascending order of key.
+//
+// Each message is a type byte, the length of the payload as a uvarint, and the
+// payload.
+
+// A Role is the part a peer plays in Reconcile.
+type Role int
+
+const (
+	// Initiator drives the reconciliation.
+	Initiator Role = iota
+	// Responder answers the initiator.
+	Responder
+)
+
+// A Difference describes a key whose item differs between two trees.
+type Difference struct {
+	Key      Key
+	Local    Value // value in the local tree, if InLocal
+	Remote   Value // value in the remote tree, if InRemote
+	InLocal  bool
+	InRemote bool
+}
+
+// ErrReconcileProtocol is returned by Reconcile when the peer sends an
+// unexpected or malformed message.
+var ErrReconcileProtocol = errors.New("btree: reconcile protocol error")
+
+const (
+	msgHello = iota + 1 // payload: version byte, has-hash byte, root hash
+	msgRange            // payload: lower bound, upper bound, count (uvarint), digest
+	msgEqual            // empty payload
+	msgDiff             // payload: count (uvarint)
+	msgItems            // payload: count (uvarint), then length-prefixed keys and values
+	msgDone             // empty payload
+
+	reconcileVersion = 2
+	// If either side has no more than this many items in a differing range,
+	// the items are exchanged instead of splitting the range further.
+	reconcileLeafItems = 16
+	maxMessageSize     = 64 << 20
+)
+
+// Reconcile runs the reconciliation protocol with a peer over rw, comparing t
+// with the peer's tree. One peer must use the Initiator role and the other the
+// Responder role. Keys and values are encoded with keyCodec and valueCodec,
+// which must be the same on both sides, as must the trees' less functions.
+//
+// Reconcile returns the differences between t and the peer's tree in ascending
+// order of key. It does not modify t, which must not be modified until
+// Reconcile returns.
+//
+// If both trees have hashing enabled (see EnableHashing) and equal root
+// hashes, Reconcile returns after exchanging only the hashes. Otherwise it
+// narrows in on the differing ranges, so the amount of data exchanged depends
+// on the number of differences rather than the size of the trees. If t has
+// hashing enabled with the same codecs, and was not created with NoIndex, the
+// work Reconcile does depends on the number of differences as well; otherwise
+// it reads every item of t at least once.
+func Reconcile(rw io.ReadWriter, t *BTree, keyCodec, valueCodec Codec, role Role) ([]Difference, error) {
+	return newReconciler(rw, t, keyCodec, valueCodec).run(role)
+}
+
+func newReconciler(rw io.ReadWriter, t *BTree, keyCodec, valueCodec Codec) *reconciler {
+	return &reconciler{
+		t:  t,
+		kc: keyCodec,
+		vc: valueCodec,
+		br: bufio.NewReader(rw),
+		bw: bufio.NewWriter(rw),
+		useSums: t.hasher != nil && !t.cow.noIndex &&
+			sameCodec(t.hasher.kc, keyCodec) && sameCodec(t.hasher.vc, valueCodec),
+	}
+}
+
+// sameCodec reports whether a and b are known to be the same codec.
+func sameCodec(a, b Codec) bool {
+	ta := reflect.TypeOf(a)
+	return ta != nil && ta == reflect.TypeOf(b) && ta.Comparable() && a == b
+}
+
+func (r *reconciler) run(role Role) ([]Difference, error) {
+	equal, err := r.hello(role)
+	if err != nil || equal {
+		return nil, err
+	}
+	if role == Initiator {
+		err = r.initiate()
+	} else {
+		err = r.respond()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.diffs, nil
+}
+
+type reconciler struct {
+	t      *BTree
+	kc, vc Codec
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	diffs  []Difference
+	// useSums is true if the nodes of t have sums computed with kc and vc, and
+	// sizes.
+	useSums bool
+	// hashed counts the items hashed to compute range digests, for tests.
+	hashed int
+}
+
+// A keyRange is the range of keys [lo, hi). A nil bound is unbounded.
+type keyRange struct {
+	lo, hi Key
+}
+
+// hello exchanges root hashes, reporting whether they show the trees to be equal.
+func (r *reconciler) hello(role Role) (bool, error) {
+	b := []byte{reconcileVersion, 0}
+	var root [sha256.Size]byte
+	if r.t.hasher != nil {
+		b[1] = 1
+		root = r.t.RootHash()
+	}
+	b = append(b, root[:]...)
+	send := func() error { return r.send(msgHello, b) }
+	recv := func() ([]byte, error) { return r.expect(msgHello) }
+	var p []byte
+	var err error
+	// The initiator speaks first.
+	if role == Initiator {
+		if err = send(); err == nil {
+			p, err = recv()
+		}
+	} else {
+		if p, err = recv(); err == nil {
+			err = send()
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(p) != 2+sha256.Size {
+		return false, fmt.Errorf("%w: bad hello", ErrReconcileProtocol)
+	}
+	if p[0] != reconcileVersion {
+		return false, fmt.Errorf("btree: peer uses reconcile version %d, not %d", p[0], reconcileVersion)
+	}
+	return b[1] == 1 && p[1] == 1 && bytes.Equal(p[2:], root[:]), nil
+}
+
+func (r *reconciler) initiate() error {
+	// Visit ranges depth-first, lower half first, so differences are found in key order.
+	stack := []keyRange{{}}
+	for len(stack) > 0 {
+		kr := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		count, digest, err := r.summarize(kr)
+		if err != nil {
+			return err
+		}
+		var b []byte
+		if b, err = r.appendRange(b, kr); err != nil {
+			return err
+		}
+		b = appendUvarint(b, uint64(count))
+		b = append(b, digest[:]...)
+		if err := r.send(msgRange, b); err != nil {
+			return err
+		}
+		typ, p, err := r.recv()
+		if err != nil {
+			return err
+		}
+		if typ == msgEqual {
+			continue
+		}
+		if typ != msgDiff {
+			return fmt.Errorf("%w: got message type %d in reply to range", ErrReconcileProtocol, typ)
+		}
+		remoteCount, n := binary.Uvarint(p)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad diff message", ErrReconcileProtocol)
+		}
+		if count <= reconcileLeafItems || remoteCount <= reconcileLeafItems {
+			if err := r.exchange(r.rangeItems(kr)); err != nil {
+				return err
+			}
+			continue
+		}
+		// Split at the median local key. Both halves have fewer local items than
+		// the whole, so this terminates.
+		mid := r.median(kr, count)
+		stack = append(stack, keyRange{mid, kr.hi}, keyRange{kr.lo, mid})
+	}
+	return r.send(msgDone, nil)
+}
+
+// exchange sends the local items of a range, receives the remote ones, and
+// records the differences.
+func (r *reconciler) exchange(local []item) error {
+	b, err := r.appendItems(nil, local)
+	if err != nil {
+		return err
+	}
+	if err := r.send(msgItems, b); err != nil {
+		return err
+	}
+	p, err := r.expect(msgItems)
+	if err != nil {
+		return err
+	}
+	return r.compare(local, p)
+}
+
+func (r *reconciler) respond() error {
+	for {
+		typ, p, err := r.recv()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case msgDone:
+			return nil
+		case msgRange:
+			kr, rest, err := r.decodeRange(p)
+			if err != nil {
+				return err
+			}
+			remoteCount, n := binary.Uvarint(rest)
+			if n <= 0 || len(rest[n:]) != sha256.Size {
+				return fmt.Errorf("%w: bad range message", ErrReconcileProtocol)
+			}
+			count, digest, err := r.summarize(kr)
+			if err != nil {
+				return err
+			}
+			if remoteCount == uint64(count) && bytes.Equal(digest[:], rest[n:]) {
+				if err := r.send(msgEqual, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := r.send(msgDiff, appendUvarint(nil, uint64(count))); err != nil {
+				return err
+			}
+			if count > reconcileLeafItems && remoteCount > reconcileLeafItems {
+				// The initiator will split the range.
+				continue
+			}
+			p, err := r.expect(msgItems)
+			if err != nil {
+				return err
+			}
+			local := r.rangeItems(kr)
+			b, err := r.appendItems(nil, local)
+			if err != nil {
+				return err
+			}
+			if err := r.send(msgItems, b); err != nil {
+				return err
+			}
+			if err := r.compare(local, p); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: unexpected message type %d", ErrReconcileProtocol, typ)
+		}
+	}
+}
+
+// A rangeDigest summarizes a set of items. It is the sum, modulo 2^256, of the
+// SHA-256 hashes of the items' length-prefixed encoded keys and values, read as
+// big-endian integers. Unlike a hash of the items in sequence, the digest of a
+// union of disjoint sets is the sum of their digests, and it doesn't depend on
+// the shape of the tree. So a tree with hashing enabled keeps the digest of
+// each subtree in node.sum, and the digest of a range can be assembled from
+// the sums of the subtrees it covers and a few items at its edges.
+//
+// A rangeDigest is not collision-resistant against a peer that chooses its
+// items to defeat it; it detects accidental differences.
+type rangeDigest [sha256.Size]byte
+
+// add adds e to d.
+func (d *rangeDigest) add(e *rangeDigest) {
+	var carry uint64
+	for i := len(d) - 8; i >= 0; i -= 8 {
+		var x uint64
+		x, carry = bits.Add64(binary.BigEndian.Uint64(d[i:]), binary.BigEndian.Uint64(e[i:]), carry)
+		binary.BigEndian.PutUint64(d[i:], x)
+	}
+}
+
+// addItem adds the hash of an item, whose encoded key and value are b, to d.
+func (d *rangeDigest) addItem(b []byte) {
+	h := rangeDigest(sha256.Sum256(b))
+	d.add(&h)
+}
+
+// rangeIterator returns an iterator positioned before the first item of t in kr.
+func (r *reconciler) rangeIterator(kr keyRange) *Iterator {
+	if kr.lo == nil {
+		return r.t.first()
+	}
+	return r.t.Before(kr.lo)
+}
+
+// inRange reports whether k is in kr.
+func (r *reconciler) inRange(k Key, kr keyRange) bool {
+	return (kr.lo == nil || !r.t.less(k, kr.lo)) && (kr.hi == nil || r.t.less(k, kr.hi))
+}
+
+// rangeItems returns the items of t in kr.
+func (r *reconciler) rangeItems(kr keyRange) []item {
+	it := r.rangeIterator(kr)
+	var s []item
+	for it.Next() && r.inRange(it.Key, kr) {
+		s = append(s, item{it.Key, it.Value})
+	}
+	return s
+}
+
+// summarize returns the number of items of t in kr and their digest.
+func (r *reconciler) summarize(kr keyRange) (int, rangeDigest, error) {
+	var d rangeDigest
+	if r.useSums {
+		if r.t.root == nil {
+			return 0, d, nil
+		}
+		n, err := r.summarizeNode(r.t.root, kr, kr.lo == nil, kr.hi == nil, &d)
+		return n, d, err
+	}
+	// Without sums, hash the items while walking them.
+	count := 0
+	it := r.rangeIterator(kr)
+	for it.Next() && r.inRange(it.Key, kr) {
+		if err := r.addItem(&d, it.Key, it.Value); err != nil {
+			return 0, d, err
+		}
+		count++
+	}
+	return count, d, nil
+}
+
+// summarizeNode adds the digest of the items of n's subtree in kr to d, and
+// returns their number. If loIn is true, all the keys of the subtree are known
+// to be at least kr.lo; if hiIn is true, they are all known to be less than
+// kr.hi. It visits only the nodes on the paths to the bounds of kr, using the
+// sums and sizes of the subtrees between them.
+func (r *reconciler) summarizeNode(n *node, kr keyRange, loIn, hiIn bool, d *rangeDigest) (int, error) {
+	if loIn && hiIn {
+		d.add(n.sum)
+		return n.size, nil
+	}
+	less := r.t.less
+	count := 0
+	for i := 0; i <= len(n.items); i++ {
+		if i < len(n.children) {
+			// The keys of child i are at least n.items[i-1] (greater, except in a
+			// B+ tree), and less than n.items[i].
+			below := !loIn && i < len(n.items) && !less(kr.lo, n.items[i].key)
+			above := !hiIn && i > 0 && !less(n.items[i-1].key, kr.hi)
+			if !below && !above {
+				cLoIn := loIn || (i > 0 && !less(n.items[i-1].key, kr.lo))
+				cHiIn := hiIn || (i < len(n.items) && !less(kr.hi, n.items[i].key))
+				c, err := r.summarizeNode(n.children[i], kr, cLoIn, cHiIn, d)
+				if err != nil {
+					return 0, err
+				}
+				count += c
+			}
+		}
+		if i == len(n.items) || (n.cow.bplus && len(n.children) > 0) {
+			continue
+		}
+		if m := n.items[i]; r.inRange(m.key, kr) {
+			if err := r.addItem(d, m.key, m.value); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// addItem adds the hash of the item with key k and value v to d.
+func (r *reconciler) addItem(d *rangeDigest, k Key, v Value) error {
+	b, err := appendField(nil, r.kc, k)
+	if err == nil {
+		b, err = appendField(b, r.vc, v)
+	}
+	if err != nil {
+		return err
+	}
+	d.addItem(b)
+	r.hashed++
+	return nil
+}
+
+// median returns the key of the middle one of the count items of t in kr.
+func (r *reconciler) median(kr keyRange, count int) Key {
+	it := r.rangeIterator(kr)
+	if !r.t.cow.noIndex {
+		it.Next()
+		k, _ := r.t.At(it.Index + count/2)
+		return k
+	}
+	for i := 0; i <= count/2; i++ {
+		it.Next()
+	}
+	return it.Key
+}
+
+// compare records the differences between the local items and the encoded
+// remote items p, both in ascending order.
+func (r *reconciler) compare(local []item, p []byte) error {
+	n, ln := binary.Uvarint(p)
+	if ln <= 0 {
+		return fmt.Errorf("%w: bad items message", ErrReconcileProtocol)
+	}
+	p = p[ln:]
+	i := 0
+	var prev Key
+	for j := uint64(0); j < n; j++ {
+		kb, rest, err := readField(p)
+		if err != nil {
+			return fmt.Errorf("%w: bad items message", ErrReconcileProtocol)
+		}
+		vb, rest, err := readField(rest)
+		if err != nil {
+			return fmt.Errorf("%w: bad items message", ErrReconcileProtocol)
+		}
+		p = rest
+		k, err := r.kc.Decode(kb)
+		if err != nil {
+			return err
+		}
+		if j > 0 && !r.t.less(prev, k) {
+			return fmt.Errorf("%w: items out of order", ErrReconcileProtocol)
+		}
+		prev = k
+		// Local items before k are missing remotely.
+		for ; i < len(local) && r.t.less(local[i].key, k); i++ {
+			r.diffs = append(r.diffs, Difference{Key: local[i].key, Local: local[i].value, InLocal: true})
+		}
+		if i < len(local) && !r.t.less(k, local[i].key) {
+			lb, err := r.vc.Append(nil, local[i].value)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(lb, vb) {
+				v, err := r.vc.Decode(vb)
+				if err != nil {
+					return err
+				}
+				r.diffs = append(r.diffs, Difference{Key: local[i].key, Local: local[i].value, Remote: v, InLocal: true, InRemote: true})
+			}
+			i++
+			continue
+		}
+		v, err := r.vc.Decode(vb)
+		if err != nil {
+			return err
+		}
+		r.diffs = append(r.diffs, Difference{Key: k, Remote: v, InRemote: true})
+	}
+	for ; i < len(local); i++ {
+		r.diffs = append(r.diffs, Difference{Key: local[i].key, Local: local[i].value, InLocal: true})
+	}
+	return nil
+}
+
+func (r *reconciler) appendItems(b []byte, s []item) ([]byte, error) {
+	b = appendUvarint(b, uint64(len(s)))
+	for _, m := range s {
+		var err error
+		if b, err = appendField(b, r.kc, m.key); err != nil {
+			return nil, err
+		}
+		if b, err = appendField(b, r.vc, m.value); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendRange appends kr's bounds, each a byte that is 1 if the bound is
+// present, followed by the encoded key if it is.
+func (r *reconciler) appendRange(b []byte, kr keyRange) ([]byte, error) {
+	for _, k := range []Key{kr.lo, kr.hi} {
+		if k == nil {
+			b = append(b, 0)
+			continue
+		}
+		var err error
+		if b, err = appendField(append(b, 1), r.kc, k); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (r *reconciler) decodeRange(p []byte) (keyRange, []byte, error) {
+	var bounds [2]Key
+	for i := range bounds {
+		if len(p) == 0 {
+			return keyRange{}, nil, fmt.Errorf("%w: bad range", ErrReconcileProtocol)
+		}
+		present := p[0]
+		p = p[1:]
+		if present == 0 {
+			continue
+		}
+		var err error
+		if bounds[i], p, err = decodeField(p, r.kc); err != nil {
+			return keyRange{}, nil, fmt.Errorf("%w: bad range: %v", ErrReconcileProtocol, err)
+		}
+	}
+	return keyRange{bounds[0], bounds[1]}, p, nil
+}
+
+func (r *reconciler) send(typ byte, payload []byte) error {
+	r.bw.WriteByte(typ)
+	r.bw.Write(appendUvarint(nil, uint64(len(payload))))
+	r.bw.Write(payload)
+	return r.bw.Flush()
+}
+
+func (r *reconciler) recv() (byte, []byte, error) {
+	typ, err := r.br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > maxMessageSize {
+		return 0, nil, fmt.Errorf("%w: message of %d bytes is too large", ErrReconcileProtocol, n)
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r.br, p); err != nil {
+		return 0, nil, err
+	}
+	return typ, p, nil
+}
+
+func (r *reconciler) expect(typ byte) ([]byte, error) {
+	got, p, err := r.recv()
+	if err != nil {
+		return nil, err
+	}
+	if got != typ {
+		return nil, fmt.Errorf("%w: got message type %d, want %d", ErrReconcileProtocol, got, typ)
+	}
+	return p, nil
+}
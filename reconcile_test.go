@@ -0,0 +1,210 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// countingConn counts the bytes written through it.
+type countingConn struct {
+	io.ReadWriter
+	n int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return c.ReadWriter.Write(p)
+}
+
+func reconcile(t *testing.T, a, b *BTree) (da, db []Difference, written int) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	cc := &countingConn{ReadWriter: ca}
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		db, err = Reconcile(cb, b, IntCodec, IntCodec, Responder)
+		errc <- err
+	}()
+	da, err := Reconcile(cc, a, IntCodec, IntCodec, Initiator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return da, db, cc.n
+}
+
+func TestReconcile(t *testing.T) {
+	const size = 10000
+	a, b := New(4, less), New(8, less)
+	for _, m := range perm(size) {
+		a.Set(m.Key, m.Value)
+		b.Set(m.Key, m.Value)
+	}
+	da, db, _ := reconcile(t, a, b)
+	if len(da) != 0 || len(db) != 0 {
+		t.Fatalf("equal trees: got differences %v, %v", da, db)
+	}
+
+	var want []Difference
+	for k := 0; k < size+100; k += 997 {
+		d := Difference{Key: k}
+		switch rand.Intn(3) {
+		case 0: // only in a
+			a.Set(k, 1)
+			b.Delete(k)
+		case 1: // only in b
+			a.Delete(k)
+			b.Set(k, 2)
+		default: // different values
+			a.Set(k, 1)
+			b.Set(k, 2)
+		}
+		d.Local, d.InLocal = a.Get(k), a.Has(k)
+		d.Remote, d.InRemote = b.Get(k), b.Has(k)
+		want = append(want, d)
+	}
+	da, db, written := reconcile(t, a, b)
+	if !cmp.Equal(da, want) {
+		t.Errorf("initiator:\ngot  %v\nwant %v", da, want)
+	}
+	for i := range want {
+		w := &want[i]
+		w.Local, w.Remote = w.Remote, w.Local
+		w.InLocal, w.InRemote = w.InRemote, w.InLocal
+	}
+	if !cmp.Equal(db, want) {
+		t.Errorf("responder:\ngot  %v\nwant %v", db, want)
+	}
+	// Far less than the ~3 bytes per item it would take to send the whole tree.
+	if written > size {
+		t.Errorf("initiator wrote %d bytes", written)
+	}
+}
+
+func TestReconcileHashes(t *testing.T) {
+	a := New(4, less)
+	a.EnableHashing(IntCodec, IntCodec)
+	for _, m := range perm(1000) {
+		a.Set(m.Key, m.Value)
+	}
+	b := a.Clone()
+	da, db, written := reconcile(t, a, b)
+	if len(da) != 0 || len(db) != 0 {
+		t.Fatalf("got differences %v, %v", da, db)
+	}
+	if written > 40 {
+		t.Errorf("equal hashes: initiator wrote %d bytes", written)
+	}
+	b.Delete(500)
+	da, _, _ = reconcile(t, a, b)
+	if want := []Difference{{Key: 500, Local: 500, InLocal: true}}; !cmp.Equal(da, want) {
+		t.Errorf("got %v, want %v", da, want)
+	}
+}
+
+// reconcileWork reconciles a and b, and returns the differences found by a, and
+// the number of items each side hashed.
+func reconcileWork(t *testing.T, a, b *BTree) (da []Difference, hashedA, hashedB int) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	ra := newReconciler(ca, a, IntCodec, IntCodec)
+	rb := newReconciler(cb, b, IntCodec, IntCodec)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := rb.run(Responder)
+		errc <- err
+	}()
+	da, err := ra.run(Initiator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return da, ra.hashed, rb.hashed
+}
+
+func TestReconcileWork(t *testing.T) {
+	const size = 100000
+	for _, bplus := range []bool{false, true} {
+		c := new(Counters)
+		a := NewWithOptions(8, less, Options{BPlus: bplus, Counters: c})
+		a.EnableHashing(IntCodec, IntCodec)
+		for i := 0; i < size; i++ {
+			a.Set(i, i)
+		}
+		b := a.Clone()
+		b.Set(size/3, -1)
+		c.Reset()
+		da, hashedA, hashedB := reconcileWork(t, a, b)
+		want := []Difference{{Key: size / 3, Local: size / 3, Remote: -1, InLocal: true, InRemote: true}}
+		if !cmp.Equal(da, want) {
+			t.Errorf("bplus=%t: got %v, want %v", bplus, da, want)
+		}
+		// Each side visits O(log n) ranges, hashing only a few items at the edges
+		// of each, and making O(log n) comparisons to find them.
+		if hashedA > 2000 || hashedB > 2000 {
+			t.Errorf("bplus=%t: hashed %d and %d items of %d", bplus, hashedA, hashedB, size)
+		}
+		if got := c.Counts().Compares; got > size/10 {
+			t.Errorf("bplus=%t: %d comparisons for %d items", bplus, got, size)
+		}
+	}
+}
+
+// TestReconcileMixed checks that range digests from node sums agree with those
+// computed by walking the items.
+func TestReconcileMixed(t *testing.T) {
+	for _, bplus := range []bool{false, true} {
+		a := NewWithOptions(3, less, Options{BPlus: bplus})
+		a.EnableHashing(IntCodec, IntCodec)
+		b := NewWithOptions(5, less, Options{NoIndex: true})
+		for _, m := range perm(2000) {
+			a.Set(m.Key, m.Value)
+			b.Set(m.Key, m.Value)
+		}
+		var want []Difference
+		for k := 0; k < 2000; k += 101 {
+			b.Delete(k)
+			want = append(want, Difference{Key: k, Local: k, InLocal: true})
+		}
+		da, db, _ := reconcile(t, a, b)
+		if !cmp.Equal(da, want) {
+			t.Errorf("bplus=%t: initiator: got %v, want %v", bplus, da, want)
+		}
+		if len(db) != len(want) {
+			t.Errorf("bplus=%t: responder found %d differences, want %d", bplus, len(db), len(want))
+		}
+		// Equal contents with different shapes and different ways of computing
+		// digests.
+		da, _, _ = reconcile(t, b, a.Clone())
+		if len(da) != len(want) {
+			t.Errorf("bplus=%t: reversed roles: got %d differences, want %d", bplus, len(da), len(want))
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// The snapshot format written by WriteTo is:
+//
+//	magic    the 8 bytes "jbabtree"
+//	version  one byte, currently 1
+//	count    uvarint, the number of items
+//	items    count pairs of length-prefixed key and length-prefixed value, in ascending order
+//	checksum big-endian CRC-32 (IEEE) of all preceding bytes
+//
+// Each length prefix is a uvarint.
+const (
+	snapshotMagic   = "jbabtree"
+	snapshotVersion = 1
+)
+
+// ErrCorruptSnapshot is returned by ReadFrom when its input is not a valid snapshot.
+var ErrCorruptSnapshot = errors.New("btree: corrupt snapshot")
+
+// WriteTo writes the contents of t to w in a versioned, checksummed binary format
+// that can be read by ReadFrom. Keys and values are encoded with keyCodec and
+// valueCodec. The structure of the tree is not written.
+//
+// WriteTo is a function rather than a method so that it does not collide with
+// the signature of io.WriterTo.
+func WriteTo(w io.Writer, t *BTree, keyCodec, valueCodec Codec) error {
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	mw := io.MultiWriter(bw, crc)
+	buf := append([]byte(snapshotMagic), snapshotVersion)
+	buf = appendUvarint(buf, uint64(t.Len()))
+	it := t.first()
+	for it.Next() {
+		var err error
+		if buf, err = appendField(buf, keyCodec, it.Key); err != nil {
+			return err
+		}
+		if buf, err = appendField(buf, valueCodec, it.Value); err != nil {
+			return err
+		}
+		if len(buf) >= 4096 {
+			if _, err := mw.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := mw.Write(buf); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err := bw.Write(sum[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadFrom reads a snapshot written by WriteTo and returns a new tree holding its
+// contents. The tree is created with New(degree, less). Its nodes are filled
+// directly from the sorted contents of the snapshot, which is much faster than
+// calling Set for each item.
+//
+// ReadFrom returns an error wrapping ErrCorruptSnapshot if the snapshot is
+// malformed, fails its checksum, or holds keys that are not in ascending order
+// according to less.
+func ReadFrom(r io.Reader, degree int, less func(interface{}, interface{}) bool, keyCodec, valueCodec Codec) (*BTree, error) {
+	t := New(degree, less)
+	cr := &checksumReader{r: bufio.NewReader(r), h: crc32.NewIEEE()}
+	var magic [len(snapshotMagic)]byte
+	if _, err := io.ReadFull(cr, magic[:]); err != nil {
+		return nil, corrupt(err)
+	}
+	if string(magic[:]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic number", ErrCorruptSnapshot)
+	}
+	version, err := cr.ReadByte()
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("btree: unsupported snapshot version %d", version)
+	}
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	// Don't trust count for more than a modest preallocation.
+	s := make([]item, 0, minUint64(count, 1<<16))
+	var buf []byte
+	for i := uint64(0); i < count; i++ {
+		var k, v interface{}
+		if k, buf, err = readCodecField(cr, keyCodec, buf); err != nil {
+			return nil, corrupt(err)
+		}
+		if v, buf, err = readCodecField(cr, valueCodec, buf); err != nil {
+			return nil, corrupt(err)
+		}
+		if len(s) > 0 && !less(s[len(s)-1].key, k) {
+			return nil, fmt.Errorf("%w: keys out of order at item %d", ErrCorruptSnapshot, i)
+		}
+		s = append(s, item{k, v})
+	}
+	want := cr.h.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(cr.r, sum[:]); err != nil {
+		return nil, corrupt(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return nil, fmt.Errorf("%w: bad checksum", ErrCorruptSnapshot)
+	}
+	t.root = t.cow.buildSorted(s, t.maxItems(), t.minItems())
+	return t, nil
+}
+
+// readCodecField reads a length-prefixed field from r and decodes it with c.
+// It uses buf as scratch space and returns it for reuse.
+func readCodecField(r *checksumReader, c Codec, buf []byte) (interface{}, []byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, buf, err
+	}
+	if uint64(cap(buf)) < n {
+		// Grow gradually, so a corrupt length can't cause a huge allocation.
+		buf = make([]byte, 0, minUint64(n, 1<<20))
+	}
+	buf = buf[:0]
+	for uint64(len(buf)) < n {
+		m := minUint64(n-uint64(len(buf)), 1<<20)
+		start := len(buf)
+		buf = append(buf, make([]byte, m)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			return nil, buf, err
+		}
+	}
+	x, err := c.Decode(buf)
+	if err != nil {
+		return nil, buf, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	return x, buf, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// corrupt converts an error encountered while reading a snapshot into
+// the return value of ReadFrom.
+func corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", ErrCorruptSnapshot, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// A checksumReader computes a checksum of the bytes read through it.
+type checksumReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.h.Write([]byte{b})
+	}
+	return b, err
+}
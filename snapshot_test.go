@@ -0,0 +1,80 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, degree := range []int{2, 3, 8} {
+		for _, size := range []int{0, 1, 2, 3, 4, 5, 6, 7, 10, 50, 100, 1000} {
+			t.Run(fmt.Sprintf("degree=%d,size=%d", degree, size), func(t *testing.T) {
+				tr := New(32, less)
+				for _, m := range perm(size) {
+					tr.Set(m.Key, fmt.Sprint(m.Value))
+				}
+				var buf bytes.Buffer
+				if err := WriteTo(&buf, tr, IntCodec, StringCodec); err != nil {
+					t.Fatal(err)
+				}
+				got, err := ReadFrom(&buf, degree, less, IntCodec, StringCodec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkTree(t, got)
+				if !cmp.Equal(all(got.BeforeIndex(0)), all(tr.BeforeIndex(0))) {
+					t.Fatal("contents differ")
+				}
+				// The tree must be usable afterwards.
+				for _, m := range perm(size) {
+					got.Delete(m.Key)
+					checkTree(t, got)
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	tr := New(4, less)
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	var buf bytes.Buffer
+	if err := WriteTo(&buf, tr, IntCodec, IntCodec); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, n := range []int{0, 5, 10, len(data) / 2, len(data) - 1} {
+		if _, err := ReadFrom(bytes.NewReader(data[:n]), 4, less, IntCodec, IntCodec); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("truncated to %d: got %v, want ErrCorruptSnapshot", n, err)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	bad[len(bad)/2] ^= 1
+	if _, err := ReadFrom(bytes.NewReader(bad), 4, less, IntCodec, IntCodec); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("flipped bit: got %v, want ErrCorruptSnapshot", err)
+	}
+	greater := func(a, b interface{}) bool { return a.(int) > b.(int) }
+	if _, err := ReadFrom(bytes.NewReader(data), 4, greater, IntCodec, IntCodec); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("wrong order: got %v, want ErrCorruptSnapshot", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"crypto/sha256"
+	"unsafe"
+)
+
+// FillBuckets is the number of buckets in the fill-factor histogram of a LevelStats.
+const FillBuckets = 10
+
+// Stats describes the shape and memory use of a tree.
+type Stats struct {
+	Height int // number of levels; 0 for an empty tree
+	Nodes  int
+	Leaves int
+	Items  int // the number of items in the tree, as returned by Len
+	// Separators is the number of keys in the internal nodes of a B+ tree.
+	// It is always zero for other trees.
+	Separators int
+	// Shared is the number of nodes that the tree does not own: nodes it
+	// shares with clones, or with a snapshot taken by a Snapshotter. Writing
+	// to a shared node copies it.
+	Shared int
+	// Levels describes each level of the tree, starting with the root.
+	Levels []LevelStats
+
+	// Approximate bytes retained by the tree, not counting the keys and values
+	// themselves. Nodes shared with other trees are counted in full.
+	NodeBytes     int64 // node structs, and their hashes and cached sizes
+	ItemBytes     int64 // backing arrays of the items slices
+	ChildrenBytes int64 // backing arrays of the children slices
+}
+
+// LevelStats describes one level of a tree.
+type LevelStats struct {
+	Nodes int
+	Items int // including separators, in a B+ tree
+	// Fill is a histogram of how full the nodes are. Fill[i] is the number of
+	// nodes holding at least i/FillBuckets and less than (i+1)/FillBuckets of
+	// the maximum number of items. Full nodes are counted in the last bucket.
+	Fill [FillBuckets]int
+}
+
+// Stats returns statistics about t. It takes time proportional to the number
+// of nodes in t.
+func (t *BTree) Stats() Stats {
+	s := Stats{Items: t.Len()}
+	if t.root == nil || t.Len() == 0 {
+		return s
+	}
+	t.root.stats(t, 0, &s)
+	s.Height = len(s.Levels)
+	return s
+}
+
+func (n *node) stats(t *BTree, depth int, s *Stats) {
+	if depth == len(s.Levels) {
+		s.Levels = append(s.Levels, LevelStats{})
+	}
+	l := &s.Levels[depth]
+	l.Nodes++
+	l.Items += len(n.items)
+	b := len(n.items) * FillBuckets / t.maxItems()
+	if b >= FillBuckets {
+		b = FillBuckets - 1
+	}
+	l.Fill[b]++
+	s.Nodes++
+	if len(n.children) == 0 {
+		s.Leaves++
+	} else if t.cow.bplus {
+		s.Separators += len(n.items)
+	}
+	if n.cow != t.cow {
+		s.Shared++
+	}
+	s.NodeBytes += int64(unsafe.Sizeof(*n)) + int64(cap(n.cum))*int64(unsafe.Sizeof(0))
+	if n.hash != nil {
+		s.NodeBytes += 2 * sha256.Size // the hash and the sum
+	}
+	s.ItemBytes += int64(cap(n.items)) * int64(unsafe.Sizeof(item{}))
+	s.ChildrenBytes += int64(cap(n.children)) * int64(unsafe.Sizeof(n))
+	for _, c := range n.children {
+		c.stats(t, depth+1, s)
+	}
+}
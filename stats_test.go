@@ -0,0 +1,125 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStats(t *testing.T) {
+	if got := New(2, less).Stats(); !cmp.Equal(got, Stats{}) {
+		t.Errorf("empty tree: got %+v", got)
+	}
+
+	tr := New(2, less)
+	for _, m := range rang(7) {
+		tr.Set(m.Key, m.Value)
+	}
+	s := tr.Stats()
+	got := s
+	got.Levels = nil
+	got.NodeBytes, got.ItemBytes, got.ChildrenBytes = 0, 0, 0
+	// Check the counts against a walk of the tree.
+	want := Stats{Items: 7}
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if depth+1 > want.Height {
+			want.Height = depth + 1
+		}
+		want.Nodes++
+		if len(n.children) == 0 {
+			want.Leaves++
+		}
+		for _, c := range n.children {
+			walk(c, depth+1)
+		}
+	}
+	walk(tr.root, 0)
+	if !cmp.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	var nodes, items int
+	for _, l := range s.Levels {
+		nodes += l.Nodes
+		items += l.Items
+		var fill int
+		for _, f := range l.Fill {
+			fill += f
+		}
+		if fill != l.Nodes {
+			t.Errorf("level %+v: fill histogram sums to %d", l, fill)
+		}
+	}
+	if nodes != s.Nodes || items != s.Items {
+		t.Errorf("levels have %d nodes and %d items", nodes, items)
+	}
+	if s.Levels[0].Nodes != 1 {
+		t.Errorf("root level has %d nodes", s.Levels[0].Nodes)
+	}
+	if s.NodeBytes == 0 || s.ItemBytes == 0 || s.ChildrenBytes == 0 {
+		t.Errorf("bytes: %+v", s)
+	}
+
+	// A full root of degree 2 (3 items) is in the last fill bucket.
+	full := New(2, less)
+	for i := 0; i < 3; i++ {
+		full.Set(i, i)
+	}
+	if f := full.Stats().Levels[0].Fill; f[FillBuckets-1] != 1 {
+		t.Errorf("full root: fill %v", f)
+	}
+}
+
+func TestStatsShared(t *testing.T) {
+	tr := New(3, less)
+	for _, m := range perm(1000) {
+		tr.Set(m.Key, m.Value)
+	}
+	if got := tr.Stats().Shared; got != 0 {
+		t.Fatalf("before Clone: %d shared", got)
+	}
+	clone := tr.Clone()
+	s := tr.Stats()
+	if s.Shared != s.Nodes {
+		t.Fatalf("after Clone: %d of %d shared", s.Shared, s.Nodes)
+	}
+	clone.Set(0, 1)
+	// Only the path to 0 was copied, along with any nodes split on the way.
+	s = clone.Stats()
+	if got := s.Nodes - s.Shared; got < s.Height || got > 2*s.Height+1 {
+		t.Errorf("after Set: %d unshared, height %d", got, s.Height)
+	}
+}
+
+func TestStatsBPlus(t *testing.T) {
+	tr := newBPlus(3)
+	for _, m := range perm(1000) {
+		tr.Set(m.Key, m.Value)
+	}
+	s := tr.Stats()
+	var leafItems int
+	for _, l := range s.Levels {
+		leafItems = l.Items
+	}
+	if leafItems != 1000 || s.Items != 1000 {
+		t.Errorf("leaf items %d, items %d; want 1000", leafItems, s.Items)
+	}
+	// Each child but the first of an internal node follows a separator.
+	if want := s.Nodes - 1 - (s.Nodes - s.Leaves); s.Separators != want {
+		t.Errorf("%d separators, want %d", s.Separators, want)
+	}
+}
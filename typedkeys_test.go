@@ -0,0 +1,60 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// The key conversions used by the tests of the specialized trees, which are
+// generated from int64tree_test.go. Each converts between an int and a key in
+// a way that preserves order.
+
+func int64Key(i int) int64      { return int64(i) }
+func int64KeyInt(k int64) int   { return int(k) }
+func stringKey(i int) string    { return fmt.Sprintf("%08d", i) }
+func stringKeyInt(k string) int { i, _ := strconv.Atoi(k); return i }
+
+func TestStringTreeFormattedKeys(t *testing.T) {
+	tr := NewStringTree(3)
+	var want []string
+	for _, i := range rand.Perm(1000) {
+		tr.Set(fmt.Sprintf("%04d", i), i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		tr.Delete(fmt.Sprintf("%04d", i))
+	}
+	for i := 1; i < 1000; i += 2 {
+		want = append(want, fmt.Sprintf("%04d", i))
+	}
+	var got []string
+	for it := tr.Before("0500"); it.Next(); {
+		if want := len(got) + 250; it.Index != want {
+			t.Fatalf("%s: got index %d, want %d", it.Key, it.Index, want)
+		}
+		got = append(got, it.Key)
+	}
+	if !cmp.Equal(got, want[250:]) {
+		t.Errorf("got %v, want %v", got, want[250:])
+	}
+	if k, v := tr.At(10); k != "0021" || v != 21 {
+		t.Errorf("At(10) = %q, %v", k, v)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Verify checks that t is a well-formed tree, and returns an error describing
+// the first problem it finds, or nil if there is none. It checks that
+//
+//   - keys are in increasing order, within each node and across the tree;
+//   - every node but the root has between degree-1 and 2*degree-1 items;
+//   - every internal node has one more child than it has items;
+//   - all leaves are at the same depth;
+//   - the sizes that t keeps for indexing agree with the number of items.
+//
+// If Append has split nodes of t unevenly, nodes on the right edge of the tree
+// need only have one item, since Append leaves them underfull. For a tree
+// created with the BPlus option, Verify also checks the links between leaves
+// that the tree relies on.
+//
+// The error names the path to the offending node, as "root" followed by the
+// index of the child taken at each level; for example, "root/2/0" is the first
+// child of the third child of the root.
+//
+// Verify takes time linear in the size of t. It is meant for tests.
+func (t *BTree) Verify() error {
+	if t.root == nil {
+		return nil
+	}
+	v := &verifier{t: t, leafDepth: -1}
+	if len(t.root.items) == 0 && len(t.root.children) > 0 {
+		return v.errorf("has no items but %d children", len(t.root.children))
+	}
+	if err := v.walk(t.root, nil, nil, true); err != nil {
+		return err
+	}
+	if t.cow.noIndex && v.items != t.count {
+		return fmt.Errorf("btree: tree has %d items, but its count is %d", v.items, t.count)
+	}
+	if t.cow.bplus {
+		return v.checkLinks()
+	}
+	return nil
+}
+
+// verifier holds the state of a call to Verify.
+type verifier struct {
+	t         *BTree
+	path      []int // child indexes from the root to the current node
+	leafDepth int
+	items     int
+	leaves    []*node // in a B+ tree
+}
+
+// errorf returns an error about the current node.
+func (v *verifier) errorf(format string, args ...interface{}) error {
+	var b strings.Builder
+	b.WriteString("root")
+	for _, i := range v.path {
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(i))
+	}
+	return fmt.Errorf("btree: node %s: %s", b.String(), fmt.Sprintf(format, args...))
+}
+
+// walk checks the subtree rooted at n, whose keys must be greater than lo
+// (greater than or equal to it, in a B+ tree) and less than hi. A nil bound
+// means the range is unbounded on that side. spine reports whether n is on the
+// right edge of the tree.
+func (v *verifier) walk(n *node, lo, hi *Key, spine bool) error {
+	t := v.t
+	bplus := t.cow.bplus
+	leaf := len(n.children) == 0
+	min := t.minItems()
+	if n == t.root {
+		min = 0
+	} else if spine && t.appended {
+		min = 1
+	}
+	if len(n.items) < min {
+		return v.errorf("has %d items, fewer than %d", len(n.items), min)
+	}
+	if len(n.items) > t.maxItems() {
+		return v.errorf("has %d items, more than %d", len(n.items), t.maxItems())
+	}
+	if !leaf && len(n.children) != len(n.items)+1 {
+		return v.errorf("has %d items but %d children", len(n.items), len(n.children))
+	}
+	for i, m := range n.items {
+		if i > 0 && !t.less(n.items[i-1].key, m.key) {
+			return v.errorf("keys %v and %v at %d and %d are out of order", n.items[i-1].key, m.key, i-1, i)
+		}
+		if lo != nil && (t.less(m.key, *lo) || (!bplus && !t.less(*lo, m.key))) {
+			return v.errorf("key %v at %d is out of order with the separator %v above it", m.key, i, *lo)
+		}
+		if hi != nil && !t.less(m.key, *hi) {
+			return v.errorf("key %v at %d is out of order with the separator %v above it", m.key, i, *hi)
+		}
+	}
+	if leaf {
+		if v.leafDepth < 0 {
+			v.leafDepth = len(v.path)
+		} else if len(v.path) != v.leafDepth {
+			return v.errorf("is a leaf at depth %d, but other leaves are at depth %d", len(v.path), v.leafDepth)
+		}
+		v.items += len(n.items)
+		if bplus {
+			v.leaves = append(v.leaves, n)
+		}
+	} else {
+		if !bplus {
+			v.items += len(n.items)
+		}
+		for i, c := range n.children {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = &n.items[i-1].key
+			}
+			if i < len(n.items) {
+				chi = &n.items[i].key
+			}
+			v.path = append(v.path, i)
+			err := v.walk(c, clo, chi, spine && i == len(n.items))
+			v.path = v.path[:len(v.path)-1]
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return v.checkSize(n)
+}
+
+// checkSize checks the size and cumulative child sizes of n, which are only
+// kept in indexed trees.
+func (v *verifier) checkSize(n *node) error {
+	if v.t.cow.noIndex {
+		return nil
+	}
+	if sz := n.computeSize(); n.size != sz {
+		return v.errorf("has size %d, but holds %d items", n.size, sz)
+	}
+	if len(n.cum) != len(n.children) {
+		return v.errorf("has %d cumulative sizes for %d children", len(n.cum), len(n.children))
+	}
+	sz := 0
+	for i, c := range n.children {
+		sz += c.size
+		if n.cum[i] != sz {
+			return v.errorf("has cumulative size %d for child %d, want %d", n.cum[i], i, sz)
+		}
+	}
+	return nil
+}
+
+// checkLinks checks the leaf links of a B+ tree that the tree trusts, which
+// are those whose ends it both owns (see bplus.go).
+func (v *verifier) checkLinks() error {
+	owned := func(n *node) bool { return n != nil && n.cow == v.t.cow }
+	for i, l := range v.leaves {
+		if !owned(l) || !owned(l.next) {
+			continue
+		}
+		if i == len(v.leaves)-1 {
+			return fmt.Errorf("btree: last leaf links to another leaf")
+		}
+		if l.next != v.leaves[i+1] {
+			return fmt.Errorf("btree: leaf %d does not link to the next leaf", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	// Each test builds a tree of degree 2 holding 0 through 19, which has
+	// four levels, and corrupts it.
+	for _, test := range []struct {
+		name    string
+		bplus   bool
+		corrupt func(tr *BTree)
+		want    string
+	}{
+		{
+			name:    "order within node",
+			corrupt: func(tr *BTree) { c := tr.root.children[1].children[1]; c.items[0], c.items[1] = c.items[1], c.items[0] },
+			want:    "node root/1/1: keys 15 and 13 at 0 and 1 are out of order",
+		},
+		{
+			name:    "lower bound",
+			corrupt: func(tr *BTree) { tr.root.children[1].children[0].children[0].items[0].key = 2 },
+			want:    "node root/1/0/0: key 2 at 0 is out of order with the separator 7 above it",
+		},
+		{
+			name:    "upper bound",
+			corrupt: func(tr *BTree) { tr.root.children[0].children[0].children[0].items[0].key = 4 },
+			want:    "node root/0/0/0: key 4 at 0 is out of order with the separator 1 above it",
+		},
+		{
+			name: "underfull",
+			corrupt: func(tr *BTree) {
+				c := tr.root.children[0].children[1].children[0]
+				c.items = c.items[:0]
+			},
+			want: "node root/0/1/0: has 0 items, fewer than 1",
+		},
+		{
+			name: "overfull",
+			corrupt: func(tr *BTree) {
+				c := tr.root.children[1].children[1].children[3]
+				c.items = append(c.items, item{20, 20}, item{21, 21})
+			},
+			want: "node root/1/1/3: has 4 items, more than 3",
+		},
+		{
+			name:    "children",
+			corrupt: func(tr *BTree) { c := tr.root.children[1].children[1]; c.children = c.children[:2] },
+			want:    "node root/1/1: has 3 items but 2 children",
+		},
+		{
+			name: "leaf depth",
+			corrupt: func(tr *BTree) {
+				tr.root.children[0].children[0] = &node{items: items{{1, 1}}, size: 1, cow: tr.cow}
+			},
+			want: "node root/0/1/0: is a leaf at depth 3, but other leaves are at depth 2",
+		},
+		{
+			name:    "size",
+			corrupt: func(tr *BTree) { tr.root.children[1].children[1].children[3].size++ },
+			want:    "node root/1/1/3: has size 3, but holds 2 items",
+		},
+		{
+			name:    "cum",
+			corrupt: func(tr *BTree) { tr.root.children[0].cum[1]++ },
+			want:    "node root/0: has cumulative size 7 for child 1, want 6",
+		},
+		{
+			name:    "empty root",
+			corrupt: func(tr *BTree) { tr.root.items = tr.root.items[:0] },
+			want:    "node root: has no items but 2 children",
+		},
+		{
+			name:    "B+ lower bound",
+			bplus:   true,
+			corrupt: func(tr *BTree) { tr.root.children[1].children[0].children[1].items[0].key = 2 },
+			want:    "node root/1/0/1: key 2 at 0 is out of order with the separator 5 above it",
+		},
+		{
+			name:  "B+ link",
+			bplus: true,
+			corrupt: func(tr *BTree) {
+				l := tr.root.children[0].children[0].children[0]
+				l.next = l.next.next
+			},
+			want: "leaf 0 does not link to the next leaf",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tr := NewWithOptions(2, less, Options{BPlus: test.bplus})
+			for _, m := range rang(20) {
+				tr.Set(m.Key, m.Value)
+			}
+			if err := tr.Verify(); err != nil {
+				t.Fatalf("before breaking: %v", err)
+			}
+			test.corrupt(tr)
+			err := tr.Verify()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyNoIndex(t *testing.T) {
+	tr := NewWithOptions(2, less, Options{NoIndex: true})
+	for _, m := range perm(100) {
+		tr.Set(m.Key, m.Value)
+	}
+	if err := tr.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	tr.count++
+	if err := tr.Verify(); err == nil || !strings.Contains(err.Error(), "count is 101") {
+		t.Errorf("got %v, want error about the count", err)
+	}
+	if err := New(3, less).Verify(); err != nil {
+		t.Errorf("empty tree: %v", err)
+	}
+}
+
+// TestVerifyAppended checks that Verify allows underfull nodes on the right
+// edge of a tree only if Append has left them there.
+func TestVerifyAppended(t *testing.T) {
+	lastLeaf := func(tr *BTree) *node {
+		n := tr.root
+		for len(n.children) > 0 {
+			n = n.children[len(n.children)-1]
+		}
+		return n
+	}
+
+	tr := New(3, less)
+	for _, m := range rang(20) {
+		tr.Set(m.Key, m.Value)
+	}
+	l := lastLeaf(tr)
+	l.items = l.items[:1]
+	if err := tr.Verify(); err == nil || !strings.Contains(err.Error(), "has 1 items, fewer than 2") {
+		t.Errorf("underfull right edge after Set: got %v, want error about occupancy", err)
+	}
+
+	tr = New(3, less)
+	tr.Append(0, 0)
+	for i := 1; lastLeaf(tr) == tr.root || len(lastLeaf(tr).items) >= tr.minItems(); i++ {
+		if err := tr.Append(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Verify(); err != nil {
+		t.Fatalf("after Append: %v", err)
+	}
+	tr.appended = false
+	if err := tr.Verify(); err == nil || !strings.Contains(err.Error(), "fewer than 2") {
+		t.Errorf("without the appended flag: got %v, want error about occupancy", err)
+	}
+	tr.appended = true
+	tr.Compact(1)
+	if tr.appended {
+		t.Error("Compact did not clear the appended flag")
+	}
+	if err := tr.Verify(); err != nil {
+		t.Errorf("after Compact: %v", err)
+	}
+}
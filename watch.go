@@ -0,0 +1,108 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+// An EventKind describes the change reported by an Event.
+type EventKind int
+
+const (
+	EventInsert EventKind = iota // a key was added to the tree
+	EventUpdate                  // the value of a key already in the tree was replaced
+	EventDelete                  // a key was removed from the tree
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventInsert:
+		return "insert"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// An Event describes a change to a single key in a tree.
+type Event struct {
+	Kind EventKind
+	Key  Key
+	// Old is the value before the change. It is nil for EventInsert.
+	Old Value
+	// New is the value after the change. It is nil for EventDelete.
+	New Value
+}
+
+// A watcher is a registered call to Watch.
+type watcher struct {
+	lo, hi Key // nil means unbounded
+	f      func(Event)
+}
+
+// contains reports whether k is in the range [w.lo, w.hi).
+func (w *watcher) contains(k Key, less lessFunc) bool {
+	if w.lo != nil && less(k, w.lo) {
+		return false
+	}
+	if w.hi != nil && !less(k, w.hi) {
+		return false
+	}
+	return true
+}
+
+// Watch arranges for f to be called whenever a key k with lo <= k < hi is
+// inserted, updated or deleted. If lo is nil, the range has no lower bound;
+// if hi is nil, it has no upper bound.
+//
+// f is called synchronously by the method that made the change, after the tree
+// has been updated. It must not modify the tree.
+//
+// Watch returns a function that removes the watch. Watches are not copied by Clone.
+func (t *BTree) Watch(lo, hi Key, f func(Event)) (cancel func()) {
+	w := &watcher{lo: lo, hi: hi, f: f}
+	t.watchers = append(t.watchers[:len(t.watchers):len(t.watchers)], w)
+	return func() { t.unwatch(w) }
+}
+
+func (t *BTree) unwatch(w *watcher) {
+	// Build a new slice rather than modifying the old one in place, so that a watch
+	// can be cancelled from within a call to notify.
+	var ws []*watcher
+	for _, x := range t.watchers {
+		if x != w {
+			ws = append(ws, x)
+		}
+	}
+	t.watchers = ws
+}
+
+// notifySet reports the result of setting k to v.
+func (t *BTree) notifySet(k Key, v, old Value, present bool) {
+	if present {
+		t.notify(Event{Kind: EventUpdate, Key: k, Old: old, New: v})
+	} else {
+		t.notify(Event{Kind: EventInsert, Key: k, New: v})
+	}
+}
+
+// notify calls the watchers whose ranges include e.Key.
+func (t *BTree) notify(e Event) {
+	for _, w := range t.watchers {
+		if w.contains(e.Key, t.less) {
+			w.f(e)
+		}
+	}
+}
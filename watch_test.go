@@ -0,0 +1,62 @@
+// Copyright 2018 Jonathan Amsterdam (jbamsterdam@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btree
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatch(t *testing.T) {
+	tr := New(2, less)
+	for _, m := range perm(20) {
+		tr.Set(m.Key, m.Value)
+	}
+	var got []Event
+	cancel := tr.Watch(5, 10, func(e Event) { got = append(got, e) })
+	var all []Event
+	tr.Watch(nil, nil, func(e Event) { all = append(all, e) })
+
+	tr.Set(7, 70)        // update, in range
+	tr.Set(10, 100)      // update, out of range (hi is exclusive)
+	tr.Delete(5)         // delete, in range
+	tr.Set(5, 50)        // insert, in range
+	tr.Delete(100)       // not present: no event
+	tr.DeleteMin()       // deletes 0, out of range
+	tr.DeleteMax()       // deletes 19, out of range
+	tr.Clone().Set(6, 0) // clones don't inherit watches
+	want := []Event{
+		{Kind: EventUpdate, Key: 7, Old: 7, New: 70},
+		{Kind: EventDelete, Key: 5, Old: 5},
+		{Kind: EventInsert, Key: 5, New: 50},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	if len(all) != 6 {
+		t.Errorf("got %d events for unbounded watch, want 6", len(all))
+	}
+
+	cancel()
+	got = nil
+	tr.Set(6, 60)
+	if len(got) != 0 {
+		t.Errorf("got events after cancel: %+v", got)
+	}
+	if len(all) != 7 {
+		t.Errorf("unbounded watch: got %d events, want 7", len(all))
+	}
+}